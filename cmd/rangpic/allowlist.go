@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// --- 图床白名单 ---
+
+// allowedImageHosts 来自 ALLOWED_IMAGE_HOSTS（逗号分隔的域名），为空表示不限制。
+// 列出的域名同时允许其所有子域名，例如 example.com 也允许 img.example.com
+var allowedImageHosts []string
+
+// parseHostList 解析逗号分隔的域名列表，统一为小写并去掉首尾的点和 "*." 前缀
+func parseHostList(v string) []string {
+	var hosts []string
+	for _, h := range strings.Split(v, ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		h = strings.Trim(strings.TrimPrefix(h, "*."), ".")
+		if h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// hostAllowed 判断主机名是否在白名单中（与某个域名相同或是其子域名），白名单为空时总是允许
+func hostAllowed(host string) bool {
+	if len(allowedImageHosts) == 0 {
+		return true
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, allowed := range allowedImageHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageURLAllowed 判断远程图片 URL 的主机是否在白名单中，本地图片总是允许
+func imageURLAllowed(imgURL string) bool {
+	if strings.HasPrefix(imgURL, "/local/") || len(allowedImageHosts) == 0 {
+		return true
+	}
+	u, err := url.Parse(imgURL)
+	return err == nil && hostAllowed(u.Hostname())
+}
+
+// errRedirectRejected 表示上游的跳转没有通过 checkImageRedirect，这类失败重试也不会成功
+var errRedirectRejected = errors.New("跳转被拒绝")
+
+// checkImageRedirect 是拉取远程图片的 http.Client 的跳转检查，每一跳都要重新对照白名单，
+// 否则白名单内的图床可以把请求跳转到任意主机
+func checkImageRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("%w：跳转次数过多", errRedirectRejected)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w：只允许跳转到 http 或 https 地址", errRedirectRejected)
+	}
+	if !hostAllowed(req.URL.Hostname()) {
+		return fmt.Errorf("%w：%w：跳转目标 %s 不在 ALLOWED_IMAGE_HOSTS 白名单中", errRedirectRejected, errPolicyRejected, req.URL.Hostname())
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseHostList(t *testing.T) {
+	got := parseHostList(" Example.com, *.imgur.com ,,cdn.test. ")
+	if strings.Join(got, ",") != "example.com,imgur.com,cdn.test" {
+		t.Errorf("parseHostList = %v", got)
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	defer func(v []string) { allowedImageHosts = v }(allowedImageHosts)
+
+	allowedImageHosts = nil
+	if !hostAllowed("anything.test") {
+		t.Error("白名单为空时应允许所有主机")
+	}
+
+	allowedImageHosts = parseHostList("example.com,i.imgur.com")
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com", true},
+		{"example.com.", true},
+		{"img.example.com", true},
+		{"a.b.example.com", true},
+		{"badexample.com", false},
+		{"example.com.evil.test", false},
+		{"i.imgur.com", true},
+		{"imgur.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := hostAllowed(tt.host); got != tt.want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPClientChecksRedirectHosts(t *testing.T) {
+	defer func(v []string, n int) { allowedImageHosts, upstreamRetries = v, n }(allowedImageHosts, upstreamRetries)
+	upstreamRetries = 2
+
+	var targetHits atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targetHits.Add(1)
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+	// 白名单只有 127.0.0.1，同一个服务通过 localhost 访问时主机名不在白名单中
+	allowedImageHosts = []string{"127.0.0.1"}
+	outside := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+
+	var sourceHits atomic.Int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sourceHits.Add(1)
+		dest := target.URL
+		if r.URL.Path == "/outside" {
+			dest = outside
+		}
+		http.Redirect(w, r, dest+"/img", http.StatusFound)
+	}))
+	defer source.Close()
+
+	resp, err := getWithRetry(context.Background(), httpClient, source.URL+"/inside")
+	if err != nil {
+		t.Fatalf("跳转到白名单内的主机应成功: %v", err)
+	}
+	resp.Body.Close()
+	if targetHits.Load() != 1 {
+		t.Fatalf("目标服务收到 %d 次请求，want 1", targetHits.Load())
+	}
+
+	sourceHits.Store(0)
+	_, err = getWithRetry(context.Background(), httpClient, source.URL+"/outside")
+	if !errors.Is(err, errRedirectRejected) || !errors.Is(err, errPolicyRejected) {
+		t.Fatalf("跳转到白名单之外的主机应作为访问策略拒绝，got %v", err)
+	}
+	if targetHits.Load() != 1 {
+		t.Error("被拒绝的跳转目标不应收到请求")
+	}
+	if sourceHits.Load() != 1 {
+		t.Errorf("被拒绝的跳转不应重试，源服务收到 %d 次请求", sourceHits.Load())
+	}
+}
+
+func TestCheckImageURLAllowlistIsPolicyRejection(t *testing.T) {
+	defer func(h []string) { allowedImageHosts = h }(allowedImageHosts)
+	allowedImageHosts = []string{"example.com"}
+	// check-links 据此把不在白名单中的图片记为跳过而不是失效
+	if err := checkImageURL(context.Background(), "https://other.test/a.png"); !errors.Is(err, errPolicyRejected) {
+		t.Errorf("不在白名单中的主机应作为访问策略拒绝，got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- 审计日志 ---
+
+// auditPageSize 是审计日志页面每页显示的条数
+const auditPageSize = 50
+
+// maxAuditDetail 是 detail 列保存的最大字符数，超出部分截断
+const maxAuditDetail = 500
+
+// auditTargetKeys 按优先级列出表示操作对象的表单字段，第一个非空的值记为 target
+var auditTargetKeys = []string{"id", "ids", "file_name", "old_name", "tag", "from", "url"}
+
+// auditSecretKeys 是不写入审计日志的表单字段
+var auditSecretKeys = map[string]bool{"csrf_token": true, "password": true}
+
+// AuditEntry 是审计日志中的一条记录
+type AuditEntry struct {
+	ID        int64
+	CreatedAt time.Time
+	Actor     string
+	IP        string
+	Action    string
+	Target    string
+	Detail    string
+	Status    int
+}
+
+// AuditPageData 是审计日志页面的数据
+type AuditPageData struct {
+	Entries    []AuditEntry
+	Page       int
+	TotalPages int
+}
+
+// sessionActor 返回会话令牌对应的操作者标识。只保存令牌哈希的前 8 位，足以区分不同会话，
+// 又不会让能读到审计日志的人拿到可用的令牌
+func sessionActor(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "session:" + hex.EncodeToString(sum[:4])
+}
+
+// recordAudit 写入一条审计日志。写入是尽力而为的：失败只记录错误日志，不影响已经完成的操作
+func recordAudit(r *http.Request, actor, action, target, detail string, status int) {
+	// 请求可能已被客户端取消，审计写入不跟随请求的取消
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+	_, err := dbpool.Exec(ctx,
+		"INSERT INTO audit_log (actor, ip, action, target, detail, status) VALUES ($1, $2, $3, $4, $5, $6)",
+		actor, clientIP(r), action, target, truncateRunes(detail, maxAuditDetail), status)
+	if err != nil {
+		requestLogger(r).Error("写入审计日志失败", "action", action, "err", err)
+	}
+}
+
+// auditRequest 根据处理完成的后台请求写入审计日志，只记录修改数据且成功（状态码小于 400）的请求。
+// 表单只读取处理函数已经解析过的内容，请求体不会被再次读取；处理函数通过 addAuditValues 补充的字段一并记录
+func auditRequest(r *http.Request, actor string, status int) {
+	if csrfSafeMethod(r.Method) || status >= http.StatusBadRequest {
+		return
+	}
+	values := url.Values{}
+	for k, vs := range r.URL.Query() {
+		values[k] = append(values[k], vs...)
+	}
+	for k, vs := range r.PostForm {
+		values[k] = append(values[k], vs...)
+	}
+	if extra, ok := r.Context().Value(auditValuesKey{}).(url.Values); ok {
+		for k, vs := range extra {
+			values[k] = append(values[k], vs...)
+		}
+	}
+	if r.MultipartForm != nil {
+		for k, files := range r.MultipartForm.File {
+			for _, f := range files {
+				values[k] = append(values[k], f.Filename)
+			}
+		}
+	}
+	recordAudit(r, actor, r.Method+" "+r.URL.Path, auditTarget(values), auditDetail(values), status)
+}
+
+// auditValuesKey 是请求上下文中补充审计字段的键，见 withAuditValues
+type auditValuesKey struct{}
+
+// withAuditValues 为请求附加一个可由处理函数补充审计字段的容器。请求体是 JSON 的接口没有表单可供审计，
+// 由处理函数通过 addAuditValues 记录实际写入的内容
+func withAuditValues(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), auditValuesKey{}, url.Values{}))
+}
+
+// addAuditValues 为当前请求的审计日志补充字段，字段名与表单字段一样参与 target 和 detail 的生成；
+// 请求未经过 authMiddleware 时不做任何事
+func addAuditValues(r *http.Request, key string, vs ...string) {
+	if values, ok := r.Context().Value(auditValuesKey{}).(url.Values); ok {
+		values[key] = append(values[key], vs...)
+	}
+}
+
+// auditTarget 返回表单中第一个非空的操作对象字段，多个值以逗号连接
+func auditTarget(values url.Values) string {
+	for _, k := range auditTargetKeys {
+		var parts []string
+		for _, v := range values[k] {
+			if v = strings.TrimSpace(v); v != "" {
+				parts = append(parts, v)
+			}
+		}
+		if len(parts) > 0 {
+			return truncateRunes(strings.Join(parts, ","), maxAuditDetail)
+		}
+	}
+	return ""
+}
+
+// auditDetail 把表单字段按名称排序后格式化为 "名称=值" 列表，去掉 CSRF 令牌和密码
+func auditDetail(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if !auditSecretKeys[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, k+"="+strings.Join(values[k], ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// truncateRunes 把字符串截断到最多 n 个字符，截断时末尾加省略号
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
+
+// adminAuditHandler 分页列出审计日志，最新的在前
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	data := AuditPageData{Page: page}
+
+	var total int
+	if err := dbpool.QueryRow(r.Context(), "SELECT COUNT(*) FROM audit_log").Scan(&total); err != nil {
+		http.Error(w, "无法获取审计日志", http.StatusInternalServerError)
+		return
+	}
+	data.TotalPages = max(1, (total+auditPageSize-1)/auditPageSize)
+
+	rows, err := dbpool.Query(r.Context(),
+		"SELECT id, created_at, actor, COALESCE(ip, ''), action, COALESCE(target, ''), COALESCE(detail, ''), status FROM audit_log ORDER BY id DESC LIMIT $1 OFFSET $2",
+		auditPageSize, (page-1)*auditPageSize)
+	if err != nil {
+		http.Error(w, "无法获取审计日志", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.IP, &e.Action, &e.Target, &e.Detail, &e.Status); err != nil {
+			requestLogger(r).Error("扫描审计日志失败", "err", err)
+			continue
+		}
+		data.Entries = append(data.Entries, e)
+	}
+	render(w, "audit.html", data)
+}
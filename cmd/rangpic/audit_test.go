@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditDetailOmitsSecrets(t *testing.T) {
+	values := url.Values{"url": {"/local/a.png"}, "csrf_token": {"tok"}, "password": {"pw"}, "image_type": {"a", "b"}}
+	if got, want := auditDetail(values), "image_type=a,b url=/local/a.png"; got != want {
+		t.Errorf("auditDetail = %q, want %q", got, want)
+	}
+	if got := auditTarget(url.Values{"url": {"u"}, "id": {" ", "3"}}); got != "3" {
+		t.Errorf("auditTarget 应优先取 id 中非空的值，got %q", got)
+	}
+}
+
+func TestAddImageWritesAuditRow(t *testing.T) {
+	testDB(t)
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+	if err := os.WriteFile(filepath.Join(localImagesPath, "a.png"), testPNG(t, 4, 4, color.White), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	token, _, err := createSession(ctx)
+	if err != nil {
+		t.Fatalf("createSession: %v", err)
+	}
+	csrf, _, err := lookupSession(ctx, token)
+	if err != nil {
+		t.Fatalf("lookupSession: %v", err)
+	}
+
+	h := authMiddleware(http.HandlerFunc(adminAddImageHandler))
+	add := func() int {
+		r := postForm("/admin/add", url.Values{"url": {"/local/a.png"}, "image_type": {"cats"}, "csrf_token": {csrf}})
+		r.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		r.RemoteAddr = "192.0.2.9:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := add(); code != http.StatusFound {
+		t.Fatalf("添加图片 status = %d, want %d", code, http.StatusFound)
+	}
+	// 重复添加返回 409，失败的请求不写审计日志
+	if code := add(); code != http.StatusConflict {
+		t.Fatalf("重复添加 status = %d, want %d", code, http.StatusConflict)
+	}
+
+	rows, err := dbpool.Query(ctx, "SELECT actor, ip, action, target, detail, status FROM audit_log ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.Actor, &e.IP, &e.Action, &e.Target, &e.Detail, &e.Status); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("应只有 1 条审计日志，got %d: %+v", len(entries), entries)
+	}
+	e := entries[0]
+	if e.Actor != sessionActor(token) || e.IP != "192.0.2.9" || e.Action != "POST /admin/add" || e.Target != "/local/a.png" || e.Status != http.StatusFound {
+		t.Errorf("审计日志内容不正确: %+v", e)
+	}
+	if strings.Contains(e.Detail, csrf) || !strings.Contains(e.Detail, "image_type=cats") {
+		t.Errorf("detail = %q，应包含表单字段且不含 CSRF 令牌", e.Detail)
+	}
+}
+
+func TestAddAuditValues(t *testing.T) {
+	// 没有经过 authMiddleware 的请求没有容器，调用不应出错
+	addAuditValues(httptest.NewRequest(http.MethodPost, "/api/images", nil), "url", "x")
+
+	r := withAuditValues(httptest.NewRequest(http.MethodPost, "/api/images", nil))
+	addAuditValues(r, "url", "https://example.com/a.jpg", "https://example.com/b.jpg")
+	addAuditValues(r, "inserted", "2")
+	values := r.Context().Value(auditValuesKey{}).(url.Values)
+	if got, want := auditTarget(values), "https://example.com/a.jpg,https://example.com/b.jpg"; got != want {
+		t.Errorf("auditTarget = %q, want %q", got, want)
+	}
+	if got := auditDetail(values); !strings.Contains(got, "inserted=2") {
+		t.Errorf("auditDetail = %q", got)
+	}
+}
+
+func TestBatchAddWritesAuditRow(t *testing.T) {
+	testDB(t)
+	defer func(v string) { apiToken = v }(apiToken)
+	apiToken = "secret"
+	insertTestImage(t, "https://example.com/existing.jpg")
+
+	body := `[{"url": "https://example.com/new.jpg"}, {"url": "https://example.com/existing.jpg"}]`
+	r := httptest.NewRequest(http.MethodPost, "/api/images", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	authMiddleware(http.HandlerFunc(batchAddImagesHandler)).ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var e AuditEntry
+	if err := dbpool.QueryRow(context.Background(), "SELECT actor, action, target, detail FROM audit_log").Scan(&e.Actor, &e.Action, &e.Target, &e.Detail); err != nil {
+		t.Fatalf("应写入一条审计日志: %v", err)
+	}
+	// 只记录实际插入的 URL，已存在而被跳过的不计入 target
+	if e.Actor != "api-token" || e.Action != "POST /api/images" || e.Target != "https://example.com/new.jpg" {
+		t.Errorf("审计日志内容不正确: %+v", e)
+	}
+	if !strings.Contains(e.Detail, "inserted=1") || !strings.Contains(e.Detail, "skipped=1") {
+		t.Errorf("detail = %q，应包含插入和跳过的数量", e.Detail)
+	}
+}
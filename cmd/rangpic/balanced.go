@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- 按分类均衡选择 ---
+
+// balanced=1 时分两步选择：先在候选图片的标签（分类）中选出一个，每个分类的权重为其图片数的倒数，
+// 再在带该标签的图片中按原有规则选一张。分类越小越容易被选中，只有 1 张图片的分类不会被大分类淹没
+
+// balancedCountsTTL 是分类图片数缓存的有效期。统计要展开候选图片的全部标签，缓存后每种条件每个周期只统计一次，
+// 代价是新增或删空的分类最多晚一个周期才会反映到均衡选择中
+const balancedCountsTTL = time.Minute
+
+// categoryCounts 为每种过滤条件缓存各分类的图片数，结构与 pickPool 相同
+type categoryCounts struct {
+	mu      sync.Mutex
+	entries map[string]*categoryCountsEntry
+}
+
+type categoryCountsEntry struct {
+	mu        sync.Mutex
+	counts    map[string]int
+	fetchedAt time.Time
+}
+
+// balancedCounts 是 chooseBalancedImage 使用的分类图片数缓存
+var balancedCounts = newCategoryCounts()
+
+func newCategoryCounts() *categoryCounts {
+	return &categoryCounts{entries: make(map[string]*categoryCountsEntry)}
+}
+
+// categoryKey 只包含影响分类统计的条件；AvoidID 最多让一个分类少一张图片，不单独统计
+func categoryKey(f imageFilter) string {
+	return fmt.Sprintf("%s\x00%t\x00%s\x00%t", strings.Join(f.Tags, ","), f.MatchAny, strings.Join(f.Exclude, ","), f.Starred)
+}
+
+// get 返回满足过滤条件的各分类图片数，缓存过期时重新统计
+func (c *categoryCounts) get(ctx context.Context, f imageFilter) (map[string]int, error) {
+	key := categoryKey(f)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		if len(c.entries) >= maxPickPoolEntries {
+			c.entries = make(map[string]*categoryCountsEntry)
+		}
+		entry = &categoryCountsEntry{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	// 统计期间持有 entry 的锁，同一条件下的并发请求等待这一次查询而不是各自查询
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if time.Since(entry.fetchedAt) >= balancedCountsTTL {
+		counts, err := queryCategoryCounts(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		entry.counts = counts
+		entry.fetchedAt = time.Now()
+	}
+	return entry.counts, nil
+}
+
+// balancedCountsQuery 返回统计各分类图片数的 SQL 和参数。分类来自满足过滤条件的图片上的所有标签：
+// match=any 时只统计查询的标签；否则去掉查询的标签，因为所有候选图片都带有它们，选中它们等于不做均衡
+func balancedCountsQuery(f imageFilter) (string, []interface{}) {
+	f.AvoidID = 0
+	where, args := randomFilterClause(f)
+	query := `SELECT tag, COUNT(*) FROM (SELECT LOWER(t) AS tag FROM images, unnest(tags) AS t` + where + `) AS categories`
+	if len(f.Tags) > 0 {
+		args = append(args, f.Tags)
+		if f.MatchAny {
+			query += fmt.Sprintf(" WHERE tag = ANY($%d::text[])", len(args))
+		} else {
+			query += fmt.Sprintf(" WHERE tag <> ALL($%d::text[])", len(args))
+		}
+	}
+	return query + " GROUP BY tag", args
+}
+
+func queryCategoryCounts(ctx context.Context, f imageFilter) (map[string]int, error) {
+	query, args := balancedCountsQuery(f)
+	rows, err := readQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var n int
+		if err := rows.Scan(&tag, &n); err != nil {
+			return nil, err
+		}
+		counts[tag] = n
+	}
+	return counts, rows.Err()
+}
+
+// pickBalancedTag 以图片数的倒数为权重选出一个分类，没有分类时返回空字符串。做法与 weightedRandomOrder 相同：
+// 每个分类的排序键为 -ln(1-u)·图片数，取最小者时被选中的概率与图片数成反比。
+// seed 非空时 u 由分类和种子的 SHA-256 哈希得到，相同的种子和数据总会选出同一个分类
+func pickBalancedTag(counts map[string]int, seed string) string {
+	best, bestKey := "", math.Inf(1)
+	for tag, n := range counts {
+		u := rand.Float64()
+		if seed != "" {
+			sum := sha256.Sum256([]byte(tag + "\x00" + seed))
+			u = float64(binary.BigEndian.Uint64(sum[:])>>11) / (1 << 53)
+		}
+		key := -math.Log(1-u) * float64(n)
+		// 排序键相同时按标签决定，使带种子的选择不受 map 遍历顺序影响
+		if key < bestKey || key == bestKey && tag < best {
+			best, bestKey = tag, key
+		}
+	}
+	return best
+}
+
+// chooseBalancedImage 先按分类大小的倒数选出一个分类，再在该分类中选择图片。候选图片都没有可用的分类标签，
+// 或选中的分类已被删空（缓存尚未过期）时，退回到普通的选择方式
+func chooseBalancedImage(ctx context.Context, f imageFilter) (Image, error) {
+	plain := f
+	plain.Balanced = false
+
+	counts, err := balancedCounts.get(ctx, f)
+	if err != nil {
+		return Image{}, err
+	}
+	tag := pickBalancedTag(counts, f.Seed)
+	if tag == "" {
+		return chooseRandomImageOnce(ctx, plain)
+	}
+
+	inner := plain
+	if f.MatchAny {
+		inner.Tags, inner.MatchAny = []string{tag}, false
+	} else {
+		inner.Tags = append(slices.Clone(f.Tags), tag)
+	}
+	img, err := chooseRandomImageOnce(ctx, inner)
+	if errors.Is(err, errNoImageFound) {
+		return chooseRandomImageOnce(ctx, plain)
+	}
+	return img, err
+}
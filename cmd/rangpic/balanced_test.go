@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPickBalancedTagInverseSize(t *testing.T) {
+	if tag := pickBalancedTag(nil, ""); tag != "" {
+		t.Errorf("没有分类时应返回空字符串，got %q", tag)
+	}
+
+	counts := map[string]int{"solo": 1, "mid": 10, "big": 100}
+	const trials = 100000
+	picked := map[string]int{}
+	for i := 0; i < trials; i++ {
+		picked[pickBalancedTag(counts, "")]++
+	}
+	// 权重为图片数的倒数：1 : 0.1 : 0.01
+	total := 1 + 0.1 + 0.01
+	for tag, n := range counts {
+		want := 1 / float64(n) / total
+		if got := float64(picked[tag]) / trials; math.Abs(got-want) > 0.005 {
+			t.Errorf("%s 被选中的比例为 %.4f，期望约 %.4f", tag, got, want)
+		}
+	}
+}
+
+func TestPickBalancedTagSeeded(t *testing.T) {
+	counts := map[string]int{"a": 1, "b": 1, "c": 1}
+	seen := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		seed := strconv.Itoa(i)
+		tag := pickBalancedTag(counts, seed)
+		for j := 0; j < 5; j++ {
+			if again := pickBalancedTag(counts, seed); again != tag {
+				t.Fatalf("种子 %s 两次选出不同的分类: %s, %s", seed, tag, again)
+			}
+		}
+		seen[tag] = true
+	}
+	if len(seen) != len(counts) {
+		t.Errorf("不同的种子应能选出每个分类，got %v", seen)
+	}
+}
+
+func TestBalancedCountsQuery(t *testing.T) {
+	query, args := balancedCountsQuery(imageFilter{Tags: []string{"cat", "dog"}, MatchAny: true, AvoidID: 7, Balanced: true})
+	if !strings.Contains(query, "GROUP BY tag") || strings.Contains(query, "DISTINCT") {
+		t.Errorf("应按分类分组统计: %s", query)
+	}
+	if !strings.Contains(query, "tag = ANY($2::text[])") || len(args) != 2 {
+		t.Errorf("match=any 时只统计查询的标签: %s %v", query, args)
+	}
+	// AvoidID 不影响统计，同一条件的请求共用一份缓存
+	if strings.Contains(query, "id <>") {
+		t.Errorf("统计不应排除上一次返回的图片: %s", query)
+	}
+	if query, _ := balancedCountsQuery(imageFilter{Tags: []string{"desktop"}}); !strings.Contains(query, "tag <> ALL($2::text[])") {
+		t.Errorf("match=all 时应去掉查询的标签: %s", query)
+	}
+}
+
+func TestBalancedReachesOneImageCategory(t *testing.T) {
+	testDB(t)
+	defer func(c *categoryCounts) { balancedCounts = c }(balancedCounts)
+	balancedCounts = newCategoryCounts()
+	for i := 0; i < 30; i++ {
+		insertTestImage(t, "https://example.com/big"+strconv.Itoa(i)+".jpg", "big")
+	}
+	solo := insertTestImage(t, "https://example.com/solo.jpg", "solo")
+
+	ctx := context.Background()
+	f := imageFilter{Balanced: true}
+	hits := 0
+	for i := 0; i < 50; i++ {
+		img, err := chooseBalancedImage(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.ID == solo {
+			hits++
+		}
+	}
+	// solo 分类的权重是 big 的 30 倍，约 97% 的请求应选中它
+	if hits < 35 {
+		t.Errorf("只有 1 张图片的分类在 50 次中只出现 %d 次", hits)
+	}
+
+	// 缓存中的分类已被删空时退回普通选择
+	if _, err := dbpool.Exec(ctx, "UPDATE images SET deleted_at = now() WHERE id = $1", solo); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		img, err := chooseBalancedImage(ctx, f)
+		if err != nil || img.ID == solo {
+			t.Fatalf("删空的分类应退回普通选择，got %+v, %v", img, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// --- 批量下载 ---
+
+const (
+	// maxBulkDownloadURLs 是一次批量下载最多接受的 URL 数量
+	maxBulkDownloadURLs = 100
+	// bulkDownloadConcurrency 是批量下载时同时进行的下载数
+	bulkDownloadConcurrency = 4
+)
+
+// bulkDownloadResult 是批量下载中单个 URL 的结果，成功时 FileName 为保存的相对文件名，失败时 Err 为原因
+type bulkDownloadResult struct {
+	URL      string
+	FileName string
+	Err      string
+}
+
+// BulkDownloadPageData 是批量下载结果页面的数据
+type BulkDownloadPageData struct {
+	Results   []bulkDownloadResult
+	Succeeded int
+	Failed    int
+}
+
+// parseURLLines 把多行文本拆成 URL 列表，去掉首尾空白并跳过空行
+func parseURLLines(text string) []string {
+	var urls []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			urls = append(urls, line)
+		}
+	}
+	return urls
+}
+
+// adminBulkDownloadHandler 把 urls 文本框中每行一个的地址下载到本地素材库的子目录 dir，
+// 同时进行至多 bulkDownloadConcurrency 个下载，全部完成后显示每个 URL 的结果。
+// 每个地址都经过与单个下载相同的 SSRF 校验，某个地址失败不影响其他地址
+func adminBulkDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	urls := parseURLLines(r.FormValue("urls"))
+	if len(urls) == 0 {
+		http.Error(w, "URL 不能为空", http.StatusBadRequest)
+		return
+	}
+	if len(urls) > maxBulkDownloadURLs {
+		http.Error(w, fmt.Sprintf("一次最多下载 %d 个 URL", maxBulkDownloadURLs), http.StatusBadRequest)
+		return
+	}
+	dir, err := localSubdir(r.FormValue("dir"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]bulkDownloadResult, len(urls))
+	slots := make(chan struct{}, bulkDownloadConcurrency)
+	var wg sync.WaitGroup
+	for i, rawURL := range urls {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			results[i].URL = rawURL
+			u, err := validateDownloadURL(r.Context(), rawURL)
+			if err != nil {
+				results[i].Err = "不允许下载该地址: " + err.Error()
+				return
+			}
+			if results[i].FileName, err = downloadToLocal(r.Context(), u, dir); err != nil {
+				results[i].Err = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	data := BulkDownloadPageData{Results: results}
+	for _, res := range results {
+		if res.Err == "" {
+			data.Succeeded++
+		} else {
+			data.Failed++
+		}
+	}
+	requestLogger(r).Info("批量下载完成", "dir", dir, "succeeded", data.Succeeded, "failed", data.Failed)
+	render(w, "bulk_download.html", data)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// --- 远程图片内存缓存 ---
+
+// imageCache 是按 URL 索引的 LRU 字节缓存，总大小不超过 maxBytes，条目超过 ttl 后视为失效
+type imageCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	ttl      time.Duration
+	size     int64
+	ll       *list.List // 最近使用的条目在前
+	items    map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key         string
+	contentType string
+	data        []byte
+	storedAt    time.Time
+}
+
+// remoteImageCache 为 nil 表示未启用缓存
+var remoteImageCache *imageCache
+
+func newImageCache(maxBytes int64, ttl time.Duration) *imageCache {
+	return &imageCache{maxBytes: maxBytes, ttl: ttl, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get 返回未过期的缓存内容，命中时把条目移到最前
+func (c *imageCache) Get(key string) (contentType string, data []byte, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return "", nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Since(e.storedAt) > c.ttl {
+		c.removeElement(el)
+		return "", nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e.contentType, e.data, true
+}
+
+// Put 写入缓存并淘汰最久未使用的条目；单个条目超过总容量时不缓存
+func (c *imageCache) Put(key, contentType string, data []byte) {
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, contentType: contentType, data: data, storedAt: time.Now()})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *imageCache) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*cacheEntry)
+	delete(c.items, e.key)
+	c.size -= int64(len(e.data))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImageCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newImageCache(10, time.Minute)
+	c.Put("a", "image/png", make([]byte, 4))
+	c.Put("b", "image/png", make([]byte, 4))
+	// 访问 a 后，最久未使用的是 b
+	if _, _, ok := c.Get("a"); !ok {
+		t.Fatal("a 应命中")
+	}
+	c.Put("c", "image/png", make([]byte, 4))
+
+	if _, _, ok := c.Get("b"); ok {
+		t.Error("超出容量时应淘汰最久未使用的 b")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, _, ok := c.Get(key); !ok {
+			t.Errorf("%s 不应被淘汰", key)
+		}
+	}
+	if c.size != 8 {
+		t.Errorf("size = %d, want 8", c.size)
+	}
+}
+
+func TestImageCacheReplaceAndOversize(t *testing.T) {
+	c := newImageCache(10, time.Minute)
+	c.Put("a", "image/png", make([]byte, 4))
+	c.Put("a", "image/jpeg", make([]byte, 6))
+	if ct, data, ok := c.Get("a"); !ok || ct != "image/jpeg" || len(data) != 6 || c.size != 6 {
+		t.Errorf("重复写入应替换旧条目，got %q %d %v size=%d", ct, len(data), ok, c.size)
+	}
+
+	c.Put("big", "image/png", make([]byte, 11))
+	if _, _, ok := c.Get("big"); ok {
+		t.Error("超过总容量的条目不应被缓存")
+	}
+	if _, _, ok := c.Get("a"); !ok {
+		t.Error("不缓存的大条目不应挤掉已有条目")
+	}
+}
+
+func TestImageCacheTTL(t *testing.T) {
+	c := newImageCache(10, time.Minute)
+	c.Put("a", "image/png", make([]byte, 4))
+	c.items["a"].Value.(*cacheEntry).storedAt = time.Now().Add(-2 * time.Minute)
+	if _, _, ok := c.Get("a"); ok {
+		t.Error("过期条目不应命中")
+	}
+	if c.size != 0 || c.ll.Len() != 0 {
+		t.Errorf("过期条目应在读取时移除，size=%d len=%d", c.size, c.ll.Len())
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// --- 主色调 ---
+
+// dominantColor 返回图片的主色调，格式为 #rrggbb。先把图片缩小，再把像素按每通道 16 级归入色桶，
+// 取像素最多的色桶内所有像素的平均色；几乎透明的像素不参与统计，全部透明时返回空字符串
+func dominantColor(img image.Image) string {
+	small := downscale(img, 64)
+	type bucket struct {
+		r, g, b, n int
+	}
+	buckets := make(map[int]*bucket)
+	var best *bucket
+	bounds := small.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := small.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			// RGBA 返回预乘 alpha 的 16 位值，还原为不透明时的 8 位颜色
+			r, g, b = r*0xff/a, g*0xff/a, b*0xff/a
+			key := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			bk := buckets[key]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.r += int(r)
+			bk.g += int(g)
+			bk.b += int(b)
+			bk.n++
+			if best == nil || bk.n > best.n {
+				best = bk
+			}
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.n, best.g/best.n, best.b/best.n)
+}
+
+// parseHexColor 解析 RRGGBB 或 #RRGGBB 格式的颜色，返回规范化的 #rrggbb 和三个通道的值
+func parseHexColor(s string) (string, [3]int, error) {
+	var rgb [3]int
+	hex := strings.ToLower(strings.TrimPrefix(s, "#"))
+	if len(hex) != 6 {
+		return "", rgb, fmt.Errorf("颜色必须是 RRGGBB 格式的十六进制值")
+	}
+	for i := range rgb {
+		v, err := strconv.ParseUint(hex[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return "", rgb, fmt.Errorf("颜色必须是 RRGGBB 格式的十六进制值")
+		}
+		rgb[i] = int(v)
+	}
+	return "#" + hex, rgb, nil
+}
+
+// nearColorBucket 是 near_color 排序时把颜色距离分档的宽度。同一档内的图片仍按权重随机排序，
+// 避免每次都返回颜色最接近的同一张图片
+const nearColorBucket = 32
+
+// nearColorOrder 返回按与目标颜色的 RGB 欧氏距离分档排序的 ORDER BY 表达式，没有颜色的图片排在最后；
+// first 为三个通道参数中第一个的序号
+func nearColorOrder(first int) string {
+	var terms []string
+	for i := range 3 {
+		channel := fmt.Sprintf("('x' || substr(color, %d, 2))::bit(8)::int", 2+2*i)
+		terms = append(terms, fmt.Sprintf("(%s - $%d) * (%s - $%d)", channel, first+i, channel, first+i))
+	}
+	return fmt.Sprintf(`CASE WHEN color ~ '^#[0-9a-f]{6}$' THEN FLOOR(SQRT(%s) / %d) ELSE 1000 END`, strings.Join(terms, " + "), nearColorBucket)
+}
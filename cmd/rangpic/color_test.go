@@ -0,0 +1,83 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"regexp"
+	"testing"
+)
+
+// solidImage 返回 w×h 的纯色图片
+func solidImage(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestDominantColor(t *testing.T) {
+	// 大部分为蓝色，左上角一小块为红色
+	mixed := solidImage(100, 100, color.RGBA{0, 0, 255, 255})
+	draw.Draw(mixed, image.Rect(0, 0, 20, 20), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name string
+		img  image.Image
+		want string
+	}{
+		{"纯红", solidImage(100, 50, color.RGBA{255, 0, 0, 255}), "#ff0000"},
+		{"纯色", solidImage(7, 300, color.RGBA{0x33, 0x66, 0x99, 255}), "#336699"},
+		{"灰度图", image.NewGray(image.Rect(0, 0, 10, 10)), "#000000"},
+		{"全透明", solidImage(10, 10, color.RGBA{}), ""},
+		{"占多数的颜色", mixed, "#0000ff"},
+	}
+	for _, tt := range tests {
+		if got := dominantColor(tt.img); got != tt.want {
+			t.Errorf("%s: dominantColor = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		rgb     [3]int
+		wantErr bool
+	}{
+		{"#FF8000", "#ff8000", [3]int{255, 128, 0}, false},
+		{"00ff7f", "#00ff7f", [3]int{0, 255, 127}, false},
+		{"#fff", "", [3]int{}, true},
+		{"gg0000", "", [3]int{}, true},
+		{"+10000", "", [3]int{}, true},
+		{"", "", [3]int{}, true},
+	}
+	for _, tt := range tests {
+		got, rgb, err := parseHexColor(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHexColor(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (got != tt.want || rgb != tt.rgb) {
+			t.Errorf("parseHexColor(%q) = %q %v, want %q %v", tt.in, got, rgb, tt.want, tt.rgb)
+		}
+	}
+}
+
+func TestNearColorOrderPlaceholders(t *testing.T) {
+	expr := nearColorOrder(4)
+	counts := make(map[string]int)
+	for _, m := range regexp.MustCompile(`\$\d+`).FindAllString(expr, -1) {
+		counts[m]++
+	}
+	// 每个通道的参数在平方项中出现两次，不引用其他参数
+	if len(counts) != 3 || counts["$4"] != 2 || counts["$5"] != 2 || counts["$6"] != 2 {
+		t.Errorf("nearColorOrder(4) 引用的参数 = %v: %s", counts, expr)
+	}
+	for i, offset := range []string{"2", "4", "6"} {
+		p := regexp.MustCompile(`substr\(color, ` + offset + `, 2\)\)::bit\(8\)::int - \$(\d+)`).FindStringSubmatch(expr)
+		if p == nil || p[1] != string(rune('4'+i)) {
+			t.Errorf("第 %d 个通道没有与参数 $%d 比较: %s", i+1, 4+i, expr)
+		}
+	}
+}
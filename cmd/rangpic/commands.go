@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// --- 命令行子命令 ---
+
+// runCommand 执行 `rangpic <命令>` 形式的一次性任务，数据库已连接并完成初始化，执行完毕后进程退出
+func runCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "backfill":
+		// 同步补算所有缺少尺寸、大小或 blurhash 的图片，无法获取或解码的图片记为 0
+		n := fillMissingMetadata(ctx)
+		slog.Info("元数据回填完成", "images", n)
+		return nil
+	case "import":
+		if len(args) != 2 {
+			return fmt.Errorf("用法: rangpic import <文件>")
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sum, err := importURLList(ctx, f)
+		if err != nil {
+			return err
+		}
+		fmt.Println(sum)
+		return nil
+	case "check-links":
+		return runCheckLinks(ctx, args[1:])
+	case "normalize-tags":
+		n, err := normalizeStoredTags(ctx)
+		if err != nil {
+			return err
+		}
+		slog.Info("标签规范化完成", "images", n)
+		return nil
+	default:
+		return fmt.Errorf("未知命令 %q，可用命令: backfill, import, check-links, normalize-tags", args[0])
+	}
+}
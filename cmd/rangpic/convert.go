@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// --- 下载格式转换 ---
+
+// convertDownloadsTo 来自 CONVERT_DOWNLOADS_TO，为目标图片类型（如 image/jpeg），为空时下载的图片保持原格式
+var convertDownloadsTo string
+
+// convertTargets 是 CONVERT_DOWNLOADS_TO 可取的值及对应的图片类型
+var convertTargets = map[string]string{
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// parseConvertTarget 解析 CONVERT_DOWNLOADS_TO，空字符串表示不转换。当前构建不支持 WebP 编码时拒绝 webp
+func parseConvertTarget(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	target, ok := convertTargets[v]
+	if !ok {
+		return "", errors.New("CONVERT_DOWNLOADS_TO 只能是 jpeg、png 或 webp")
+	}
+	if target == "image/webp" && !webpSupported {
+		return "", errors.New("当前构建未启用 cgo，不支持转换为 WebP")
+	}
+	return target, nil
+}
+
+// convertImage 把图片解码后重新编码为 target 类型。JPEG 源图会先按 EXIF 方向转正；
+// 转为 JPEG 时透明区域铺白色底。多帧 GIF 转换后会丢失动画，返回错误由调用方保存原图
+func convertImage(data []byte, target string) ([]byte, error) {
+	if g, err := gif.DecodeAll(bytes.NewReader(data)); err == nil && len(g.Image) > 1 {
+		return nil, errors.New("不转换 GIF 动图")
+	}
+	src, err := decodeImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("无法解码图片: %w", err)
+	}
+	if detectImageType(data, "") == "image/jpeg" {
+		src = applyOrientation(src, jpegOrientation(data))
+	}
+
+	var buf bytes.Buffer
+	switch target {
+	case "image/jpeg":
+		// JPEG 不支持透明，直接编码时透明像素会变成黑色
+		dst := image.NewRGBA(src.Bounds())
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Over)
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 90})
+	case "image/png":
+		err = png.Encode(&buf, src)
+	case "image/webp":
+		return encodeWebP(src)
+	default:
+		return nil, fmt.Errorf("不支持的目标格式 %s", target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
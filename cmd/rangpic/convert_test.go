@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestConvertPNGToJPEG(t *testing.T) {
+	// 左半不透明红色，右半全透明
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			src.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var in bytes.Buffer
+	if err := png.Encode(&in, src); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := convertImage(in.Bytes(), "image/jpeg")
+	if err != nil {
+		t.Fatalf("convertImage: %v", err)
+	}
+	if got := detectImageType(out, ""); got != "image/jpeg" {
+		t.Fatalf("输出类型 = %q, want image/jpeg", got)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("输出无法按 JPEG 解码: %v", err)
+	}
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 8 {
+		t.Errorf("尺寸 = %v, want 16x8", img.Bounds())
+	}
+	// JPEG 有损，颜色只比较大致范围
+	if r, g, b, _ := img.At(2, 4).RGBA(); r>>8 < 200 || g>>8 > 60 || b>>8 > 60 {
+		t.Errorf("不透明区域应保持红色，got %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+	if r, g, b, _ := img.At(13, 4).RGBA(); r>>8 < 240 || g>>8 < 240 || b>>8 < 240 {
+		t.Errorf("透明区域应铺白色底，got %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestConvertImageRejects(t *testing.T) {
+	if _, err := convertImage([]byte("not an image"), "image/jpeg"); err == nil {
+		t.Error("无法解码的数据应返回错误")
+	}
+	if _, err := convertImage(testPNG(t, 2, 2, color.White), "image/bmp"); err == nil {
+		t.Error("不支持的目标格式应返回错误")
+	}
+	if _, err := convertImage(testAnimatedGIF(t, 4, 4), "image/png"); err == nil {
+		t.Error("GIF 动图不应被转换")
+	}
+}
+
+func TestParseConvertTarget(t *testing.T) {
+	for in, want := range map[string]string{"": "", "jpg": "image/jpeg", "jpeg": "image/jpeg", "png": "image/png"} {
+		if got, err := parseConvertTarget(in); err != nil || got != want {
+			t.Errorf("parseConvertTarget(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseConvertTarget("bmp"); err == nil {
+		t.Error("bmp 应被拒绝")
+	}
+	if _, err := parseConvertTarget("webp"); (err == nil) != webpSupported {
+		t.Errorf("webp: err = %v, webpSupported = %v", err, webpSupported)
+	}
+}
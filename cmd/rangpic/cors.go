@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// --- 跨域访问 ---
+
+// allowedOrigins 来自 ALLOWED_ORIGINS（逗号分隔），为空表示不发送任何 CORS 头，包含 "*" 表示允许任意来源
+var allowedOrigins []string
+
+func parseOrigins(v string) []string {
+	var origins []string
+	for _, o := range strings.Split(v, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// allowOrigin 返回应写入 Access-Control-Allow-Origin 的值，不允许时返回空字符串
+func allowOrigin(origin string) string {
+	for _, o := range allowedOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// corsMiddleware 为公开接口添加 CORS 头并响应 OPTIONS 预检请求，只包裹公开的只读接口，后台路由不使用
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := allowOrigin(origin)
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Expose-Headers", "X-Image-Id, X-Cache")
+			if allowed != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+				if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+					w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+				}
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	defer func(o []string) { allowedOrigins = o }(allowedOrigins)
+	allowedOrigins = parseOrigins("https://site.example.com/, https://other.example.com")
+	called := false
+	h := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/random-image", nil)
+	req.Header.Set("Origin", "https://site.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "X-Requested-With")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if called {
+		t.Error("预检请求不应转给处理函数")
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "https://site.example.com",
+		"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS",
+		"Access-Control-Allow-Headers": "X-Requested-With",
+		"Vary":                         "Origin",
+	}
+	for k, v := range want {
+		if got := rec.Header().Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("未允许的来源不应得到 CORS 头: %v", rec.Header())
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	defer func(o []string) { allowedOrigins = o }(allowedOrigins)
+	allowedOrigins = parseOrigins("*")
+	h := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+	req.Header.Set("Origin", "https://any.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Vary") != "" {
+		t.Errorf("ALLOWED_ORIGINS=* 时应返回 * 且不需要 Vary: %v", rec.Header())
+	}
+	if rec.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Error("应暴露 X-Image-Id 等响应头")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidCSRFToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		form     string
+		expected string
+		want     bool
+	}{
+		{"缺少令牌", "", "", "tok", false},
+		{"表单字段匹配", "", "tok", "tok", true},
+		{"请求头匹配", "tok", "", "tok", true},
+		{"令牌不匹配", "other", "", "tok", false},
+		{"会话没有令牌", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/admin/delete", strings.NewReader(url.Values{"csrf_token": {tt.form}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				r.Header.Set("X-CSRF-Token", tt.header)
+			}
+			if got := validCSRFToken(r, tt.expected); got != tt.want {
+				t.Errorf("validCSRFToken = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRejectsPostWithoutCSRFToken(t *testing.T) {
+	testDB(t)
+	token, _, err := createSession(context.Background())
+	if err != nil {
+		t.Fatalf("createSession: %v", err)
+	}
+	called := false
+	h := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	r := postForm("/admin/delete", url.Values{"id": {"1"}})
+	r.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if called {
+		t.Error("缺少 CSRF 令牌的请求不应到达处理函数")
+	}
+
+	// 只读请求不需要令牌
+	r = httptest.NewRequest(http.MethodGet, "/admin", nil)
+	r.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if !called {
+		t.Errorf("GET 请求没有到达处理函数，status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// --- data URI 输出 ---
+
+// maxDataURIBytes 限制 /api/random-image.datauri 返回的图片大小，base64 编码后响应会再大三分之一
+const maxDataURIBytes = 2 << 20
+
+// randomImageDataURIHandler 随机选择一张图片，以 text/plain 返回 data:<类型>;base64,<内容>，
+// 供无法直接引用外部图片的页面内嵌使用。过滤参数与 /api/random-image 相同，图片超过 maxDataURIBytes 时返回 413
+func randomImageDataURIHandler(w http.ResponseWriter, r *http.Request) {
+	randomImageRequests.WithLabelValues("datauri").Inc()
+	filter, err := parseImageFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	img, err := pickRandomImage(r.Context(), filter)
+	if errors.Is(err, errNoImageFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("随机选择图片失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	if !imageURLAllowed(img.URL) {
+		requestLogger(r).Warn("图片地址不在图床白名单中", "image_id", img.ID, "url", img.URL)
+		http.Error(w, "图片地址不在允许的图床列表中", http.StatusBadGateway)
+		return
+	}
+
+	data, err := fetchImageBytesLimit(r.Context(), img.URL, maxDataURIBytes)
+	if errors.Is(err, errImageTooLarge) {
+		http.Error(w, fmt.Sprintf("图片超过 %d KB，无法以 data URI 返回", maxDataURIBytes>>10), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("读取图片失败", "image_id", img.ID, "url", img.URL, "err", err)
+		http.Error(w, "无法获取图片", http.StatusBadGateway)
+		return
+	}
+
+	requestLogger(r).Info("提供 data URI", "tags", filter.Tags, "image_id", img.ID, "url", img.URL)
+	w.Header().Set("X-Image-Id", strconv.Itoa(img.ID))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	fmt.Fprintf(w, "data:%s;base64,%s", http.DetectContentType(data), base64.StdEncoding.EncodeToString(data))
+}
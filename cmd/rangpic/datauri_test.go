@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchImageBytesLimit(t *testing.T) {
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+	small, big := bytes.Repeat([]byte("a"), 10), bytes.Repeat([]byte("b"), 11)
+	os.WriteFile(filepath.Join(localImagesPath, "small.png"), small, 0o644)
+	os.WriteFile(filepath.Join(localImagesPath, "big.png"), big, 0o644)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Write(small)
+		case "/big":
+			w.Write(big)
+		case "/chunked":
+			// 先刷新响应头，响应不带 Content-Length，只能边读边判断
+			w.(http.Flusher).Flush()
+			w.Write(big)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		url     string
+		want    []byte
+		tooBig  bool
+		comment string
+	}{
+		{"/local/small.png", small, false, "本地文件未超限"},
+		{"/local/big.png", nil, true, "本地文件按大小拒绝"},
+		{srv.URL + "/small", small, false, "远程图片未超限"},
+		{srv.URL + "/big", nil, true, "Content-Length 超限"},
+		{srv.URL + "/chunked", nil, true, "长度未知时读取超限"},
+	}
+	for _, tt := range tests {
+		got, err := fetchImageBytesLimit(context.Background(), tt.url, 10)
+		if tt.tooBig {
+			if !errors.Is(err, errImageTooLarge) {
+				t.Errorf("%s: err = %v, want errImageTooLarge", tt.comment, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, %v", tt.comment, got, err)
+		}
+	}
+}
+
+func TestRandomImageDataURIRoundTrip(t *testing.T) {
+	testDB(t)
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+	data := testPNG(t, 3, 3, color.RGBA{10, 20, 30, 255})
+	os.WriteFile(filepath.Join(localImagesPath, "a.png"), data, 0o644)
+	os.WriteFile(filepath.Join(localImagesPath, "huge.png"), make([]byte, maxDataURIBytes+1), 0o644)
+	insertTestImage(t, "/local/a.png", "small")
+	insertTestImage(t, "/local/huge.png", "huge")
+
+	rec := httptest.NewRecorder()
+	randomImageDataURIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/random-image.datauri?tag=small", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	encoded, ok := strings.CutPrefix(rec.Body.String(), "data:image/png;base64,")
+	if !ok {
+		t.Fatalf("响应不是 PNG 的 data URI: %.40q", rec.Body.String())
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("解码后的内容与原图不同: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	randomImageDataURIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/random-image.datauri?tag=huge", nil))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("超过上限的图片 status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
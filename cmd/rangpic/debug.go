@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// --- 调试接口（仅 DEBUG=1 时注册）---
+
+// pickExplanation 描述一次随机选择的完整过程
+type pickExplanation struct {
+	Image      *Image        `json:"image"`
+	Candidates int           `json:"candidates"`
+	Strategy   string        `json:"strategy"`
+	Exclusions []string      `json:"exclusions"`
+	SQL        string        `json:"sql"`
+	Params     []interface{} `json:"params"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// adminDebugPickHandler 按与 /api/random-image 相同的参数执行一次选择，并返回候选数量、
+// 使用的策略以及实际执行的 SQL。只读，不经过预选池，因此不会影响线上请求拿到的结果。
+func adminDebugPickHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseImageFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exp := pickExplanation{Strategy: "order_by_weighted_random", Exclusions: pickExclusions(filter)}
+	switch {
+	case filter.Balanced:
+		// 第二步在选中的分类中按下面其他策略选择，这里只展示统计分类图片数的 SQL（结果缓存 balancedCountsTTL）
+		exp.Strategy = "balanced_by_inverse_tag_size"
+		exp.SQL, exp.Params = balancedCountsQuery(filter)
+	case useIDSeek(filter):
+		exp.Strategy = "id_seek"
+		exp.SQL, exp.Params = idSeekQuery, []interface{}{"rand.Intn(MAX(id)) + 1", filter.AvoidID}
+	case filter.NearColor != "":
+		exp.Strategy = "order_by_color_distance"
+		exp.SQL, exp.Params = randomImageQuery(filter, 1)
+	case filter.Fresh:
+		exp.Strategy = "order_by_fresh_decay"
+		exp.SQL, exp.Params = randomImageQuery(filter, 1)
+	case filter.Seed != "":
+		exp.Strategy = "order_by_seeded_hash"
+		exp.SQL, exp.Params = randomImageQuery(filter, 1)
+	case filter.MinWidth > 0:
+		exp.Strategy = "order_by_width_preference"
+		exp.SQL, exp.Params = randomImageQuery(filter, 1)
+	default:
+		exp.SQL, exp.Params = randomImageQuery(filter, 1)
+	}
+	if randomPool != nil && filter.Seed == "" && !filter.Balanced {
+		exp.Strategy += " (线上请求经由预选池)"
+	}
+
+	where, args := randomFilterClause(filter)
+	if err := dbpool.QueryRow(r.Context(), "SELECT COUNT(*) FROM images"+where, args...).Scan(&exp.Candidates); err != nil {
+		exp.Error = err.Error()
+	} else if img, err := chooseRandomImage(r.Context(), filter); err != nil {
+		exp.Error = err.Error()
+	} else {
+		exp.Image = &img
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	json.NewEncoder(w).Encode(exp)
+}
+
+// pickExclusions 列出这次选择排除候选图片的条件，与 randomFilterClause 生成的 WHERE 条件对应
+func pickExclusions(f imageFilter) []string {
+	exclusions := []string{
+		"weight > 0: 权重为 0 的图片不参与随机",
+		"deleted_at IS NULL: 回收站中的图片",
+	}
+	if len(f.Exclude) > 0 {
+		exclusions = append(exclusions, "exclude: 带有标签 "+strings.Join(f.Exclude, ", ")+" 的图片")
+	}
+	if f.Starred {
+		exclusions = append(exclusions, "starred: 未收藏的图片")
+	}
+	if f.AvoidID > 0 {
+		exclusions = append(exclusions, fmt.Sprintf("last: 上一次返回的图片 %d（只剩这一张时仍会返回）", f.AvoidID))
+	}
+	return exclusions
+}
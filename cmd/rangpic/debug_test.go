@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPickExclusions(t *testing.T) {
+	f, err := parseImageFilter(url.Values{"exclude": {"NSFW,draft"}, "last": {"42"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(pickExclusions(f), "\n")
+	for _, want := range []string{"weight > 0", "deleted_at IS NULL", "nsfw, draft", "42"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("排除条件缺少 %q:\n%s", want, got)
+		}
+	}
+
+	if got := strings.Join(pickExclusions(imageFilter{Starred: true}), "\n"); !strings.Contains(got, "starred") {
+		t.Errorf("starred=1 时应列出未收藏的图片:\n%s", got)
+	}
+	if got := pickExclusions(imageFilter{}); len(got) != 2 {
+		t.Errorf("没有过滤参数时应只有权重和回收站两项，got %v", got)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// --- 下载地址校验 ---
+
+// allowPrivateDownload 为 true 时允许从内网、回环和链路本地地址下载，仅用于可信的内网部署
+var allowPrivateDownload bool
+
+// cgnatRange 是运营商级 NAT 使用的共享地址段，net.IP.IsPrivate 不包含它
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPrivateIP 判断地址是否属于不应从服务端访问的内网、回环、链路本地或保留范围
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnatRange.Contains(ip)
+}
+
+// errPolicyRejected 表示目标地址因访问策略（如内网地址）被拒绝而没有请求，
+// 与地址本身无法访问区分开
+var errPolicyRejected = errors.New("访问策略不允许")
+
+// validateDownloadURL 要求下载地址为 http/https，并拒绝解析到内网地址的主机，防止 SSRF
+func validateDownloadURL(ctx context.Context, rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("URL 格式错误: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("只允许下载 http 或 https 地址")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return nil, errors.New("URL 缺少主机名")
+	}
+	if !hostAllowed(host) {
+		return nil, fmt.Errorf("%w：主机 %s 不在 ALLOWED_IMAGE_HOSTS 白名单中", errPolicyRejected, host)
+	}
+	if allowPrivateDownload {
+		return u, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析主机 %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return nil, fmt.Errorf("%w：主机 %s 解析到内网地址 %s", errPolicyRejected, host, addr.IP)
+		}
+	}
+	return u, nil
+}
+
+// downloadClient 用于下载管理员提交的 URL。除了预先校验外，建立连接时再次检查目标 IP，
+// 防止跳转或 DNS 重绑定绕过 validateDownloadURL
+var downloadClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				if allowPrivateDownload {
+					return nil
+				}
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+					return fmt.Errorf("%w：拒绝连接内网地址 %s", errPolicyRejected, host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: checkImageRedirect,
+}
+
+// --- 下载到本地素材库 ---
+
+// errDownloadTooLarge 表示下载的文件超过了 MAX_DOWNLOAD_BYTES
+var errDownloadTooLarge = errors.New("文件超过下载大小上限")
+
+// cappedReader 读取超过 limit 字节时返回 errDownloadTooLarge，而不是像 io.LimitReader 那样静默截断
+type cappedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	if c.read > c.limit {
+		return n, fmt.Errorf("%w（%d 字节）", errDownloadTooLarge, c.limit)
+	}
+	return n, err
+}
+
+// downloadToLocal 下载已通过 validateDownloadURL 校验的图片，按实际类型确定扩展名后保存到素材库的子目录 dir，
+// 返回保存的相对文件名。JPEG 会按 EXIF 方向转正，并在后台预热缩略图
+func downloadToLocal(ctx context.Context, u *url.URL, dir string) (string, error) {
+	resp, err := getWithRetry(ctx, downloadClient, u.String())
+	if err != nil {
+		return "", fmt.Errorf("下载失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("下载失败，源站返回状态码: %d", resp.StatusCode)
+	}
+	// 源站给出的长度已经超限时不必开始下载；没有给出或给错长度时由 cappedReader 在读取时限制
+	var src io.Reader = resp.Body
+	if maxDownloadBytes > 0 {
+		if resp.ContentLength > maxDownloadBytes {
+			return "", fmt.Errorf("%w（%d 字节）", errDownloadTooLarge, maxDownloadBytes)
+		}
+		src = &cappedReader{r: resp.Body, limit: maxDownloadBytes}
+	}
+
+	// 先读取文件头判断真实的图片类型，用于确定扩展名
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("下载失败: %w", err)
+	}
+	head = head[:n]
+	contentType := detectImageType(head, resp.Header.Get("Content-Type"))
+	var body io.Reader = io.MultiReader(bytes.NewReader(head), src)
+
+	// 设置了 CONVERT_DOWNLOADS_TO 时先读入内存转换格式，无法转换的图片按原格式保存
+	if convertDownloadsTo != "" && contentType != "" && contentType != convertDownloadsTo {
+		data, err := io.ReadAll(io.LimitReader(body, maxDecodeBytes+1))
+		if err != nil {
+			return "", fmt.Errorf("下载失败: %w", err)
+		}
+		body = bytes.NewReader(data)
+		if len(data) > maxDecodeBytes {
+			slog.Warn("图片过大，跳过格式转换", "url", u.String())
+			body = io.MultiReader(body, src)
+		} else if out, err := convertImage(data, convertDownloadsTo); err != nil {
+			slog.Warn("无法转换图片格式，保存原图", "url", u.String(), "from", contentType, "to", convertDownloadsTo, "err", err)
+		} else {
+			body, contentType = bytes.NewReader(out), convertDownloadsTo
+		}
+	}
+
+	// 不覆盖素材库中已有的同名文件，重名时 createLocalFile 会追加随机后缀
+	outFile, fileName, err := createLocalFile(path.Join(dir, downloadFileName(u, contentType)))
+	if err != nil {
+		return "", fmt.Errorf("无法在本地创建文件: %w", err)
+	}
+	defer outFile.Close()
+	localPath := outFile.Name()
+
+	_, err = io.Copy(outFile, body)
+	if err == nil {
+		err = outFile.Close()
+	}
+	if err != nil {
+		// 不保留写了一半的文件
+		outFile.Close()
+		os.Remove(localPath)
+		return "", fmt.Errorf("保存文件失败: %w", err)
+	}
+	// 手机拍摄的照片常依赖 EXIF 方向标签，转正后保存，避免在不读取该标签的地方显示成横的
+	if _, err := fixJPEGOrientation(localPath); err != nil {
+		slog.Warn("校正图片方向失败", "file", fileName, "err", err)
+	}
+	warmupThumbnails(fileName)
+	return fileName, nil
+}
+
+// --- 下载文件命名 ---
+
+// preferredExtensions 为常见图片类型指定固定的扩展名，mime.ExtensionsByType 返回的顺序依赖系统的 mime 表
+var preferredExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// detectImageType 优先根据文件头判断图片类型，无法识别时再参考响应的 Content-Type，都不是图片时返回空字符串
+func detectImageType(head []byte, header string) string {
+	if sniffed := http.DetectContentType(head); strings.HasPrefix(sniffed, "image/") {
+		return sniffed
+	}
+	if mediaType, _, err := mime.ParseMediaType(header); err == nil && strings.HasPrefix(mediaType, "image/") {
+		return mediaType
+	}
+	return ""
+}
+
+// extensionForType 返回图片类型对应的扩展名，未知类型返回空字符串
+func extensionForType(contentType string) string {
+	if ext, ok := preferredExtensions[contentType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// downloadFileName 根据 URL 路径和实际图片类型确定保存的文件名：
+// 路径没有文件名时使用 UUID，扩展名与实际类型不符时替换为正确的扩展名
+func downloadFileName(u *url.URL, contentType string) string {
+	ext := extensionForType(contentType)
+	base := filepath.Base(u.Path)
+	if base == "." || base == "/" {
+		if ext == "" {
+			ext = ".jpg"
+		}
+		return uuid.NewString() + ext
+	}
+	if ext == "" {
+		return base
+	}
+	oldExt := filepath.Ext(base)
+	if oldType, _, err := mime.ParseMediaType(mime.TypeByExtension(oldExt)); err == nil && oldType == contentType {
+		return base
+	}
+	return strings.TrimSuffix(base, oldExt) + ext
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateDownloadURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://93.184.216.34/a.jpg", true},
+		{"http://8.8.8.8:8080/a.png", true},
+		{"file:///etc/passwd", false},
+		{"ftp://93.184.216.34/a.jpg", false},
+		{"http:///a.jpg", false},
+		{"http://127.0.0.1/a.jpg", false},
+		{"http://localhost/a.jpg", false},
+		{"http://[::1]/a.jpg", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://10.0.0.5/a.jpg", false},
+		{"http://192.168.1.1/a.jpg", false},
+		{"http://100.64.0.1/a.jpg", false},
+		{"http://0.0.0.0/a.jpg", false},
+		{"http://[fe80::1]/a.jpg", false},
+	}
+	for _, tt := range tests {
+		_, err := validateDownloadURL(context.Background(), tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateDownloadURLAllowPrivate(t *testing.T) {
+	defer func(v bool) { allowPrivateDownload = v }(allowPrivateDownload)
+	allowPrivateDownload = true
+	if _, err := validateDownloadURL(context.Background(), "http://127.0.0.1/a.jpg"); err != nil {
+		t.Errorf("ALLOW_PRIVATE_DOWNLOAD=1 时应允许内网地址: %v", err)
+	}
+	if _, err := validateDownloadURL(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("ALLOW_PRIVATE_DOWNLOAD=1 时仍只允许 http/https")
+	}
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.0.1", "169.254.169.254", "100.127.255.255", "::1", "fd00::1", "224.0.0.1"} {
+		if !isPrivateIP(net.ParseIP(ip)) {
+			t.Errorf("%s 应视为内网地址", ip)
+		}
+	}
+	for _, ip := range []string{"93.184.216.34", "100.128.0.1", "2606:4700::1111"} {
+		if isPrivateIP(net.ParseIP(ip)) {
+			t.Errorf("%s 不应视为内网地址", ip)
+		}
+	}
+}
+
+// testImageServer 按路径返回预设的内容，Content-Type 故意设置为错误的值，检验按文件头判断类型
+func testImageServer(t *testing.T, files map[string][]byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testGIF(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadToLocalExtension(t *testing.T) {
+	defer func(p string, v bool) { localImagesPath, allowPrivateDownload = p, v }(localImagesPath, allowPrivateDownload)
+	localImagesPath = t.TempDir()
+	allowPrivateDownload = true
+
+	webp := append([]byte("RIFF\x24\x00\x00\x00WEBPVP8 "), make([]byte, 32)...)
+	srv := testImageServer(t, map[string][]byte{
+		"/":             testPNG(t, 2, 2, color.White),
+		"/photo.jpg":    testGIF(t),
+		"/picture":      webp,
+		"/already.webp": webp,
+	})
+	tests := []struct {
+		path string
+		name string // 为空表示 UUID 文件名
+		ext  string
+	}{
+		{"/", "", ".png"},
+		{"/photo.jpg", "photo.gif", ".gif"},
+		{"/picture", "picture.webp", ".webp"},
+		{"/already.webp", "already.webp", ".webp"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(srv.URL + tt.path)
+		name, err := downloadToLocal(context.Background(), u, "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if path.Ext(name) != tt.ext || (tt.name != "" && name != tt.name) {
+			t.Errorf("%s: 保存为 %s，want %s (%s)", tt.path, name, tt.name, tt.ext)
+		}
+		if tt.name == "" && len(strings.TrimSuffix(name, tt.ext)) != 36 {
+			t.Errorf("%s: 路径没有文件名时应使用 UUID，got %s", tt.path, name)
+		}
+	}
+}
+
+func TestCreateLocalFileKeepsExisting(t *testing.T) {
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+	os.WriteFile(filepath.Join(localImagesPath, "a.png"), []byte("old"), 0o644)
+
+	f, name, err := createLocalFile("a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if name == "a.png" || !strings.HasPrefix(name, "a-") || filepath.Ext(name) != ".png" {
+		t.Errorf("重名时应追加随机后缀，got %q", name)
+	}
+	if data, _ := os.ReadFile(filepath.Join(localImagesPath, "a.png")); string(data) != "old" {
+		t.Error("已有的文件不应被覆盖")
+	}
+	if _, _, err := createLocalFile("../a.png"); err == nil {
+		t.Error("无效的文件名应被拒绝")
+	}
+}
+
+func TestBulkDownload(t *testing.T) {
+	defer func(p string, v bool, tp TemplateProvider) {
+		localImagesPath, allowPrivateDownload, templateProvider = p, v, tp
+	}(localImagesPath, allowPrivateDownload, templateProvider)
+	localImagesPath = t.TempDir()
+	allowPrivateDownload = true
+	tmpl, err := parseTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templateProvider = &cachedTemplates{t: tmpl}
+
+	first, second := testPNG(t, 2, 2, color.White), testPNG(t, 3, 3, color.Black)
+	srv := testImageServer(t, map[string][]byte{"/a/cat.png": first, "/b/cat.png": second})
+	urls := srv.URL + "/a/cat.png\n" + srv.URL + "/missing.png\n" + srv.URL + "/b/cat.png"
+
+	rec := httptest.NewRecorder()
+	adminBulkDownloadHandler(rec, postForm("/admin/bulk_download", url.Values{"urls": {urls}, "dir": {"batch"}}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "成功 2 个，失败 1 个") {
+		t.Errorf("结果页面没有给出正确的统计: %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "404") {
+		t.Error("失败的地址应显示源站状态码")
+	}
+
+	// 两个地址的文件名相同，都应保存下来而不是互相覆盖
+	entries, err := os.ReadDir(filepath.Join(localImagesPath, "batch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, e := range entries {
+		data, _ := os.ReadFile(filepath.Join(localImagesPath, "batch", e.Name()))
+		found[string(data)] = true
+	}
+	if len(entries) != 2 || !found[string(first)] || !found[string(second)] {
+		t.Errorf("应保存两个不同的文件，got %d 个", len(entries))
+	}
+}
+
+func TestCappedReader(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 100)
+	got, err := io.ReadAll(&cappedReader{r: bytes.NewReader(data), limit: 100})
+	if err != nil || len(got) != 100 {
+		t.Errorf("恰好达到上限时应完整读出，got %d 字节, %v", len(got), err)
+	}
+	if _, err := io.ReadAll(&cappedReader{r: bytes.NewReader(data), limit: 99}); !errors.Is(err, errDownloadTooLarge) {
+		t.Errorf("超过上限时应返回 errDownloadTooLarge，got %v", err)
+	}
+}
+
+func TestDownloadToLocalRejectsOversized(t *testing.T) {
+	defer func(p string, v bool, n int64) {
+		localImagesPath, allowPrivateDownload, maxDownloadBytes = p, v, n
+	}(localImagesPath, allowPrivateDownload, maxDownloadBytes)
+	localImagesPath = t.TempDir()
+	allowPrivateDownload = true
+	maxDownloadBytes = 1000
+
+	exact := append(testPNG(t, 2, 2, color.White), make([]byte, 1000)...)[:1000]
+	big := append(testPNG(t, 2, 2, color.White), make([]byte, 2000)...)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		switch r.URL.Path {
+		case "/exact.png":
+			w.Write(exact)
+		case "/length.png":
+			// 带 Content-Length，应在读取内容前拒绝
+			w.Header().Set("Content-Length", strconv.Itoa(len(big)))
+			w.Write(big)
+		case "/chunked.png":
+			// 分块发送、没有 Content-Length，只能在读取时发现超限
+			for i := 0; i < len(big); i += 500 {
+				w.Write(big[i:min(i+500, len(big))])
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL + "/exact.png")
+	if name, err := downloadToLocal(context.Background(), u, ""); err != nil || name != "exact.png" {
+		t.Fatalf("恰好达到上限的文件应下载成功，got %q, %v", name, err)
+	}
+	for _, p := range []string{"/length.png", "/chunked.png"} {
+		u, _ := url.Parse(srv.URL + p)
+		if _, err := downloadToLocal(context.Background(), u, ""); !errors.Is(err, errDownloadTooLarge) {
+			t.Errorf("%s: 应返回 errDownloadTooLarge，got %v", p, err)
+		}
+	}
+	// 超限的下载不应留下写了一半的文件
+	entries, err := os.ReadDir(localImagesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "exact.png" {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("素材库中应只有 exact.png，got %v", names)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"golang.org/x/image/draw"
+)
+
+// --- 重复图片检测 ---
+
+// isUniqueViolation 判断数据库错误是否为唯一约束冲突（SQLSTATE 23505），即 URL 已存在
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// duplicateURLMessage 返回 URL 已存在时给管理员看的提示，说明已有的是哪张图片
+func duplicateURLMessage(ctx context.Context, imgURL string) string {
+	var id int
+	var deleted bool
+	err := dbpool.QueryRow(ctx, "SELECT id, deleted_at IS NOT NULL FROM images WHERE url = $1", imgURL).Scan(&id, &deleted)
+	switch {
+	case err != nil:
+		return "该 URL 已存在"
+	case deleted:
+		return fmt.Sprintf("该 URL 已存在（图片 #%d，目前在回收站中，可以在回收站恢复）", id)
+	default:
+		return fmt.Sprintf("该 URL 已存在（图片 #%d）", id)
+	}
+}
+
+// localContentHash 计算本地图片文件内容的 SHA-256，非本地 URL 返回 nil
+func localContentHash(imgURL string) (*string, error) {
+	if !strings.HasPrefix(imgURL, "/local/") {
+		return nil, nil
+	}
+	filePath, err := safeLocalPath(strings.TrimPrefix(imgURL, "/local/"))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	return &sum, nil
+}
+
+// sameContentImage 查找内容哈希相同但 URL 不同的图片，返回其 id，没有时返回 0
+func sameContentImage(ctx context.Context, hash *string, imgURL string) (int, error) {
+	if hash == nil {
+		return 0, nil
+	}
+	var id int
+	err := dbpool.QueryRow(ctx, "SELECT COALESCE(MIN(id), 0) FROM images WHERE content_hash = $1 AND url <> $2 AND deleted_at IS NULL", *hash, imgURL).Scan(&id)
+	return id, err
+}
+
+// --- 相似图片（感知哈希）---
+
+// perceptualHash 计算图片的平均哈希（aHash）：缩小为 8x8 灰度图，亮度高于平均值的像素记为 1。
+// 缩放、重新压缩或轻微调色后的同一张图片哈希几乎相同，可以用汉明距离衡量相似程度
+func perceptualHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 8, 8))
+	draw.CatmullRom.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+	var sum int
+	for _, p := range small.Pix {
+		sum += int(p)
+	}
+	mean := sum / len(small.Pix)
+	var hash uint64
+	for i, p := range small.Pix {
+		if int(p) > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// hammingDistance 返回两个哈希不同的位数
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// 相似图片页面 distance 参数的默认值和上限
+const (
+	defaultDuplicateDistance = 5
+	maxDuplicateDistance     = 16
+)
+
+// DuplicatesPageData 是相似图片页面的数据，每组中的图片两两之间可以通过距离不超过 Distance 的图片相连
+type DuplicatesPageData struct {
+	Groups    [][]Image
+	Distance  int
+	CSRFToken string
+}
+
+// groupSimilar 把哈希距离不超过 maxDistance 的图片归为一组（并查集，相似关系可传递），
+// 只返回至少两张图片的组，组内与组间都保持输入顺序。
+// 64 位哈希被分成 maxDistance+1 段，距离不超过 maxDistance 的两个哈希至少有一段完全相同，
+// 因此只需比较某一段相同的图片，不必两两比较全部图片
+func groupSimilar(images []Image, hashes []uint64, maxDistance int) [][]Image {
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	bands := min(maxDistance+1, 64)
+	for b := 0; b < bands; b++ {
+		lo, hi := b*64/bands, (b+1)*64/bands
+		mask := uint64(1)<<uint(hi-lo) - 1
+		buckets := make(map[uint64][]int)
+		for i, h := range hashes {
+			key := h >> uint(lo) & mask
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, bucket := range buckets {
+			for x, i := range bucket {
+				for _, j := range bucket[x+1:] {
+					ri, rj := find(i), find(j)
+					if ri != rj && hammingDistance(hashes[i], hashes[j]) <= maxDistance {
+						parent[max(ri, rj)] = min(ri, rj)
+					}
+				}
+			}
+		}
+	}
+
+	members := make(map[int][]Image)
+	var roots []int
+	for i, img := range images {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], img)
+	}
+	var groups [][]Image
+	for _, root := range roots {
+		if len(members[root]) > 1 {
+			groups = append(groups, members[root])
+		}
+	}
+	return groups
+}
+
+// adminDuplicatesHandler 列出感知哈希相近的图片组，便于清理重复的图片
+func adminDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	distance := defaultDuplicateDistance
+	if v := r.URL.Query().Get("distance"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxDuplicateDistance {
+			http.Error(w, fmt.Sprintf("distance 必须是 0-%d 之间的整数", maxDuplicateDistance), http.StatusBadRequest)
+			return
+		}
+		distance = n
+	}
+
+	// phash 为 0 表示无法解码或尚未计算，不参与比较
+	rows, err := dbpool.Query(r.Context(), "SELECT "+imageColumns+", phash FROM images WHERE deleted_at IS NULL AND COALESCE(phash, 0) <> 0 ORDER BY id")
+	if err != nil {
+		http.Error(w, "无法获取图片列表", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	var images []Image
+	var hashes []uint64
+	for rows.Next() {
+		var img Image
+		var hash int64
+		if err := rows.Scan(append(imageScanDest(&img), &hash)...); err != nil {
+			requestLogger(r).Error("扫描图片数据失败", "err", err)
+			continue
+		}
+		images = append(images, img)
+		hashes = append(hashes, uint64(hash))
+	}
+	render(w, "duplicates.html", DuplicatesPageData{
+		Groups:    groupSimilar(images, hashes, distance),
+		Distance:  distance,
+		CSRFToken: csrfToken(r),
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgconn"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	if !isUniqueViolation(fmt.Errorf("插入失败: %w", &pgconn.PgError{Code: "23505"})) {
+		t.Error("23505 应判断为唯一约束冲突")
+	}
+	if isUniqueViolation(&pgconn.PgError{Code: "23503"}) || isUniqueViolation(nil) {
+		t.Error("其他错误不应判断为唯一约束冲突")
+	}
+}
+
+func TestAddImageDuplicateURL(t *testing.T) {
+	testDB(t)
+	id := insertTestImage(t, "https://example.com/dup.jpg", "a")
+	rec := httptest.NewRecorder()
+	adminAddImageHandler(rec, postForm("/admin/add", url.Values{"url": {"https://example.com/dup.jpg"}, "image_type": {"a"}}))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if want := fmt.Sprintf("该 URL 已存在（图片 #%d）", id); !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("body = %q, want %q", rec.Body.String(), want)
+	}
+}
+
+func TestAddImageWarnsSameContent(t *testing.T) {
+	testDB(t)
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+	data := testPNG(t, 4, 4, color.White)
+	for _, name := range []string{"a.png", "b.png"} {
+		if err := os.WriteFile(filepath.Join(localImagesPath, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	adminAddImageHandler(rec, postForm("/admin/add", url.Values{"url": {"/local/a.png"}, "image_type": {"a"}}))
+	if rec.Code != http.StatusFound || flashMessage(rec) != "" {
+		t.Fatalf("第一次添加不应有提示，status = %d, flash = %q", rec.Code, flashMessage(rec))
+	}
+	rec = httptest.NewRecorder()
+	adminAddImageHandler(rec, postForm("/admin/add", url.Values{"url": {"/local/b.png"}, "image_type": {"a"}}))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	if msg := flashMessage(rec); !strings.Contains(msg, "内容完全相同") {
+		t.Errorf("同一文件换名添加时应提示重复，flash = %q", msg)
+	}
+}
+
+func TestPerceptualHash(t *testing.T) {
+	// 左半黑右半白（或上半黑下半白）的图片，缩放后哈希应保持不变
+	halves := func(w, h int, vertical bool) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if x >= w/2 && !vertical || y >= h/2 && vertical {
+					img.Set(x, y, color.White)
+				} else {
+					img.Set(x, y, color.Black)
+				}
+			}
+		}
+		return img
+	}
+	small, large := perceptualHash(halves(32, 32, false)), perceptualHash(halves(256, 256, false))
+	if d := hammingDistance(small, large); d > 2 {
+		t.Errorf("缩放后的同一张图片哈希距离为 %d", d)
+	}
+	if small == 0 || small == ^uint64(0) {
+		t.Errorf("黑白各半的图片哈希不应全 0 或全 1，got %016x", small)
+	}
+	if other := perceptualHash(halves(32, 32, true)); hammingDistance(small, other) < 16 {
+		t.Errorf("差别明显的图片哈希距离过小: %d", hammingDistance(small, other))
+	}
+}
+
+// groupSimilarBrute 是两两比较的参照实现
+func groupSimilarBrute(images []Image, hashes []uint64, maxDistance int) [][]Image {
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			if hammingDistance(hashes[i], hashes[j]) <= maxDistance {
+				if ri, rj := find(i), find(j); ri != rj {
+					parent[max(ri, rj)] = min(ri, rj)
+				}
+			}
+		}
+	}
+	members := make(map[int][]Image)
+	var roots []int
+	for i, img := range images {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], img)
+	}
+	var groups [][]Image
+	for _, root := range roots {
+		if len(members[root]) > 1 {
+			groups = append(groups, members[root])
+		}
+	}
+	return groups
+}
+
+func TestGroupSimilar(t *testing.T) {
+	images := []Image{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}, {ID: 5}}
+	// 1 与 3 相差 2 位，3 与 5 相差 2 位（1 与 5 相差 4 位，靠传递归为一组），2 与 4 完全不同
+	hashes := []uint64{0b0000, 0xFFFF0000, 0b0011, 0xFFFFFFFF00000000, 0b1111}
+	groups := groupSimilar(images, hashes, 2)
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1: %+v", len(groups), groups)
+	}
+	var ids []int
+	for _, img := range groups[0] {
+		ids = append(ids, img.ID)
+	}
+	if fmt.Sprint(ids) != "[1 3 5]" {
+		t.Errorf("group = %v, want [1 3 5]", ids)
+	}
+	if groups := groupSimilar(images, hashes, 1); len(groups) != 0 {
+		t.Errorf("距离 1 时不应有分组，got %+v", groups)
+	}
+}
+
+func TestGroupSimilarMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	images := make([]Image, 300)
+	hashes := make([]uint64, len(images))
+	for i := range images {
+		images[i].ID = i + 1
+		if i > 0 && rng.IntN(2) == 0 {
+			// 一半的哈希由之前的哈希翻转几位得到，保证存在相似的图片
+			hashes[i] = hashes[rng.IntN(i)]
+			for n := rng.IntN(8); n > 0; n-- {
+				hashes[i] ^= 1 << rng.IntN(64)
+			}
+		} else {
+			hashes[i] = rng.Uint64()
+		}
+	}
+	for _, d := range []int{0, 1, defaultDuplicateDistance, maxDuplicateDistance} {
+		got, want := groupSimilar(images, hashes, d), groupSimilarBrute(images, hashes, d)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("distance %d: 分段比较与两两比较的结果不同", d)
+		}
+	}
+}
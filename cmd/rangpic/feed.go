@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- RSS 订阅 ---
+
+// 订阅中图片数量 limit 参数的默认值和上限
+const (
+	defaultFeedItems = 20
+	maxFeedItems     = 100
+)
+
+// rssFeed 是 RSS 2.0 文档的根元素
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title     string       `xml:"title"`
+	Link      string       `xml:"link"`
+	GUID      rssGUID      `xml:"guid"`
+	PubDate   string       `xml:"pubDate"`
+	Category  []string     `xml:"category"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// publicBaseURL 来自 PUBLIC_BASE_URL，是本服务对外的地址（如 https://pic.example.com），不以 / 结尾。
+// 为空时根据请求推断
+var publicBaseURL string
+
+// parsePublicBaseURL 校验 PUBLIC_BASE_URL：必须是带主机名的 http 或 https 地址，不能带查询参数，
+// 可以带路径前缀（部署在子路径下时），末尾的 / 会被去掉。空字符串表示不设置
+func parsePublicBaseURL(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("PUBLIC_BASE_URL 必须是 http 或 https 地址，如 https://pic.example.com: %q", v)
+	}
+	return strings.TrimRight(v, "/"), nil
+}
+
+// requestBaseURL 返回本服务对外的地址，用于在订阅中生成绝对链接。设置了 PUBLIC_BASE_URL 时直接使用，
+// 否则根据请求的 Host 推断，开启 TRUST_PROXY 时采用反向代理传来的 X-Forwarded-Proto
+func requestBaseURL(r *http.Request) string {
+	if publicBaseURL != "" {
+		return publicBaseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if trustedProxyHops > 0 {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+	}
+	return scheme + "://" + r.Host
+}
+
+// enclosureType 根据 URL 的扩展名推断图片类型，无法判断时按 JPEG 处理
+func enclosureType(imgURL string) string {
+	if i := strings.IndexAny(imgURL, "?#"); i >= 0 {
+		imgURL = imgURL[:i]
+	}
+	if t, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(imgURL))); err == nil && strings.HasPrefix(t, "image/") {
+		return t
+	}
+	return "image/jpeg"
+}
+
+// buildFeed 把按添加时间倒序的图片组装为 RSS 文档，base 为本服务的对外地址
+func buildFeed(base string, tags []string, images []Image) rssFeed {
+	title := "RangPic 最新图片"
+	if len(tags) > 0 {
+		title += "：" + strings.Join(tags, ", ")
+	}
+	feed := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:       title,
+		Link:        base + "/",
+		Description: "最近添加到 RangPic 的图片",
+	}}
+	if len(images) > 0 {
+		feed.Channel.LastBuildDate = images[0].CreatedAt.UTC().Format(time.RFC1123Z)
+	}
+	for _, img := range images {
+		link := base + "/image?id=" + strconv.Itoa(img.ID)
+		enclosureURL := img.URL
+		if strings.HasPrefix(enclosureURL, "/local/") {
+			enclosureURL = base + (&url.URL{Path: enclosureURL}).EscapedPath()
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:     fmt.Sprintf("图片 #%d", img.ID),
+			Link:      link,
+			GUID:      rssGUID{Value: link, IsPermaLink: true},
+			PubDate:   img.CreatedAt.UTC().Format(time.RFC1123Z),
+			Category:  img.Tags,
+			Enclosure: rssEnclosure{URL: enclosureURL, Length: img.Bytes, Type: enclosureType(img.URL)},
+		})
+	}
+	return feed
+}
+
+// feedHandler 以 RSS 2.0 输出最近添加的 limit 张图片，过滤参数（tag、match、exclude、starred）与随机图片相同，
+// 不参与随机（权重为 0）和回收站中的图片不会出现。每个条目链接到 /image?id=，并以图片地址作为 enclosure
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseImageFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.AvoidID = 0
+	limit := defaultFeedItems
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "limit 必须是正整数", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxFeedItems)
+	}
+
+	where, args := randomFilterClause(filter)
+	args = append(args, limit)
+	rows, err := readQuery(r.Context(), fmt.Sprintf("SELECT %s FROM images%s ORDER BY created_at DESC, id DESC LIMIT $%d", imageColumns, where, len(args)), args...)
+	if err != nil {
+		requestLogger(r).Error("查询订阅图片失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	var images []Image
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			requestLogger(r).Error("读取订阅图片失败", "err", err)
+			http.Error(w, "查询图片失败", http.StatusInternalServerError)
+			return
+		}
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		requestLogger(r).Error("读取订阅图片失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(buildFeed(requestBaseURL(r), filter.Tags, images)); err != nil {
+		requestLogger(r).Warn("写出订阅失败", "err", err)
+	}
+}
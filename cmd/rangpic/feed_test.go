@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBuildFeedWellFormed(t *testing.T) {
+	created := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	images := []Image{
+		{ID: 2, URL: "/local/sub dir/a b.png", Tags: []string{"cats & dogs", "<tag>"}, Bytes: 1234, CreatedAt: created},
+		{ID: 1, URL: "https://img.example.com/x.webp?size=large", Tags: []string{}, CreatedAt: created.Add(-time.Hour)},
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(buildFeed("https://pic.example.com", []string{"cats & dogs"}, images)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 逐个读取 token，确认整个文档是格式正确的 XML
+	dec := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("订阅不是格式正确的 XML: %v\n%s", err, buf.String())
+		}
+	}
+
+	var feed rssFeed
+	if err := xml.Unmarshal(buf.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Version != "2.0" || feed.Channel.Title != "RangPic 最新图片：cats & dogs" {
+		t.Errorf("version = %q, title = %q", feed.Version, feed.Channel.Title)
+	}
+	if feed.Channel.LastBuildDate != "Mon, 06 May 2024 07:08:09 +0000" {
+		t.Errorf("lastBuildDate = %q", feed.Channel.LastBuildDate)
+	}
+	if len(feed.Channel.Items) != 2 {
+		t.Fatalf("got %d items, want 2", len(feed.Channel.Items))
+	}
+	local, remote := feed.Channel.Items[0], feed.Channel.Items[1]
+	if want := (rssEnclosure{URL: "https://pic.example.com/local/sub%20dir/a%20b.png", Length: 1234, Type: "image/png"}); local.Enclosure != want {
+		t.Errorf("本地图片 enclosure = %+v, want %+v", local.Enclosure, want)
+	}
+	if want := (rssEnclosure{URL: "https://img.example.com/x.webp?size=large", Type: "image/webp"}); remote.Enclosure != want {
+		t.Errorf("远程图片 enclosure = %+v, want %+v", remote.Enclosure, want)
+	}
+	if local.Link != "https://pic.example.com/image?id=2" || !local.GUID.IsPermaLink || local.GUID.Value != local.Link {
+		t.Errorf("link = %q, guid = %+v", local.Link, local.GUID)
+	}
+	if len(local.Category) != 2 || local.Category[1] != "<tag>" {
+		t.Errorf("category = %q", local.Category)
+	}
+}
+
+func TestEnclosureType(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://a.test/x.PNG":       "image/png",
+		"https://a.test/x.gif#frag":  "image/gif",
+		"https://a.test/x.webp?w=10": "image/webp",
+		"https://a.test/x":           "image/jpeg",
+		"https://a.test/x.html":      "image/jpeg",
+	} {
+		if got := enclosureType(in); got != want {
+			t.Errorf("enclosureType(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParsePublicBaseURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                             "",
+		"https://pic.example.com":      "https://pic.example.com",
+		"https://pic.example.com/":     "https://pic.example.com",
+		"http://example.com:8080/pic/": "http://example.com:8080/pic",
+	} {
+		if got, err := parsePublicBaseURL(in); err != nil || got != want {
+			t.Errorf("parsePublicBaseURL(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"pic.example.com", "ftp://pic.example.com", "https://", "https://pic.example.com/?a=1", "/relative"} {
+		if _, err := parsePublicBaseURL(in); err == nil {
+			t.Errorf("parsePublicBaseURL(%q) 应返回错误", in)
+		}
+	}
+}
+
+func TestRequestBaseURL(t *testing.T) {
+	defer func(b string, hops int) { publicBaseURL, trustedProxyHops = b, hops }(publicBaseURL, trustedProxyHops)
+
+	plain := httptest.NewRequest(http.MethodGet, "/feed.xml", nil)
+	plain.Host = "internal:17777"
+	plain.Header.Set("X-Forwarded-Proto", "https")
+	secure := httptest.NewRequest(http.MethodGet, "/feed.xml", nil)
+	secure.Host = "pic.test"
+	secure.TLS = &tls.ConnectionState{}
+
+	tests := []struct {
+		name string
+		base string
+		hops int
+		r    *http.Request
+		want string
+	}{
+		{"按请求推断", "", 0, plain, "http://internal:17777"},
+		{"TLS 连接", "", 0, secure, "https://pic.test"},
+		{"信任代理的协议头", "", 1, plain, "https://internal:17777"},
+		{"PUBLIC_BASE_URL 优先", "https://pic.example.com", 1, plain, "https://pic.example.com"},
+	}
+	for _, tt := range tests {
+		publicBaseURL, trustedProxyHops = tt.base, tt.hops
+		if got := requestBaseURL(tt.r); got != tt.want {
+			t.Errorf("%s: requestBaseURL = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, GZIP", true},
+		{"br;q=1.0, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"identity", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// gunzipBody 解压 gzip 响应体
+func gunzipBody(t *testing.T, rec *httptest.ResponseRecorder) []byte {
+	t.Helper()
+	if enc := rec.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", enc)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("响应不是有效的 gzip 数据: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("解压响应失败: %v", err)
+	}
+	return data
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"tag":"cat"}`, 100)
+	h := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1300")
+		io.WriteString(w, body)
+	}))
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(rec, r)
+	if got := string(gunzipBody(t, rec)); got != body {
+		t.Errorf("解压后的内容与原始内容不同")
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Error("压缩后不应保留处理函数设置的 Content-Length")
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type 应按原始内容探测，got %q", ct)
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", rec.Header().Get("Vary"))
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != body {
+		t.Error("不接受 gzip 的客户端应收到未压缩的内容")
+	}
+}
+
+func TestTagsAPIGzipped(t *testing.T) {
+	testDB(t)
+	insertTestImage(t, "https://example.com/a.jpg", "cat", "dog")
+	insertTestImage(t, "https://example.com/b.jpg", "bird")
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+	r.Header.Set("Accept-Encoding", "gzip, deflate")
+	gzipMiddleware(http.HandlerFunc(tagsAPIHandler)).ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var tags []string
+	if err := json.Unmarshal(gunzipBody(t, rec), &tags); err != nil {
+		t.Fatalf("解压后不是有效的 JSON: %v", err)
+	}
+	if strings.Join(tags, ",") != "bird,cat,dog" {
+		t.Errorf("tags = %v, want [bird cat dog]", tags)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
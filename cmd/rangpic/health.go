@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// --- 健康检查 ---
+
+// healthCheckTimeout 限制健康检查访问数据库的时间，避免编排系统的探针长时间挂起
+const healthCheckTimeout = 2 * time.Second
+
+// healthzHandler 确认服务能连上数据库，供存活探针使用
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	writeHealth(w, dbpool.Ping(ctx))
+}
+
+// readyzHandler 在能连上数据库的基础上确认 images 表已创建，供就绪探针使用
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	var exists bool
+	err := dbpool.QueryRow(ctx, "SELECT to_regclass('images') IS NOT NULL").Scan(&exists)
+	if err == nil && !exists {
+		err = errors.New("images 表不存在")
+	}
+	writeHealth(w, err)
+}
+
+func writeHealth(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
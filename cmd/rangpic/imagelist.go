@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// --- 图片列表接口 ---
+
+// defaultListLimit 和 maxListLimit 是 /api/images 每页的默认条数和上限
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// ImageList 是 GET /api/images 的响应，Total 为满足条件的图片总数，不受 limit 和 offset 影响
+type ImageList struct {
+	Items []Image `json:"items"`
+	Total int     `json:"total"`
+}
+
+// parseListPaging 读取 limit 和 offset 参数，limit 超过上限时按上限处理
+func parseListPaging(q url.Values) (limit, offset int, err error) {
+	limit = defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("limit 必须是正整数")
+		}
+		limit = min(limit, maxListLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset 必须是非负整数")
+		}
+	}
+	return limit, offset, nil
+}
+
+// listImagesAPIHandler 按条件分页列出图库中的图片（不含回收站），供后台搜索使用。
+// 标签过滤参数（tag、match、exclude、starred）与 /api/random-image 相同，权重为 0 的图片也会列出；
+// q 按 URL 子串或完整标签搜索，sort 与仪表盘相同（id、newest、oldest）。
+// 所有条件都以参数传给数据库，排序只能从白名单中选择
+func listImagesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseImageFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.AvoidID = 0
+	limit, offset, err := parseListPaging(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort := q.Get("sort")
+	if sort == "" {
+		sort = "id"
+	}
+	order, ok := dashboardSorts[sort]
+	if !ok {
+		http.Error(w, "sort 只能是 id、newest 或 oldest", http.StatusBadRequest)
+		return
+	}
+
+	conds, args := imageFilterConds([]string{"deleted_at IS NULL"}, nil, filter)
+	if search := strings.ToLower(strings.TrimSpace(q.Get("q"))); search != "" {
+		args = append(args, search)
+		conds = append(conds, fmt.Sprintf(`(url ILIKE '%%' || $%d || '%%' OR $%d = ANY(%s))`, len(args), len(args), lowerTagsExpr))
+	}
+	where := " WHERE " + strings.Join(conds, " AND ")
+
+	list := ImageList{Items: []Image{}}
+	if err := readQueryRow(r.Context(), "SELECT COUNT(*) FROM images"+where, args...).Scan(&list.Total); err != nil {
+		requestLogger(r).Error("统计图片数量失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	args = append(args, limit, offset)
+	rows, err := readQuery(r.Context(),
+		fmt.Sprintf("SELECT %s FROM images%s ORDER BY %s LIMIT $%d OFFSET $%d", imageColumns, where, order, len(args)-1, len(args)),
+		args...)
+	if err != nil {
+		requestLogger(r).Error("查询图片列表失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			requestLogger(r).Error("读取图片列表失败", "err", err)
+			http.Error(w, "查询图片失败", http.StatusInternalServerError)
+			return
+		}
+		list.Items = append(list.Items, img)
+	}
+	if err := rows.Err(); err != nil {
+		requestLogger(r).Error("读取图片列表失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestListImagesAPIRejectsInvalidParams(t *testing.T) {
+	// 参数校验在查询数据库之前完成，不需要数据库
+	for _, query := range []string{
+		"sort=random",
+		"sort=id%3BDROP+TABLE+images",
+		"match=some",
+		"limit=0",
+		"limit=abc",
+		"offset=-1",
+	} {
+		rec := httptest.NewRecorder()
+		listImagesAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/images?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestListImagesAPIFilters(t *testing.T) {
+	testDB(t)
+	ctx := context.Background()
+	cat := insertTestImage(t, "https://example.com/cat.jpg", "cats", "cute")
+	starred := insertTestImage(t, "https://example.com/starred-cat.jpg", "cats")
+	dog := insertTestImage(t, "https://example.com/dog.jpg", "dogs", "Cute")
+	trashed := insertTestImage(t, "https://example.com/trashed.jpg", "cats")
+	hidden := insertTestImage(t, "https://example.com/hidden.jpg", "cats")
+	for _, u := range []struct {
+		stmt string
+		id   int
+	}{
+		{"UPDATE images SET starred = true WHERE id = $1", starred},
+		{"UPDATE images SET deleted_at = now() WHERE id = $1", trashed},
+		{"UPDATE images SET weight = 0 WHERE id = $1", hidden},
+	} {
+		if _, err := dbpool.Exec(ctx, u.stmt, u.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+		total int
+	}{
+		// 回收站中的图片不列出，权重为 0 的图片照常列出
+		{"", []int{hidden, dog, starred, cat}, 4},
+		{"tag=&q=+", []int{hidden, dog, starred, cat}, 4},
+		{"tag=cats", []int{hidden, starred, cat}, 3},
+		{"tag=cats&tag=cute", []int{cat}, 1},
+		{"tags=cats,dogs&match=any", []int{hidden, dog, starred, cat}, 4},
+		{"exclude=cute", []int{hidden, starred}, 2},
+		{"tag=cats&starred=1", []int{starred}, 1},
+		{"q=dog", []int{dog}, 1},
+		{"q=CUTE", []int{dog, cat}, 2},
+		{"tag=cats&q=starred", []int{starred}, 1},
+		{"tag=cats&exclude=cute&sort=oldest", []int{starred, hidden}, 2},
+		{"sort=newest&limit=2&offset=1", []int{dog, starred}, 4},
+		{"tag=birds", []int{}, 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		listImagesAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/images?"+tt.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: status = %d: %s", tt.query, rec.Code, rec.Body.String())
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+			t.Errorf("%q: Content-Type = %q", tt.query, ct)
+		}
+		var list ImageList
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if list.Items == nil {
+			t.Errorf("%q: 没有结果时 items 应为空数组而不是 null", tt.query)
+		}
+		var ids []int
+		for _, img := range list.Items {
+			ids = append(ids, img.ID)
+		}
+		if !slices.Equal(ids, tt.want) || list.Total != tt.total {
+			t.Errorf("%q: got %v (total %d), want %v (total %d)", tt.query, ids, list.Total, tt.want, tt.total)
+		}
+	}
+}
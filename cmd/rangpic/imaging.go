@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buckket/go-blurhash"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// --- 图片解码管线 ---
+
+// maxDecodeBytes 限制为解码而读取的单张图片大小，防止异常大的源图耗尽内存
+const maxDecodeBytes = 32 << 20
+
+// errImageTooLarge 表示图片超过了读取时允许的大小上限
+var errImageTooLarge = errors.New("图片超过大小上限")
+
+// fetchImageBytes 读取图片的原始字节，本地图片直接读文件，其余走 httpClient，超过 maxDecodeBytes 时返回 errImageTooLarge
+func fetchImageBytes(ctx context.Context, imgURL string) ([]byte, error) {
+	return fetchImageBytesLimit(ctx, imgURL, maxDecodeBytes)
+}
+
+// fetchImageBytesLimit 与 fetchImageBytes 相同，但大小上限为 limit 字节。
+// 本地文件先看文件大小、远程图片先看 Content-Length，明显超限时不读取内容；
+// 长度未知时最多读取 limit+1 字节，读到第 limit+1 字节即判定超限
+func fetchImageBytesLimit(ctx context.Context, imgURL string, limit int64) ([]byte, error) {
+	var body io.Reader
+	if strings.HasPrefix(imgURL, "/local/") {
+		filePath, err := safeLocalPath(strings.TrimPrefix(imgURL, "/local/"))
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if info, err := f.Stat(); err == nil && info.Size() > limit {
+			return nil, errImageTooLarge
+		}
+		body = f
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, imgURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("源站返回状态码: %d", resp.StatusCode)
+		}
+		if resp.ContentLength > limit {
+			return nil, errImageTooLarge
+		}
+		body = resp.Body
+	}
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errImageTooLarge
+	}
+	return data, nil
+}
+
+// decodeImage 解码 jpeg/png/gif/webp 格式的图片
+func decodeImage(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// downscale 将图片等比缩小到最长边不超过 maxSide，已足够小时原样返回
+func downscale(src image.Image, maxSide int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSide && h <= maxSide {
+		return src
+	}
+	if w >= h {
+		h = max(1, h*maxSide/w)
+		w = maxSide
+	} else {
+		w = max(1, w*maxSide/h)
+		h = maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+// --- 图片元数据（blurhash、主色调、尺寸、感知哈希）---
+
+// computeBlurhash 先把图片缩小再编码，blurhash 只描述大致色块，不需要原始分辨率
+func computeBlurhash(img image.Image) (string, error) {
+	return blurhash.Encode(4, 3, downscale(img, 32))
+}
+
+// imageMetadata 是后台任务从源图计算出的元数据
+type imageMetadata struct {
+	Blurhash string
+	Color    string
+	Width    int
+	Height   int
+	Bytes    int64
+	PHash    uint64
+}
+
+var metadataWake = make(chan struct{}, 1)
+
+// wakeMetadataWorker 通知后台任务尽快处理新加入或 URL 变化的图片
+func wakeMetadataWorker() {
+	select {
+	case metadataWake <- struct{}{}:
+	default:
+	}
+}
+
+// startMetadataWorker 启动后台任务，为尚未计算元数据的图片补算 blurhash、主色调、尺寸和感知哈希
+func startMetadataWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for {
+			fillMissingMetadata(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-metadataWake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// fillMissingMetadata 分批处理尚未计算元数据的图片，直到没有剩余或出错，返回处理的图片数
+func fillMissingMetadata(ctx context.Context) int {
+	updated, failed, err := backfillMetadata(ctx, 1, func(img Image, err error) {
+		if err != nil {
+			// 未能得到的字段记为空字符串和 0，避免无法获取或解码的图片被反复重试
+			slog.Warn("计算图片元数据失败，已跳过", "image_id", img.ID, "err", err)
+		}
+	})
+	if err != nil {
+		slog.Error("补算图片元数据失败", "err", err)
+	}
+	return updated + failed
+}
+
+// backfillMetadata 分批查询缺少元数据（任一元数据列为 NULL）的图片，以至多 concurrency 个并发获取并计算元数据，
+// 直到没有剩余的图片。只处理仍缺少元数据的行，中断后再次调用会从剩余的图片继续。
+// 每处理完一张图片调用一次 progress（可为 nil，调用不会并发），err 非 nil 表示该图片无法获取或解码，
+// 此时已得到的部分照常写入，其余字段记为空值。返回成功和失败的数量，数据库出错时提前返回
+func backfillMetadata(ctx context.Context, concurrency int, progress func(img Image, err error)) (updated, failed int, err error) {
+	var mu sync.Mutex
+	for {
+		rows, err := dbpool.Query(ctx, "SELECT id, url FROM images WHERE deleted_at IS NULL AND (blurhash IS NULL OR color IS NULL OR width IS NULL OR bytes IS NULL OR phash IS NULL) ORDER BY id LIMIT 50")
+		if err != nil {
+			return updated, failed, fmt.Errorf("查询待计算元数据的图片失败: %w", err)
+		}
+		var pending []Image
+		for rows.Next() {
+			var img Image
+			if err := rows.Scan(&img.ID, &img.URL); err == nil {
+				pending = append(pending, img)
+			}
+		}
+		rows.Close()
+		if len(pending) == 0 {
+			return updated, failed, nil
+		}
+
+		var saveErr error
+		slots := make(chan struct{}, max(1, concurrency))
+		var wg sync.WaitGroup
+		for _, img := range pending {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func() {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				meta, metaErr := metadataForURL(ctx, img.URL)
+				// 仅在 URL 未被修改时写入，防止覆盖编辑后的新图片
+				_, err := dbpool.Exec(ctx, "UPDATE images SET blurhash=$1, width=$2, height=$3, bytes=$4, phash=$5, color=$6 WHERE id=$7 AND url=$8",
+					meta.Blurhash, meta.Width, meta.Height, meta.Bytes, int64(meta.PHash), meta.Color, img.ID, img.URL)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					saveErr = fmt.Errorf("保存图片 %d 的元数据失败: %w", img.ID, err)
+					return
+				}
+				if metaErr != nil {
+					failed++
+				} else {
+					updated++
+				}
+				if progress != nil {
+					progress(img, metaErr)
+				}
+			}()
+		}
+		wg.Wait()
+		if saveErr != nil {
+			return updated, failed, saveErr
+		}
+	}
+}
+
+// backfillConcurrency 是 /admin/backfill 同时获取图片的数量
+const backfillConcurrency = 4
+
+// adminBackfillHandler 立即为缺少元数据的图片补算尺寸、大小、主色调、blurhash 和感知哈希，
+// 以纯文本逐行输出每张图片的结果，最后输出成功和失败的数量。客户端断开时停止，已写入的结果保留，
+// 再次提交会从剩余的图片继续
+func adminBackfillHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	rc := http.NewResponseController(w)
+	fmt.Fprintln(w, "开始补算缺少元数据的图片……")
+	rc.Flush()
+
+	updated, failed, err := backfillMetadata(r.Context(), backfillConcurrency, func(img Image, err error) {
+		if err != nil {
+			fmt.Fprintf(w, "失败 #%d %s: %v\n", img.ID, img.URL, err)
+		} else {
+			fmt.Fprintf(w, "完成 #%d %s\n", img.ID, img.URL)
+		}
+		rc.Flush()
+	})
+	if err != nil {
+		requestLogger(r).Error("补算图片元数据失败", "err", err)
+		fmt.Fprintf(w, "已中止: %v\n", err)
+	}
+	requestLogger(r).Info("补算图片元数据完成", "updated", updated, "failed", failed)
+	fmt.Fprintf(w, "成功 %d 张，失败 %d 张（失败的图片已记为空值，不会被重复处理）\n", updated, failed)
+}
+
+// metadataForURL 获取图片并计算元数据。出错时仍返回已得到的部分：
+// 能读到文件头时先用 image.DecodeConfig 取得尺寸，完整解码失败也不影响尺寸和大小
+func metadataForURL(ctx context.Context, imgURL string) (imageMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	data, err := fetchImageBytes(ctx, imgURL)
+	if err != nil {
+		return imageMetadata{}, err
+	}
+	meta := imageMetadata{Bytes: int64(len(data))}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		meta.Width, meta.Height = cfg.Width, cfg.Height
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return meta, err
+	}
+	meta.Width, meta.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	meta.PHash = perceptualHash(img)
+	meta.Color = dominantColor(img)
+	meta.Blurhash, err = computeBlurhash(img)
+	return meta, err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAdminBackfillHandler(t *testing.T) {
+	testDB(t)
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+	os.WriteFile(filepath.Join(localImagesPath, "red.png"), testPNG(t, 4, 3, color.RGBA{255, 0, 0, 255}), 0o644)
+	os.WriteFile(filepath.Join(localImagesPath, "blue.png"), testPNG(t, 2, 5, color.RGBA{0, 0, 255, 255}), 0o644)
+
+	red := insertTestImage(t, "/local/red.png")
+	blue := insertTestImage(t, "/local/blue.png")
+	missing := insertTestImage(t, "/local/missing.png")
+	// 已有完整元数据的图片不应被再次处理
+	done := insertTestImage(t, "/local/done.png")
+	ctx := context.Background()
+	if _, err := dbpool.Exec(ctx, "UPDATE images SET blurhash='x', color='#000000', width=1, height=1, bytes=1, phash=0 WHERE id=$1", done); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	adminBackfillHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/backfill", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "成功 2 张，失败 1 张") {
+		t.Fatalf("汇总不正确:\n%s", body)
+	}
+	for _, id := range []int{red, blue} {
+		if !strings.Contains(body, "完成 #"+strconv.Itoa(id)+" ") {
+			t.Errorf("缺少图片 %d 的完成行:\n%s", id, body)
+		}
+	}
+	if !strings.Contains(body, "失败 #"+strconv.Itoa(missing)+" ") {
+		t.Errorf("缺少图片 %d 的失败行:\n%s", missing, body)
+	}
+	if strings.Contains(body, "#"+strconv.Itoa(done)+" ") {
+		t.Errorf("已有元数据的图片 %d 不应被处理:\n%s", done, body)
+	}
+
+	cases := []struct {
+		id            int
+		width, height int
+		color         string
+	}{
+		{red, 4, 3, "#ff0000"},
+		{blue, 2, 5, "#0000ff"},
+		{missing, 0, 0, ""},
+	}
+	for _, c := range cases {
+		var width, height int
+		var col, hash string
+		if err := dbpool.QueryRow(ctx, "SELECT width, height, color, blurhash FROM images WHERE id=$1", c.id).Scan(&width, &height, &col, &hash); err != nil {
+			t.Fatalf("图片 %d 的元数据未写入: %v", c.id, err)
+		}
+		if width != c.width || height != c.height || col != c.color {
+			t.Errorf("图片 %d: 尺寸 %dx%d、主色调 %q，期望 %dx%d、%q", c.id, width, height, col, c.width, c.height, c.color)
+		}
+		if (hash != "") != (c.width > 0) {
+			t.Errorf("图片 %d: blurhash = %q", c.id, hash)
+		}
+	}
+
+	// 失败的图片已记为空值，再次提交时没有需要处理的图片
+	rec = httptest.NewRecorder()
+	adminBackfillHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/backfill", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "成功 0 张，失败 0 张") {
+		t.Errorf("再次补算不应处理任何图片:\n%s", body)
+	}
+}
+
+func TestImageBlurhashHandler(t *testing.T) {
+	get := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/image/"+id+"/blurhash", nil)
+		r.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		imageBlurhashHandler(rec, r)
+		return rec
+	}
+	// 非整数的 ID 在查询数据库之前就被拒绝
+	for _, id := range []string{"abc", "1.5", "1;DROP"} {
+		if rec := get(id); rec.Code != http.StatusBadRequest {
+			t.Errorf("id=%q: status = %d, want %d", id, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	testDB(t)
+	id := insertTestImage(t, "https://example.com/a.jpg")
+	if rec := get(strconv.Itoa(id)); rec.Code != http.StatusNotFound {
+		t.Errorf("尚未计算 blurhash 时 status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := get(strconv.Itoa(id + 1)); rec.Code != http.StatusNotFound {
+		t.Errorf("不存在的图片 status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if _, err := dbpool.Exec(context.Background(), "UPDATE images SET blurhash = 'LKTI' WHERE id = $1", id); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(strconv.Itoa(id)); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"blurhash":"LKTI"`) {
+		t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+// TestComputeBlurhashGolden 固定 blurhash 的输出，缩放算法或分量数的改动会让已存储的占位图与新算出的不一致
+func TestComputeBlurhashGolden(t *testing.T) {
+	gradient := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			gradient.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 5), 128, 255})
+		}
+	}
+	cases := []struct {
+		name string
+		img  image.Image
+		want string
+	}{
+		{"纯红", solidImage(16, 16, color.RGBA{255, 0, 0, 255}), "LKTI:j|cfQ|c|co1fQo1fQfQfQfQ"},
+		{"渐变", gradient, "LzHLF[2swxX8mHWWjtf7gJfjfQfj"},
+	}
+	for _, c := range cases {
+		got, err := computeBlurhash(c.img)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: computeBlurhash = %q，期望 %q", c.name, got, c.want)
+		}
+	}
+}
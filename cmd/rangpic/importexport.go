@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- 导入导出 ---
+
+// importSummary 汇总一次导入的结果
+type importSummary struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	Skipped  int `json:"skipped"`
+}
+
+// importURLList 逐行读取 url,tag1,tag2 格式的 URL 列表并写入数据库。
+// 已存在的 URL 会用文件中的标签覆盖，格式错误或无法写入的行记录日志后跳过
+func importURLList(ctx context.Context, r io.Reader) (importSummary, error) {
+	var sum importSummary
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		imgURL, tags, err := parseURLListLine(scanner.Text())
+		if err != nil {
+			sum.Skipped++
+			slog.Warn("跳过 URL 列表中格式错误的行", "line", n, "err", err)
+			continue
+		}
+		if imgURL == "" {
+			continue
+		}
+		if err := sum.upsert(ctx, imgURL, tags); err != nil {
+			slog.Warn("无法导入 URL 列表中的行", "line", n, "url", imgURL, "err", err)
+		}
+	}
+	return sum, scanner.Err()
+}
+
+// parseURLListLine 解析 URL 列表中的一行 url,tag1,tag2，标签经过 normalizeTags 规范化。
+// 空行和以 # 开头的注释行返回空 URL 和 nil 错误；URL 为空或无效时返回错误
+func parseURLListLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	parts := strings.Split(line, ",")
+	imgURL := strings.TrimSpace(parts[0])
+	if err := validateImageURL(imgURL); err != nil {
+		return "", nil, err
+	}
+	tags := normalizeTags(parts[1:])
+	if tags == nil {
+		// 没有标签时保存为空数组而不是 NULL
+		tags = []string{}
+	}
+	return imgURL, tags, nil
+}
+
+// upsert 按 URL 插入或更新一张图片的标签，并把结果计入汇总
+func (sum *importSummary) upsert(ctx context.Context, imgURL string, tags []string) error {
+	// xmax = 0 表示这一行是新插入的，否则是冲突后更新的
+	var inserted bool
+	err := dbpool.QueryRow(ctx, `INSERT INTO images (url, tags) VALUES ($1, $2)
+		ON CONFLICT (url) DO UPDATE SET tags = EXCLUDED.tags, deleted_at = NULL
+		RETURNING (xmax = 0)`, imgURL, tags).Scan(&inserted)
+	switch {
+	case err != nil:
+		sum.Skipped++
+	case inserted:
+		sum.Inserted++
+	default:
+		sum.Updated++
+	}
+	return err
+}
+
+// audit 把导入结果计入当前请求的审计日志
+func (sum importSummary) audit(r *http.Request) {
+	addAuditValues(r, "inserted", strconv.Itoa(sum.Inserted))
+	addAuditValues(r, "updated", strconv.Itoa(sum.Updated))
+	addAuditValues(r, "skipped", strconv.Itoa(sum.Skipped))
+}
+
+// String 返回用于页面提示的导入结果
+func (sum importSummary) String() string {
+	return fmt.Sprintf("导入完成: 新增 %d，更新 %d，跳过 %d", sum.Inserted, sum.Updated, sum.Skipped)
+}
+
+// csvTagSeparator 分隔 CSV 中 tags 列的多个标签，逗号已被 CSV 本身使用
+const csvTagSeparator = "|"
+
+// adminExportCSVHandler 以 CSV 导出全部图片（id,url,tags），逐行从游标读取并写出，不在内存中缓存整表
+func adminExportCSVHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := dbpool.Query(r.Context(), "SELECT id, url, tags FROM images WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		http.Error(w, "无法导出图片", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="rangpic-images.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "url", "tags"})
+	for rows.Next() {
+		var img Image
+		if err := rows.Scan(&img.ID, &img.URL, &img.Tags); err != nil {
+			requestLogger(r).Error("扫描图片数据失败", "err", err)
+			return
+		}
+		if err := cw.Write([]string{strconv.Itoa(img.ID), img.URL, strings.Join(img.Tags, csvTagSeparator)}); err != nil {
+			requestLogger(r).Warn("导出 CSV 中断", "err", err)
+			return
+		}
+	}
+	cw.Flush()
+	if err := errors.Join(rows.Err(), cw.Error()); err != nil {
+		requestLogger(r).Warn("导出 CSV 中断", "err", err)
+	}
+}
+
+// adminImportCSVHandler 导入 adminExportCSVHandler 导出的 CSV 文件（表单字段 file）。
+// id 列会被忽略，按 URL 插入新图片或更新已有图片的标签，URL 为空或无效的行计入跳过
+func adminImportCSVHandler(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "请选择要导入的 CSV 文件", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = 3
+	var sum importSummary
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("CSV 格式错误: %v", err), http.StatusBadRequest)
+			return
+		}
+		if line == 1 && record[0] == "id" {
+			continue
+		}
+		imgURL := strings.TrimSpace(record[1])
+		if err := validateImageURL(imgURL); err != nil {
+			requestLogger(r).Warn("跳过 URL 无效的 CSV 行", "line", line, "err", err)
+			sum.Skipped++
+			continue
+		}
+		tags := normalizeTags(strings.Split(record[2], csvTagSeparator))
+		if tags == nil {
+			// 没有标签时保存为空数组而不是 NULL
+			tags = []string{}
+		}
+		if err := sum.upsert(r.Context(), imgURL, tags); err != nil {
+			requestLogger(r).Warn("无法导入 CSV 中的行", "line", line, "err", err)
+		} else {
+			addAuditValues(r, "url", imgURL)
+		}
+	}
+	sum.audit(r)
+	wakeMetadataWorker()
+	setFlash(w, sum.String())
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+// adminExportJSONHandler 以 JSON 数组导出全部图片（完整的 Image 结构），用于在实例之间迁移。
+// 逐行从游标读取并用 json.Encoder 写出，不在内存中缓存整表
+func adminExportJSONHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := dbpool.Query(r.Context(), "SELECT "+imageColumns+" FROM images WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		http.Error(w, "无法导出图片", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="rangpic-images.json"`)
+	enc := json.NewEncoder(w)
+	io.WriteString(w, "[\n")
+	for first := true; rows.Next(); first = false {
+		img, err := scanImage(rows)
+		if err != nil {
+			requestLogger(r).Error("扫描图片数据失败", "err", err)
+			return
+		}
+		if !first {
+			io.WriteString(w, ",")
+		}
+		if err := enc.Encode(img); err != nil {
+			requestLogger(r).Warn("导出 JSON 中断", "err", err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		requestLogger(r).Warn("导出 JSON 中断", "err", err)
+		return
+	}
+	io.WriteString(w, "]\n")
+}
+
+// adminImportJSONHandler 导入 adminExportJSONHandler 导出的文件（表单字段 file）。
+// 按 URL 插入或更新标签、权重、署名、收藏状态和已计算的元数据；id 和访问次数不会导入。
+// 文件必须是 JSON 数组，其中格式不对、URL 无效或权重越界的条目计入跳过
+func adminImportJSONHandler(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "请选择要导入的 JSON 文件", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	var items []json.RawMessage
+	if err := json.NewDecoder(file).Decode(&items); err != nil {
+		http.Error(w, "文件必须是图片对象组成的 JSON 数组: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var sum importSummary
+	for i, raw := range items {
+		// 逐条解码，缺少 weight 字段的条目使用默认权重 1
+		img := Image{Weight: 1}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&img); err != nil {
+			requestLogger(r).Warn("跳过格式错误的 JSON 条目", "index", i, "err", err)
+			sum.Skipped++
+			continue
+		}
+		img.URL = strings.TrimSpace(img.URL)
+		if err := validateImageURL(img.URL); err != nil {
+			requestLogger(r).Warn("跳过 URL 无效的 JSON 条目", "index", i, "err", err)
+			sum.Skipped++
+			continue
+		}
+		if img.Weight < 0 || img.Weight > maxImageWeight {
+			requestLogger(r).Warn("跳过权重越界的 JSON 条目", "index", i, "weight", img.Weight)
+			sum.Skipped++
+			continue
+		}
+		img.Tags = normalizeTags(img.Tags)
+		// 颜色只用于排序，格式不对时丢弃，由后台任务重新计算
+		if img.Color != "" {
+			if c, _, err := parseHexColor(img.Color); err == nil {
+				img.Color = c
+			} else {
+				img.Color = ""
+			}
+		}
+
+		// 未计算过的元数据写为 NULL，交给后台任务补算；新插入的图片沿用原实例的添加时间
+		var createdAt *time.Time
+		if !img.CreatedAt.IsZero() {
+			createdAt = &img.CreatedAt
+		}
+		var inserted bool
+		err := dbpool.QueryRow(r.Context(), `INSERT INTO images (url, tags, weight, blurhash, width, height, bytes, source, author, created_at, starred, color)
+			VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, 0), NULLIF($6, 0), NULLIF($7, 0), NULLIF($8, ''), NULLIF($9, ''), COALESCE($10, now()), $11, NULLIF($12, ''))
+			ON CONFLICT (url) DO UPDATE SET tags = EXCLUDED.tags, weight = EXCLUDED.weight,
+				source = EXCLUDED.source, author = EXCLUDED.author, starred = EXCLUDED.starred, deleted_at = NULL,
+				blurhash = COALESCE(EXCLUDED.blurhash, images.blurhash),
+				color = COALESCE(EXCLUDED.color, images.color),
+				width = COALESCE(EXCLUDED.width, images.width),
+				height = COALESCE(EXCLUDED.height, images.height),
+				bytes = COALESCE(EXCLUDED.bytes, images.bytes)
+			RETURNING (xmax = 0)`,
+			img.URL, img.Tags, img.Weight, img.Blurhash, img.Width, img.Height, img.Bytes, img.Source, img.Author, createdAt, img.Starred, img.Color).Scan(&inserted)
+		switch {
+		case err != nil:
+			requestLogger(r).Warn("无法导入 JSON 条目", "index", i, "err", err)
+			sum.Skipped++
+			continue
+		case inserted:
+			sum.Inserted++
+		default:
+			sum.Updated++
+		}
+		addAuditValues(r, "url", img.URL)
+	}
+	sum.audit(r)
+	if sum.Inserted+sum.Updated > 0 {
+		refreshWeightsInUse(r.Context())
+		wakeMetadataWorker()
+	}
+	setFlash(w, sum.String())
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
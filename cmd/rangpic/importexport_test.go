@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseURLListLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		url     string
+		tags    []string
+		wantErr bool
+	}{
+		{"", "", nil, false},
+		{"   ", "", nil, false},
+		{"# 注释", "", nil, false},
+		{"  #https://example.com/a.jpg,desktop", "", nil, false},
+		{"https://example.com/a.jpg", "https://example.com/a.jpg", []string{}, false},
+		{" https://example.com/a.jpg , Desktop, nature ,desktop,", "https://example.com/a.jpg", []string{"desktop", "nature"}, false},
+		{"/local/sub/a.png,mobile", "/local/sub/a.png", []string{"mobile"}, false},
+		{",desktop", "", nil, true},
+		{"not a url,desktop", "", nil, true},
+		{"ftp://example.com/a.jpg", "", nil, true},
+	}
+	for _, tt := range tests {
+		imgURL, tags, err := parseURLListLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if imgURL != tt.url || (tt.tags != nil && !reflect.DeepEqual(tags, tt.tags)) {
+			t.Errorf("%q: got %q %q, want %q %q", tt.line, imgURL, tags, tt.url, tt.tags)
+		}
+	}
+}
+
+func TestImportURLList(t *testing.T) {
+	testDB(t)
+	insertTestImage(t, "https://example.com/old.jpg", "stale")
+	list := `# 种子数据
+https://example.com/old.jpg,Fresh
+
+https://example.com/new.jpg,desktop,nature
+,missing-url
+https://example.com/untagged.jpg
+`
+	sum, err := importURLList(context.Background(), strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (importSummary{Inserted: 2, Updated: 1, Skipped: 1}) {
+		t.Errorf("got %+v", sum)
+	}
+	var tags []string
+	if err := dbpool.QueryRow(context.Background(), "SELECT tags FROM images WHERE url = $1", "https://example.com/old.jpg").Scan(&tags); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"fresh"}) {
+		t.Errorf("已存在的 URL 应使用文件中的标签，got %q", tags)
+	}
+	var untagged int
+	if err := dbpool.QueryRow(context.Background(), "SELECT COUNT(*) FROM images WHERE tags = '{}'").Scan(&untagged); err != nil || untagged != 1 {
+		t.Errorf("没有标签的行应保存为空数组，got %d, %v", untagged, err)
+	}
+}
+
+// imageTags 返回 url 到标签的映射，标签以 | 连接，用于比较导入导出前后的数据
+func imageTags(t *testing.T) map[string]string {
+	t.Helper()
+	rows, err := dbpool.Query(context.Background(), "SELECT url, COALESCE(tags, '{}') FROM images WHERE deleted_at IS NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := map[string]string{}
+	for rows.Next() {
+		var url string
+		var tags []string
+		if err := rows.Scan(&url, &tags); err != nil {
+			t.Fatal(err)
+		}
+		got[url] = strings.Join(tags, "|")
+	}
+	return got
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	testDB(t)
+	insertTestImage(t, "https://example.com/a.jpg", "desktop", "nature")
+	insertTestImage(t, "https://example.com/b.jpg?x=1,2", "with, comma")
+	insertTestImage(t, "/local/c.png")
+	before := imageTags(t)
+
+	rec := httptest.NewRecorder()
+	adminExportCSVHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/export.csv", nil))
+	if !strings.Contains(rec.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("导出应作为附件下载，got %q", rec.Header().Get("Content-Disposition"))
+	}
+	exported := rec.Body.Bytes()
+
+	if _, err := dbpool.Exec(context.Background(), "TRUNCATE images RESTART IDENTITY"); err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "rangpic-images.csv")
+	fw.Write(exported)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/admin/import.csv", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec = httptest.NewRecorder()
+	adminImportCSVHandler(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("导入失败: %d %s", rec.Code, rec.Body)
+	}
+
+	if after := imageTags(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("导出再导入后数据不一致:\n before %v\n after  %v", before, after)
+	}
+}
+
+func TestCSVImportSkipsInvalidRows(t *testing.T) {
+	testDB(t)
+	csvData := "id,url,tags\n" +
+		"1,https://example.com/ok.jpg,a|b\n" +
+		"2,javascript:alert(1),x\n" +
+		"3,ftp://example.com/x.jpg,\n" +
+		"4,/local/../etc/passwd,\n" +
+		"5,,\n" +
+		"6,https://example.com/untagged.jpg,\n"
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "images.csv")
+	fw.Write([]byte(csvData))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/admin/import.csv", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	adminImportCSVHandler(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("导入失败: %d %s", rec.Code, rec.Body)
+	}
+	if msg, want := flashMessage(rec), "导入完成: 新增 2，更新 0，跳过 4"; msg != want {
+		t.Errorf("flash = %q, want %q", msg, want)
+	}
+
+	var urls []string
+	var nullTags int
+	if err := dbpool.QueryRow(context.Background(), "SELECT array_agg(url ORDER BY id), COUNT(*) FILTER (WHERE tags IS NULL) FROM images").Scan(&urls, &nullTags); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://example.com/ok.jpg", "https://example.com/untagged.jpg"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("导入的 URL = %v, want %v", urls, want)
+	}
+	if nullTags != 0 {
+		t.Errorf("没有标签的行应保存为空数组，有 %d 行为 NULL", nullTags)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+)
+
+// --- 失效链接检查 ---
+
+// brokenTag 是 check-links --tag-broken 为失效图片添加的标签
+const brokenTag = "broken"
+
+// linkCheckResult 是一张远程图片的检查结果，Err 为 nil 表示链接有效
+type linkCheckResult struct {
+	Image Image
+	Err   error
+}
+
+// runCheckLinks 实现 `rangpic check-links [--concurrency N] [--tag-broken]`：
+// 并发检查所有远程图片，逐行打印失效和因访问策略跳过的图片并输出汇总。
+// --tag-broken 时为失效图片加上 broken 标签，并去掉已恢复图片上的该标签，跳过的图片保持不变
+func runCheckLinks(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("check-links", flag.ContinueOnError)
+	concurrency := fs.Int("concurrency", 8, "同时检查的链接数")
+	tagBroken := fs.Bool("tag-broken", false, "为失效的图片添加 broken 标签")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency <= 0 {
+		return fmt.Errorf("concurrency 必须是正整数")
+	}
+
+	rows, err := dbpool.Query(ctx, "SELECT id, url FROM images WHERE deleted_at IS NULL AND url NOT LIKE '/local/%' ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("查询图片失败: %w", err)
+	}
+	var images []Image
+	for rows.Next() {
+		var img Image
+		if err := rows.Scan(&img.ID, &img.URL); err != nil {
+			rows.Close()
+			return err
+		}
+		images = append(images, img)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// 检查与添加图片时相同，经过 validateDownloadURL 并使用 downloadClient，每个链接最多等待 15 秒
+	jobs := make(chan Image)
+	results := make(chan linkCheckResult)
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for img := range jobs {
+				results <- linkCheckResult{Image: img, Err: checkImageURL(ctx, img.URL)}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, img := range images {
+			select {
+			case jobs <- img:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var brokenIDs, okIDs, skippedIDs []int
+	for res := range results {
+		switch {
+		case res.Err == nil:
+			okIDs = append(okIDs, res.Image.ID)
+		case errors.Is(res.Err, errPolicyRejected):
+			// 没有真正请求图片，不能据此判断链接失效
+			skippedIDs = append(skippedIDs, res.Image.ID)
+			fmt.Printf("%d\t%s\t已跳过: %v\n", res.Image.ID, res.Image.URL, res.Err)
+		default:
+			brokenIDs = append(brokenIDs, res.Image.ID)
+			fmt.Printf("%d\t%s\t%v\n", res.Image.ID, res.Image.URL, res.Err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fmt.Printf("检查完成: 共 %d 个远程链接，有效 %d，失效 %d，因访问策略跳过 %d\n", len(images), len(okIDs), len(brokenIDs), len(skippedIDs))
+
+	if *tagBroken {
+		tag, err := dbpool.Exec(ctx, "UPDATE images SET tags = array_append(COALESCE(tags, '{}'), $1) WHERE id = ANY($2) AND NOT ($1 = ANY(COALESCE(tags, '{}')))", brokenTag, brokenIDs)
+		if err != nil {
+			return fmt.Errorf("标记失效图片失败: %w", err)
+		}
+		fmt.Printf("已为 %d 张图片添加 %s 标签\n", tag.RowsAffected(), brokenTag)
+		tag, err = dbpool.Exec(ctx, "UPDATE images SET tags = array_remove(tags, $1) WHERE id = ANY($2) AND $1 = ANY(tags)", brokenTag, okIDs)
+		if err != nil {
+			return fmt.Errorf("清除恢复图片的标记失败: %w", err)
+		}
+		if n := tag.RowsAffected(); n > 0 {
+			fmt.Printf("已从 %d 张恢复的图片上去掉 %s 标签\n", n, brokenTag)
+		}
+	}
+	return nil
+}
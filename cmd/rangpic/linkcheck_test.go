@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestProbePrivateHostIsPolicyRejection(t *testing.T) {
+	defer func(v bool) { allowPrivateDownload = v }(allowPrivateDownload)
+	allowPrivateDownload = false
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	if err := checkImageURL(context.Background(), srv.URL+"/a.png"); !errors.Is(err, errPolicyRejected) {
+		t.Errorf("内网地址应作为访问策略拒绝，got %v", err)
+	}
+	allowPrivateDownload = true
+	if err := checkImageURL(context.Background(), srv.URL+"/a.png"); err == nil || errors.Is(err, errPolicyRejected) {
+		t.Errorf("返回 404 的地址应视为失效，got %v", err)
+	}
+}
+
+func TestCheckLinksSkipsPolicyRejections(t *testing.T) {
+	testDB(t)
+	defer func(v bool) { allowPrivateDownload = v }(allowPrivateDownload)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+	}))
+	defer srv.Close()
+	dead := insertTestImage(t, srv.URL+"/dead.png", "cat")
+	ok := insertTestImage(t, srv.URL+"/ok.png", "cat", brokenTag)
+
+	ctx := context.Background()
+	tagsOf := func(id int) []string {
+		var tags []string
+		if err := dbpool.QueryRow(ctx, "SELECT tags FROM images WHERE id = $1", id).Scan(&tags); err != nil {
+			t.Fatal(err)
+		}
+		return tags
+	}
+
+	allowPrivateDownload = true
+	if err := runCheckLinks(ctx, []string{"--tag-broken"}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(tagsOf(dead), brokenTag) || slices.Contains(tagsOf(ok), brokenTag) {
+		t.Fatalf("失效图片应加上 %s 标签，恢复的图片应去掉: %v %v", brokenTag, tagsOf(dead), tagsOf(ok))
+	}
+
+	// 不允许访问内网时两张图片都被跳过，既不添加也不去掉标签
+	allowPrivateDownload = false
+	for id, tags := range map[int][]string{dead: {"cat"}, ok: {"cat", brokenTag}} {
+		if _, err := dbpool.Exec(ctx, "UPDATE images SET tags = $2 WHERE id = $1", id, tags); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runCheckLinks(ctx, []string{"--tag-broken"}); err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(tagsOf(dead), brokenTag) || !slices.Contains(tagsOf(ok), brokenTag) {
+		t.Errorf("因访问策略跳过的图片标签不应改变: %v %v", tagsOf(dead), tagsOf(ok))
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- 日志 ---
+
+// initLogger 把默认日志切换为 JSON 格式的 slog，级别由 LOG_LEVEL 控制（debug/info/warn/error，默认 info）。
+// 标准库 log 包的输出也会经由该 handler 以 info 级别输出
+func initLogger() {
+	var level slog.Level
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(strings.ToUpper(v))); err != nil {
+			fmt.Fprintf(os.Stderr, "LOG_LEVEL 无效: %q\n", v)
+			os.Exit(1)
+		}
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+}
+
+// fatal 记录错误日志后退出，用于启动阶段无法继续运行的情况
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestLogger 返回带有请求方法和路径字段的 logger，处理函数中的日志都应通过它输出
+func requestLogger(r *http.Request) *slog.Logger {
+	return slog.With("method", r.Method, "path", r.URL.Path)
+}
+
+// statusRecorder 记录处理函数写出的状态码和字节数，供访问日志使用
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// statusCode 返回写出的状态码，处理函数没有写出任何内容时按 200 计
+func (rec *statusRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// Flush 透传给底层的 ResponseWriter，保证流式转发图片时数据能及时发出
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 让 http.ResponseController 能访问底层的 ResponseWriter
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// loggingMiddleware 为每个请求输出一条访问日志，包含方法、路径、状态码、响应字节数和耗时
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		observeResponse(rec.status)
+		slog.Info("请求完成",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_ip", clientIP(r),
+		)
+	})
+}
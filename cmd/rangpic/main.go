@@ -2,31 +2,90 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"math"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // --- 数据结构 ---
 
 type Image struct {
-	ID   int      `json:"id"`
-	URL  string   `json:"url"`
-	Tags []string `json:"tags"`
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Tags      []string  `json:"tags"`
+	Blurhash  string    `json:"blurhash,omitempty"`
+	Color     string    `json:"color,omitempty"`
+	Width     int       `json:"width,omitempty"`
+	Height    int       `json:"height,omitempty"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Weight    int       `json:"weight"`
+	Views     int64     `json:"views"`
+	Source    string    `json:"source,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Starred   bool      `json:"starred"`
+}
+
+// publicImage 是公开 JSON 接口返回的图片。权重和浏览量属于后台管理数据，不对外公开：
+// 外层的同名字段优先于嵌入的 Image 字段，值为 nil 时随 omitempty 一起省略
+type publicImage struct {
+	Image
+	Weight *struct{} `json:"weight,omitempty"`
+	Views  *struct{} `json:"views,omitempty"`
+}
+
+func publicImages(images []Image) []publicImage {
+	out := make([]publicImage, len(images))
+	for i, img := range images {
+		out[i] = publicImage{Image: img}
+	}
+	return out
+}
+
+// imageColumns 是查询 Image 时统一使用的列，需与 scanImage 的顺序保持一致
+const imageColumns = `id, url, tags, COALESCE(blurhash, ''), COALESCE(color, ''), COALESCE(width, 0), COALESCE(height, 0), COALESCE(bytes, 0), weight, views, COALESCE(source, ''), COALESCE(author, ''), created_at, starred`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanImage(row rowScanner) (Image, error) {
+	var img Image
+	err := row.Scan(imageScanDest(&img)...)
+	return img, err
+}
+
+// imageScanDest 返回与 imageColumns 顺序一致的扫描目标，查询额外的列时可在其后追加
+func imageScanDest(img *Image) []interface{} {
+	return []interface{}{&img.ID, &img.URL, &img.Tags, &img.Blurhash, &img.Color, &img.Width, &img.Height, &img.Bytes, &img.Weight, &img.Views, &img.Source, &img.Author, &img.CreatedAt, &img.Starred}
 }
 
 type EditPageData struct {
@@ -34,6 +93,71 @@ type EditPageData struct {
 	IsDesktop bool
 	IsMobile  bool
 	OtherTags string
+	CSRFToken string
+}
+
+// ImageDetails 是后台详情接口返回的完整图片信息
+type ImageDetails struct {
+	Image
+	Local        bool              `json:"local"`
+	MimeType     string            `json:"mime_type,omitempty"`
+	FileSize     int64             `json:"file_size,omitempty"`
+	Reachability ImageReachability `json:"reachability"`
+}
+
+// ImageReachability 是详情接口中图片的可达性：本地图片检查文件是否存在，远程图片用 checkImageURL 的规则请求一次。
+// Status 为远程返回的 HTTP 状态码，未能发出请求或本地图片时为 0
+type ImageReachability struct {
+	OK     bool   `json:"ok"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// detailsCheckTimeout 限制详情接口检查远程图片可达性的时间，图床无响应时不拖慢整个请求
+const detailsCheckTimeout = 5 * time.Second
+
+// remoteReachability 请求远程图片并返回可达性，超过 detailsCheckTimeout 视为不可达
+func remoteReachability(ctx context.Context, imgURL string) ImageReachability {
+	ctx, cancel := context.WithTimeout(ctx, detailsCheckTimeout)
+	defer cancel()
+	status, err := probeImageURL(ctx, imgURL)
+	if err != nil {
+		return ImageReachability{Status: status, Error: err.Error()}
+	}
+	return ImageReachability{OK: true, Status: status}
+}
+
+// DashboardData 是后台图片列表页的分页数据
+type DashboardData struct {
+	Images      []Image
+	Query       string
+	Flash       string
+	Page        int
+	TotalPages  int
+	Total       int
+	Sort        string
+	CSRFToken   string
+	Maintenance bool
+}
+
+// dashboardSorts 是仪表盘 sort 参数可选的排序方式，未指定或无法识别时按 id 倒序
+var dashboardSorts = map[string]string{
+	"id":     "id DESC",
+	"newest": "created_at DESC, id DESC",
+	"oldest": "created_at, id",
+}
+
+// PlaceholderSetting 是一条"无匹配图片"占位图配置，Tag 为空表示全局占位图
+type PlaceholderSetting struct {
+	Tag      string
+	FileName string
+}
+
+// PlaceholdersPageData 是占位图设置页的数据
+type PlaceholdersPageData struct {
+	Placeholders []PlaceholderSetting
+	LocalFiles   []string
+	CSRFToken    string
 }
 
 type LocalFile struct {
@@ -41,67 +165,292 @@ type LocalFile struct {
 	ModTime time.Time
 }
 
-const localImagesPath = "/app/local_images"
+// LocalFilesPageData 是本地素材库页面的数据
+type LocalFilesPageData struct {
+	Files     []LocalFile
+	Flash     string
+	CSRFToken string
+}
+
+// shutdownTimeout 是收到退出信号后等待进行中请求完成的最长时间
+const shutdownTimeout = 15 * time.Second
+
+// dashboardPageSize 是后台图片列表每页显示的条数，保证单次渲染的数据量有上限
+const dashboardPageSize = 50
+
+// errNoImageFound 表示查询正常执行但没有匹配的图片，用于和数据库错误区分
+var errNoImageFound = errors.New("没有找到匹配的图片")
 
 var (
 	dbpool        *pgxpool.Pool
+	seedDataPath  string
 	adminUsername string
 	adminPassword string
-	sessions      = make(map[string]bool)
-	httpClient    = &http.Client{Timeout: 15 * time.Second}
-	templates     *template.Template
+	adminPassHash []byte
+	httpClient    = &http.Client{Timeout: 15 * time.Second, CheckRedirect: checkImageRedirect}
+	renderSlots   chan struct{}
+	maxQueryTags  int
+	debugMode     bool
+	// devMode 为 true 时每次渲染都重新解析模板，templateDir 中的 *.html 覆盖同名的内置页面
+	devMode     bool
+	templateDir string
+	// cookieSecure 为 true 时会话 cookie 只通过 HTTPS 发送，本地 HTTP 调试时保持关闭
+	cookieSecure bool
+	// apiToken 非空时，脚本可以用 Authorization: Bearer <token> 访问后台接口而无需登录
+	apiToken string
+
+	thumbnailWarmupWidths []int
+	maxUploadBytes        int64
+	// fallbackImage 是 FALLBACK_IMAGE_PATH 指向的兜底图片内容，启动时读入，未设置时为 nil
+	fallbackImage []byte
+	// imageCacheControl 来自 CACHE_CONTROL，用于随机图片接口返回的图片内容，为空时禁止缓存
+	imageCacheControl string
+	// maxDownloadBytes 来自 MAX_DOWNLOAD_BYTES，是从 URL 下载到本地素材库的单个文件大小上限，0 表示不限制
+	maxDownloadBytes int64
+
+	listenPort      = "17777"
+	localImagesPath = "/app/local_images"
 )
 
 // --- 主函数和初始化 ---
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
+	initLogger()
 	loadConfig()
 
 	var err error
 	dbpool, err = pgxpool.Connect(context.Background(), os.Getenv("DATABASE_URL"))
 	if err != nil {
-		log.Fatalf("无法连接到 PostgreSQL: %v", err)
+		fatal("无法连接到 PostgreSQL", "err", err)
 	}
 	defer dbpool.Close()
 
+	if replicaURL := os.Getenv("DATABASE_REPLICA_URL"); replicaURL != "" {
+		replicaPool, err = pgxpool.Connect(context.Background(), replicaURL)
+		if err != nil {
+			fatal("无法连接到只读副本", "err", err)
+		}
+		defer replicaPool.Close()
+		slog.Info("已连接只读副本", "fallback_to_primary", replicaFallback)
+	}
+
 	if err := initDB(context.Background()); err != nil {
-		log.Fatalf("数据库初始化失败: %v", err)
+		fatal("数据库初始化失败", "err", err)
 	}
+	refreshWeightsInUse(context.Background())
+	loadMaintenanceMode(context.Background())
 
-	parseTemplates()
-	setupRoutes()
+	// 收到 SIGINT/SIGTERM 时取消 ctx，后台任务随之退出，HTTP 服务开始优雅关闭
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	port := "17777"
-	log.Printf("服务器启动在 http://localhost:%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	// 带参数启动时执行一次性的子命令而不是启动 HTTP 服务
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, os.Args[1:]); err != nil {
+			fatal("命令执行失败", "err", err)
+		}
+		return
+	}
+
+	startMetadataWorker(ctx)
+	startJobWorker(ctx)
+	startSessionCleaner(ctx)
+	startTrashPurger(ctx)
+	startViewFlusher(ctx)
+	startMaintenanceWatcher(ctx)
+
+	initTemplates(devMode, templateDir)
+	handler := setupRoutes()
+
+	srv := &http.Server{Addr: ":" + listenPort, Handler: handler}
+	go func() {
+		slog.Info("服务器启动", "addr", "http://localhost:"+listenPort, "local_images_path", localImagesPath)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP 服务异常退出", "err", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("收到退出信号，等待进行中的请求完成", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("优雅关闭超时，强制断开剩余连接", "err", err)
+	}
+	flushViews(shutdownCtx)
+	slog.Info("HTTP 服务已停止，关闭数据库连接池")
 }
 
 func loadConfig() {
 	databaseUrl := os.Getenv("DATABASE_URL")
 	if databaseUrl == "" {
-		log.Fatal("DATABASE_URL 环境变量未设置")
+		fatal("DATABASE_URL 环境变量未设置")
+	}
+	if port := os.Getenv("PORT"); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			fatal("PORT 必须是 1-65535 之间的整数", "value", port)
+		}
+		listenPort = port
+	}
+	if dir := os.Getenv("LOCAL_IMAGES_PATH"); dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			fatal("LOCAL_IMAGES_PATH 无效", "err", err)
+		}
+		localImagesPath = abs
+	}
+	// 启动时就确保本地图片目录可创建，避免到第一次上传时才发现路径不可用
+	if err := os.MkdirAll(localImagesPath, os.ModePerm); err != nil {
+		fatal("无法创建本地图片目录", "path", localImagesPath, "err", err)
+	}
+	seedDataPath = os.Getenv("SEED_DATA_PATH")
+	if seedDataPath == "" {
+		seedDataPath = filepath.Join("data", "image_urls.txt")
 	}
 	adminUsername = os.Getenv("ADMIN_USERNAME")
 	if adminUsername == "" {
-		log.Fatal("ADMIN_USERNAME 环境变量未设置")
+		fatal("ADMIN_USERNAME 环境变量未设置")
+	}
+	// 同时设置时优先使用 bcrypt 哈希，明文密码仅为兼容旧部署保留
+	if hash := os.Getenv("ADMIN_PASSWORD_HASH"); hash != "" {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			fatal("ADMIN_PASSWORD_HASH 不是有效的 bcrypt 哈希", "err", err)
+		}
+		adminPassHash = []byte(hash)
+	} else {
+		adminPassword = os.Getenv("ADMIN_PASSWORD")
+		if adminPassword == "" {
+			fatal("ADMIN_PASSWORD 或 ADMIN_PASSWORD_HASH 环境变量未设置")
+		}
+	}
+	renderSlots = make(chan struct{}, max(1, envInt("MAX_CONCURRENT_RENDERS", 8)))
+	if maxQueryTags = envInt("MAX_QUERY_TAGS", 20); maxQueryTags < 1 {
+		fatal("MAX_QUERY_TAGS 必须至少为 1")
+	}
+	maxUploadBytes = int64(envInt("MAX_UPLOAD_MB", 20)) << 20
+	maxDownloadBytes = int64(envInt("MAX_DOWNLOAD_BYTES", 50<<20))
+	debugMode = os.Getenv("DEBUG") == "1"
+	devMode = os.Getenv("DEV_MODE") == "1"
+	templateDir = os.Getenv("TEMPLATE_DIR")
+	if templateDir == "" {
+		templateDir = filepath.Join("web", "templates")
+	}
+	cookieSecure = os.Getenv("COOKIE_SECURE") == "1"
+	apiToken = os.Getenv("API_TOKEN")
+	if path := os.Getenv("FALLBACK_IMAGE_PATH"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fatal("无法读取 FALLBACK_IMAGE_PATH", "path", path, "err", err)
+		}
+		if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+			fatal("FALLBACK_IMAGE_PATH 不是图片文件", "path", path)
+		}
+		fallbackImage = data
 	}
-	adminPassword = os.Getenv("ADMIN_PASSWORD")
-	if adminPassword == "" {
-		log.Fatal("ADMIN_PASSWORD 环境变量未设置")
+	imageCacheControl = os.Getenv("CACHE_CONTROL")
+	target, err := parseConvertTarget(os.Getenv("CONVERT_DOWNLOADS_TO"))
+	if err != nil {
+		fatal("CONVERT_DOWNLOADS_TO 配置无效", "err", err)
+	}
+	convertDownloadsTo = target
+	if sizePresets, err = parseSizePresets(os.Getenv("TAG_SIZE_PRESETS")); err != nil {
+		fatal("TAG_SIZE_PRESETS 配置无效", "err", err)
+	}
+	trustedProxyHops = envInt("TRUST_PROXY", 0)
+	if publicBaseURL, err = parsePublicBaseURL(os.Getenv("PUBLIC_BASE_URL")); err != nil {
+		fatal("PUBLIC_BASE_URL 配置无效", "err", err)
+	}
+	allowedOrigins = parseOrigins(os.Getenv("ALLOWED_ORIGINS"))
+	allowPrivateDownload = os.Getenv("ALLOW_PRIVATE_DOWNLOAD") == "1"
+	allowedImageHosts = parseHostList(os.Getenv("ALLOWED_IMAGE_HOSTS"))
+	if perMinute := envInt("PUBLIC_RATE_LIMIT", 0); perMinute > 0 {
+		publicLimiter = newTokenBucketLimiter(perMinute, envInt("PUBLIC_RATE_BURST", perMinute))
+	}
+	initPrefSecret(os.Getenv("PREF_COOKIE_SECRET"))
+	if sessionLifetime, err = parseSessionTTL(os.Getenv("SESSION_TTL")); err != nil {
+		fatal("SESSION_TTL 配置无效", "err", err)
+	}
+	loginGuard = newLoginLimiter(max(1, envInt("LOGIN_MAX_FAILURES", 5)), time.Minute, envDuration("LOGIN_LOCKOUT", time.Minute))
+	replicaFallback = os.Getenv("REPLICA_FALLBACK") != "0"
+	widths, err := parseWidthList(os.Getenv("THUMBNAIL_WARMUP_WIDTHS"))
+	if err != nil {
+		fatal("THUMBNAIL_WARMUP_WIDTHS 格式错误", "err", err)
+	}
+	thumbnailWarmupWidths = widths
+	if mb := envInt("IMAGE_CACHE_MB", 0); mb > 0 {
+		remoteImageCache = newImageCache(int64(mb)<<20, envDuration("IMAGE_CACHE_TTL", 10*time.Minute))
+	}
+	if mb := envInt("RESIZE_CACHE_MB", 64); mb > 0 {
+		resizedImageCache = newImageCache(int64(mb)<<20, envDuration("IMAGE_CACHE_TTL", 10*time.Minute))
+	}
+	// 拉取和下载远程图片共用同一个超时，慢速图床最多让请求等待这么久（重试时每次分别计时）
+	freshHalfLife = envDuration("FRESH_HALF_LIFE", freshHalfLife)
+	upstreamTimeout := envDuration("UPSTREAM_TIMEOUT", 15*time.Second)
+	httpClient.Timeout = upstreamTimeout
+	downloadClient.Timeout = upstreamTimeout
+	upstreamRetries = envInt("UPSTREAM_RETRIES", 0)
+	if size := envInt("PICK_POOL_SIZE", 0); size > 0 {
+		randomPool = newPickPool(size, envDuration("PICK_POOL_REFRESH", 500*time.Millisecond))
 	}
 }
 
-func setupRoutes() {
-	// 公开访问
-	http.HandleFunc("/", serveIndexPage)
-	http.HandleFunc("/random-image", randomImageProxyHandler)
-	http.HandleFunc("/api/random-image", randomImageAPIHandler)
-	http.HandleFunc("/api/tags", tagsAPIHandler)
+// envDuration 读取 Go duration 格式（如 500ms、2s）的环境变量，未设置时返回默认值
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		fatal(name+" 必须是正的时间间隔（如 500ms）", "value", v)
+	}
+	return d
+}
+
+// envInt 读取非负整数环境变量，未设置时返回默认值，格式错误时直接退出
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		fatal(name+" 必须是非负整数", "value", v)
+	}
+	return n
+}
 
-	// 本地图片静态文件服务
-	localFileServer := http.FileServer(http.Dir(localImagesPath))
-	http.Handle("/local/", http.StripPrefix("/local/", localFileServer))
+// setupRoutes 在默认 ServeMux 上注册所有路由，返回包裹了中间件的最终 handler
+func setupRoutes() http.Handler {
+	// 公开访问，返回 JSON 的接口经过 gzipMiddleware 压缩，图片接口不压缩；
+	// 图片和标签接口按 IP 共用 PUBLIC_RATE_LIMIT 限流，OpenAPI 文档不限流
+	limited := func(h http.Handler) http.Handler { return rateLimitMiddleware(publicLimiter, h) }
+	http.HandleFunc("/", serveIndexPage)
+	http.Handle("/random-image", corsMiddleware(limited(http.HandlerFunc(randomImageProxyHandler))))
+	http.Handle("GET /api/pref", limited(http.HandlerFunc(prefAPIHandler)))
+	http.Handle("POST /api/pref", limited(http.HandlerFunc(prefAPIHandler)))
+	http.Handle("/api/random-image", corsMiddleware(limited(gzipMiddleware(http.HandlerFunc(randomImageAPIHandler)))))
+	http.Handle("/api/random-image.datauri", corsMiddleware(limited(gzipMiddleware(http.HandlerFunc(randomImageDataURIHandler)))))
+	http.Handle("/api/random-images", corsMiddleware(limited(gzipMiddleware(http.HandlerFunc(randomImagesAPIHandler)))))
+	http.Handle("/api/image", corsMiddleware(limited(gzipMiddleware(http.HandlerFunc(imageAPIHandler)))))
+	http.Handle("/image", corsMiddleware(limited(http.HandlerFunc(imageHandler))))
+	http.Handle("/api/tags", corsMiddleware(limited(gzipMiddleware(http.HandlerFunc(tagsAPIHandler)))))
+	http.Handle("/api/tags/counts", corsMiddleware(limited(gzipMiddleware(http.HandlerFunc(tagCountsAPIHandler)))))
+	http.Handle("/api/openapi.json", corsMiddleware(gzipMiddleware(http.HandlerFunc(openAPIHandler))))
+	http.Handle("/feed", corsMiddleware(limited(gzipMiddleware(http.HandlerFunc(feedHandler)))))
+	http.Handle("/api/tags/suggest", corsMiddleware(limited(gzipMiddleware(http.HandlerFunc(tagSuggestAPIHandler)))))
+	// 带方法的路由不会匹配 OPTIONS，需要单独注册预检请求
+	http.Handle("GET /api/image/{id}/blurhash", corsMiddleware(limited(http.HandlerFunc(imageBlurhashHandler))))
+	http.Handle("OPTIONS /api/image/{id}/blurhash", corsMiddleware(http.NotFoundHandler()))
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.Handle("/metrics", promhttp.Handler())
+
+	// 本地图片静态文件服务，支持子目录
+	http.HandleFunc("/local/", localFileHandler)
+	http.HandleFunc("/local/thumb/", localThumbHandler)
 
 	// 管理后台
 	http.HandleFunc("/admin/login", adminLoginHandler)
@@ -110,12 +459,43 @@ func setupRoutes() {
 	http.Handle("/admin/add", authMiddleware(http.HandlerFunc(adminAddImageHandler)))
 	http.Handle("/admin/edit", authMiddleware(http.HandlerFunc(adminEditImageHandler)))
 	http.Handle("/admin/delete", authMiddleware(http.HandlerFunc(adminDeleteImageHandler)))
+	http.Handle("POST /admin/star", authMiddleware(http.HandlerFunc(adminStarImageHandler)))
+	http.Handle("GET /admin/duplicates", authMiddleware(http.HandlerFunc(adminDuplicatesHandler)))
+	http.Handle("GET /admin/trash", authMiddleware(http.HandlerFunc(adminTrashHandler)))
+	http.Handle("GET /admin/audit", authMiddleware(http.HandlerFunc(adminAuditHandler)))
+	http.Handle("POST /admin/trash/restore", authMiddleware(http.HandlerFunc(adminRestoreImageHandler)))
+	http.Handle("POST /admin/trash/purge", authMiddleware(http.HandlerFunc(adminPurgeImageHandler)))
+	http.Handle("/admin/placeholders", authMiddleware(http.HandlerFunc(adminPlaceholdersHandler)))
+	http.Handle("/admin/stats", authMiddleware(http.HandlerFunc(adminStatsHandler)))
+	http.Handle("GET /admin/tags", authMiddleware(http.HandlerFunc(adminTagsHandler)))
+	http.Handle("POST /admin/tags/rename", authMiddleware(http.HandlerFunc(adminRenameTagHandler)))
+	http.Handle("POST /admin/tags/delete", authMiddleware(http.HandlerFunc(adminDeleteTagHandler)))
+	http.Handle("POST /admin/tags/bulk", authMiddleware(http.HandlerFunc(adminBulkTagHandler)))
+	http.Handle("GET /admin/export.csv", authMiddleware(http.HandlerFunc(adminExportCSVHandler)))
+	http.Handle("POST /admin/import.csv", authMiddleware(http.HandlerFunc(adminImportCSVHandler)))
+	http.Handle("GET /admin/export.json", authMiddleware(gzipMiddleware(http.HandlerFunc(adminExportJSONHandler))))
+	http.Handle("POST /admin/import.json", authMiddleware(http.HandlerFunc(adminImportJSONHandler)))
+	http.Handle("/admin/urls.txt", authMiddleware(http.HandlerFunc(adminURLListHandler)))
+	http.Handle("POST /api/images", authMiddleware(http.HandlerFunc(batchAddImagesHandler)))
+	http.Handle("GET /api/images", authMiddleware(gzipMiddleware(http.HandlerFunc(listImagesAPIHandler))))
+	http.Handle("GET /admin/image/{id}/details", authMiddleware(gzipMiddleware(http.HandlerFunc(adminImageDetailsHandler))))
+	if debugMode {
+		http.Handle("GET /admin/debug/pick", authMiddleware(http.HandlerFunc(adminDebugPickHandler)))
+	}
 
 	// 后台本地素材库管理
 	http.Handle("/admin/local_files", authMiddleware(http.HandlerFunc(adminLocalFilesHandler)))
 	http.Handle("/admin/download", authMiddleware(http.HandlerFunc(adminDownloadURLHandler)))
+	http.Handle("POST /admin/backfill", authMiddleware(http.HandlerFunc(adminBackfillHandler)))
+	http.Handle("POST /admin/maintenance", authMiddleware(http.HandlerFunc(adminMaintenanceHandler)))
+	http.Handle("GET /admin/preview", authMiddleware(http.HandlerFunc(adminPreviewHandler)))
+	http.Handle("POST /admin/download/bulk", authMiddleware(http.HandlerFunc(adminBulkDownloadHandler)))
+	http.Handle("/admin/upload", authMiddleware(http.HandlerFunc(adminUploadHandler)))
 	http.Handle("/admin/rename_file", authMiddleware(http.HandlerFunc(adminRenameFileHandler)))
 	http.Handle("/admin/delete_file", authMiddleware(http.HandlerFunc(adminDeleteFileHandler)))
+	http.Handle("POST /admin/move_file", authMiddleware(http.HandlerFunc(adminMoveFileHandler)))
+
+	return loggingMiddleware(maintenanceMiddleware(http.DefaultServeMux))
 }
 
 // --- 数据库操作 ---
@@ -125,10 +505,75 @@ func initDB(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("无法创建表: %w", err)
 	}
+	// blurhash/width 为 NULL 表示尚未计算，空字符串和 0 表示源图无法解码
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS blurhash TEXT, ADD COLUMN IF NOT EXISTS width INTEGER, ADD COLUMN IF NOT EXISTS height INTEGER;`)
+	if err != nil {
+		return fmt.Errorf("无法添加元数据列: %w", err)
+	}
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS bytes BIGINT;`)
+	if err != nil {
+		return fmt.Errorf("无法添加 bytes 列: %w", err)
+	}
+	// weight 决定被随机选中的相对概率，0 表示永不返回
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS weight INTEGER NOT NULL DEFAULT 1;`)
+	if err != nil {
+		return fmt.Errorf("无法添加 weight 列: %w", err)
+	}
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS views BIGINT NOT NULL DEFAULT 0;`)
+	if err != nil {
+		return fmt.Errorf("无法添加 views 列: %w", err)
+	}
+	// 加列时已有的行统一记为迁移时间
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();`)
+	if err != nil {
+		return fmt.Errorf("无法添加 created_at 列: %w", err)
+	}
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS starred BOOLEAN NOT NULL DEFAULT false;`)
+	if err != nil {
+		return fmt.Errorf("无法添加 starred 列: %w", err)
+	}
+	// color 是主色调（#rrggbb），NULL 表示尚未计算，空字符串表示无法解码
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS color TEXT;`)
+	if err != nil {
+		return fmt.Errorf("无法添加 color 列: %w", err)
+	}
+	// phash 是感知哈希（aHash），NULL 表示尚未计算，0 表示无法解码
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS phash BIGINT;`)
+	if err != nil {
+		return fmt.Errorf("无法添加 phash 列: %w", err)
+	}
+	// content_hash 是本地图片文件内容的 SHA-256，用于发现以不同文件名重复添加的同一文件
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS content_hash TEXT;
+		CREATE INDEX IF NOT EXISTS images_content_hash_idx ON images (content_hash);`)
+	if err != nil {
+		return fmt.Errorf("无法添加 content_hash 列: %w", err)
+	}
+	// deleted_at 非空表示图片已移入回收站，超过 trashRetention 后才真正删除
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;`)
+	if err != nil {
+		return fmt.Errorf("无法添加 deleted_at 列: %w", err)
+	}
+	// source/author 用于署名，未填写时为 NULL
+	_, err = dbpool.Exec(ctx, `ALTER TABLE images ADD COLUMN IF NOT EXISTS source TEXT, ADD COLUMN IF NOT EXISTS author TEXT;`)
+	if err != nil {
+		return fmt.Errorf("无法添加署名列: %w", err)
+	}
 
-	// 确保本地图片目录存在
-	if err := os.MkdirAll(localImagesPath, os.ModePerm); err != nil {
-		return fmt.Errorf("无法创建本地图片目录: %w", err)
+	_, err = dbpool.Exec(ctx, `CREATE TABLE IF NOT EXISTS settings (key TEXT PRIMARY KEY, value TEXT NOT NULL);`)
+	if err != nil {
+		return fmt.Errorf("无法创建 settings 表: %w", err)
+	}
+	_, err = dbpool.Exec(ctx, `CREATE TABLE IF NOT EXISTS sessions (token TEXT PRIMARY KEY, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), expires_at TIMESTAMPTZ NOT NULL);`)
+	if err != nil {
+		return fmt.Errorf("无法创建 sessions 表: %w", err)
+	}
+	_, err = dbpool.Exec(ctx, `ALTER TABLE sessions ADD COLUMN IF NOT EXISTS csrf_token TEXT;`)
+	if err != nil {
+		return fmt.Errorf("无法添加 csrf_token 列: %w", err)
+	}
+	_, err = dbpool.Exec(ctx, `CREATE TABLE IF NOT EXISTS audit_log (id BIGSERIAL PRIMARY KEY, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), actor TEXT NOT NULL, ip TEXT, action TEXT NOT NULL, target TEXT, detail TEXT, status INTEGER NOT NULL);`)
+	if err != nil {
+		return fmt.Errorf("无法创建 audit_log 表: %w", err)
 	}
 
 	var count int
@@ -136,334 +581,1811 @@ func initDB(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("无法查询表计数: %w", err)
 	}
+	seedPath, err := filepath.Abs(seedDataPath)
+	if err != nil {
+		seedPath = seedDataPath
+	}
 	if count > 0 {
+		slog.Info("images 表已有数据，跳过种子数据导入", "path", seedPath)
 		return nil
 	}
 
-	file, err := os.Open(filepath.Join("data", "image_urls.txt"))
+	file, err := os.Open(seedPath)
 	if os.IsNotExist(err) {
+		slog.Info("未找到种子数据文件，跳过导入", "path", seedPath)
 		return nil
 	}
 	if err != nil {
-		return fmt.Errorf("无法打开 image_urls.txt: %w", err)
+		return fmt.Errorf("无法打开种子数据文件 %s: %w", seedPath, err)
 	}
 	defer file.Close()
 
-	log.Println("正在从 image_urls.txt 向数据库迁移数据...")
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-		parts := strings.Split(line, ",")
-		url := strings.TrimSpace(parts[0])
-		var tags []string
-		if len(parts) > 1 {
-			for _, tag := range parts[1:] {
-				if trimmed := strings.TrimSpace(tag); trimmed != "" {
-					tags = append(tags, trimmed)
-				}
-			}
-		}
-		_, err := dbpool.Exec(ctx, "INSERT INTO images (url, tags) VALUES ($1, $2) ON CONFLICT (url) DO NOTHING", url, tags)
-		if err != nil {
-			log.Printf("警告: 无法插入行 '%s': %v", line, err)
-		}
+	slog.Info("找到种子数据文件，正在向数据库迁移数据", "path", seedPath)
+	sum, err := importURLList(ctx, file)
+	if err != nil {
+		return fmt.Errorf("读取种子数据文件失败: %w", err)
+	}
+	slog.Info("数据迁移完成", "inserted", sum.Inserted, "skipped", sum.Skipped)
+	return nil
+}
+
+// getSetting 读取一项设置，不存在时返回空字符串和 false
+func getSetting(ctx context.Context, key string) (string, bool, error) {
+	var value string
+	err := dbpool.QueryRow(ctx, "SELECT value FROM settings WHERE key=$1", key).Scan(&value)
+	if err == pgx.ErrNoRows {
+		return "", false, nil
 	}
-	log.Println("数据迁移完成。")
-	return scanner.Err()
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func setSetting(ctx context.Context, key, value string) error {
+	_, err := dbpool.Exec(ctx, "INSERT INTO settings (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value", key, value)
+	return err
+}
+
+func deleteSetting(ctx context.Context, key string) error {
+	_, err := dbpool.Exec(ctx, "DELETE FROM settings WHERE key=$1", key)
+	return err
 }
 
 // --- 核心 API 和页面处理 ---
 
-func chooseRandomImage(ctx context.Context, tagQuery string) (Image, error) {
-	var img Image
+// imageFilter 描述一次随机选择的过滤条件，由 parseImageFilter 从查询参数解析
+type imageFilter struct {
+	Tags     []string // 已规范化的查询标签
+	MatchAny bool     // true 时只需包含任一标签，否则需包含全部标签
+	Exclude  []string // 已规范化的排除标签，包含其中任一标签的图片不会被选中
+	MinWidth int      // 期望的最小宽度，0 表示不限
+	AvoidID  int      // 客户端上一次拿到的图片 id，尽量不连续返回同一张，0 表示不限
+	Seed     string   // 非空时按种子确定性地选择，相同数据下总是返回同一张图片
+	Starred  bool     // true 时只在收藏的图片中选择
+	Fresh    bool     // true 时按添加时间衰减加权，新添加的图片更容易被选中
+	Balanced bool     // true 时先按分类大小的倒数选出一个分类标签，再在该分类中选择，见 chooseBalancedImage
+	// NearColor 非空（#rrggbb）时优先选择主色调接近该颜色的图片，NearRGB 为其三个通道的值
+	NearColor string
+	NearRGB   [3]int
+}
+
+// key 返回可用于缓存的过滤条件标识。AvoidID 不参与，预选池在挑选时单独处理
+func (f imageFilter) key() string {
+	return fmt.Sprintf("%s\x00%t\x00%s\x00%d\x00%s\x00%t\x00%t\x00%s\x00%t", strings.Join(f.Tags, ","), f.MatchAny, strings.Join(f.Exclude, ","), f.MinWidth, f.Seed, f.Starred, f.Fresh, f.NearColor, f.Balanced)
+}
+
+// maxSeedLength 限制 seed 参数的长度
+const maxSeedLength = 64
+
+// parseImageFilter 解析 tag/tags、match、exclude 以及宽度相关参数
+func parseImageFilter(q url.Values) (imageFilter, error) {
+	var f imageFilter
 	var err error
-	if tagQuery == "" {
-		query := `SELECT id, url, tags FROM images ORDER BY RANDOM() LIMIT 1`
-		err = dbpool.QueryRow(ctx, query).Scan(&img.ID, &img.URL, &img.Tags)
-	} else {
-		// Use EXISTS with unnest and LOWER for case-insensitive substring matching within the tags array
-		query := `SELECT id, url, tags FROM images WHERE EXISTS (SELECT 1 FROM unnest(tags) AS t WHERE LOWER(t) LIKE LOWER('%' || $1 || '%')) ORDER BY RANDOM() LIMIT 1`
-		err = dbpool.QueryRow(ctx, query, tagQuery).Scan(&img.ID, &img.URL, &img.Tags)
+	if f.Tags, err = parseTagParams(append(q["tag"], q["tags"]...)); err != nil {
+		return f, err
 	}
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return img, fmt.Errorf("没有找到匹配的图片")
+	if f.Exclude, err = parseTagParams(q["exclude"]); err != nil {
+		return f, err
+	}
+	if len(f.Tags)+len(f.Exclude) > maxQueryTags {
+		return f, fmt.Errorf("标签数量不能超过 %d 个", maxQueryTags)
+	}
+	switch q.Get("match") {
+	case "", "all":
+	case "any":
+		f.MatchAny = true
+	default:
+		return f, fmt.Errorf("match 只能是 any 或 all")
+	}
+	if f.MinWidth, err = parseMinWidth(q); err != nil {
+		return f, err
+	}
+	if v := q.Get("last"); v != "" {
+		if f.AvoidID, err = strconv.Atoi(v); err != nil || f.AvoidID <= 0 {
+			return f, fmt.Errorf("last 必须是图片 id")
+		}
+	}
+	if f.Seed = q.Get("seed"); len(f.Seed) > maxSeedLength {
+		return f, fmt.Errorf("seed 不能超过 %d 个字符", maxSeedLength)
+	}
+	f.Starred = q.Get("starred") == "1"
+	f.Fresh = q.Get("fresh") == "1"
+	f.Balanced = q.Get("balanced") == "1"
+	if v := q.Get("near_color"); v != "" {
+		if f.NearColor, f.NearRGB, err = parseHexColor(v); err != nil {
+			return f, fmt.Errorf("near_color: %w", err)
 		}
-		return img, err
 	}
-	return img, nil
+	return f, nil
 }
 
-func randomImageAPIHandler(w http.ResponseWriter, r *http.Request) {
-	tagQuery := r.URL.Query().Get("tags")
-	img, err := chooseRandomImage(r.Context(), tagQuery)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+// chooseRandomImage 在匹配标签的图片中随机挑选一张。MinWidth > 0 时优先选择宽度达标的图片，
+// 没有达标图片时依次退回到尺寸未知和偏小的图片，因此不会因为缺少尺寸数据而选不出图片。
+func chooseRandomImage(ctx context.Context, f imageFilter) (img Image, err error) {
+	defer func() { observeSelection(err) }()
+	img, err = chooseRandomImageOnce(ctx, f)
+	if errors.Is(err, errNoImageFound) && f.AvoidID != 0 {
+		// 候选集中只剩上一次返回的图片时，宁可重复也不返回空结果
+		f.AvoidID = 0
+		img, err = chooseRandomImageOnce(ctx, f)
 	}
-	log.Printf("提供 API 数据 (标签: '%s'): ID %d, URL %s", tagQuery, img.ID, img.URL)
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	json.NewEncoder(w).Encode(img)
+	return img, err
 }
 
-func randomImageProxyHandler(w http.ResponseWriter, r *http.Request) {
-	tagQuery := r.URL.Query().Get("tags")
-	img, err := chooseRandomImage(r.Context(), tagQuery)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+func chooseRandomImageOnce(ctx context.Context, f imageFilter) (Image, error) {
+	if f.Balanced {
+		return chooseBalancedImage(ctx, f)
 	}
-	log.Printf("提供图片 (标签: '%s'): %s", tagQuery, img.URL)
-
-	// 如果是本地 URL，直接从文件服务器内部重定向或提供服务
-	if strings.HasPrefix(img.URL, "/local/") {
-		http.ServeFile(w, r, filepath.Join(localImagesPath, strings.TrimPrefix(img.URL, "/local/")))
-		return
+	if useIDSeek(f) {
+		return chooseByIDSeek(ctx, f.AvoidID)
 	}
-
-	resp, err := httpClient.Get(img.URL)
+	images, err := chooseRandomImages(ctx, f, 1)
 	if err != nil {
-		log.Printf("请求图床图片 %s 失败: %v", img.URL, err)
-		http.Error(w, "无法获取图床图片", http.StatusInternalServerError)
-		return
+		return Image{}, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("图床 %s 返回错误状态码: %d", img.URL, resp.StatusCode)
-		http.Error(w, fmt.Sprintf("图床返回错误: %d", resp.StatusCode), http.StatusBadGateway)
-		return
+	if len(images) == 0 {
+		return Image{}, errNoImageFound
 	}
+	return images[0], nil
+}
 
-	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	_, err = io.Copy(w, resp.Body)
-	if err != nil {
-		log.Printf("将图片流写入响应失败: %v", err)
-	}
+// useIDSeek 判断能否走按 id 随机定位的快速路径。ORDER BY RANDOM() 每次都要对候选行全量排序，
+// 大表上很慢；但按 id 定位时，紧跟在被删除 id 区间之后的图片被选中的概率会偏高，
+// 在标签过滤后的稀疏集合上这种偏差会非常明显，因此只在没有任何过滤和排序偏好时使用。
+// 按 id 定位无法体现权重，因此存在非默认权重的图片时也不使用；按种子、新旧、颜色或分类均衡选择时同样不使用。
+func useIDSeek(f imageFilter) bool {
+	return len(f.Tags) == 0 && len(f.Exclude) == 0 && f.MinWidth == 0 && f.Seed == "" && !f.Starred && !f.Fresh && f.NearColor == "" && !f.Balanced && !weightsInUse.Load()
 }
 
-func serveIndexPage(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
+// weightsInUse 表示是否有图片的权重不是默认值 1，在启动和修改权重后刷新
+var weightsInUse atomic.Bool
+
+func refreshWeightsInUse(ctx context.Context) {
+	var inUse bool
+	if err := dbpool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM images WHERE weight <> 1 AND deleted_at IS NULL)").Scan(&inUse); err != nil {
+		slog.Error("查询图片权重失败", "err", err)
 		return
 	}
-	http.ServeFile(w, r, filepath.Join("web", "static", "index.html"))
+	weightsInUse.Store(inUse)
 }
 
-func tagsAPIHandler(w http.ResponseWriter, r *http.Request) {
-	query := `SELECT DISTINCT unnest(tags) as tag FROM images ORDER BY tag;`
-	rows, err := dbpool.Query(context.Background(), query)
+// idSeekQuery 从随机 id 开始向后取第一张图片，跳过 $2 指定的图片（为 0 时不跳过）
+const idSeekQuery = `SELECT ` + imageColumns + ` FROM images WHERE id >= $1 AND id <> $2 AND deleted_at IS NULL ORDER BY id LIMIT 1`
+
+// chooseByIDSeek 在 [1, MAX(id)] 中随机取一个 id，再取 id 不小于它的第一张图片
+func chooseByIDSeek(ctx context.Context, avoidID int) (Image, error) {
+	var maxID *int
+	if err := readQueryRow(ctx, "SELECT MAX(id) FROM images").Scan(&maxID); err != nil {
+		return Image{}, err
+	}
+	if maxID == nil {
+		return Image{}, errNoImageFound
+	}
+	img, err := scanImage(readQueryRow(ctx, idSeekQuery, rand.Intn(*maxID)+1, avoidID))
+	if err == pgx.ErrNoRows {
+		// 随机 id 之后没有可选图片（最大 id 恰好被删除或被跳过）时，从头开始取
+		img, err = scanImage(readQueryRow(ctx, idSeekQuery, 0, avoidID))
+	}
+	if err == pgx.ErrNoRows {
+		return Image{}, errNoImageFound
+	}
+	return img, err
+}
+
+// chooseRandomImages 按与 chooseRandomImage 相同的过滤和排序规则随机取出至多 limit 张图片
+func chooseRandomImages(ctx context.Context, f imageFilter, limit int) ([]Image, error) {
+	query, args := randomImageQuery(f, limit)
+	rows, err := readQuery(ctx, query, args...)
 	if err != nil {
-		http.Error(w, "无法获取标签列表", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
-
-	var tags []string
+	var images []Image
 	for rows.Next() {
-		var tag string
-		if err := rows.Scan(&tag); err != nil {
-			continue
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
 		}
-		tags = append(tags, tag)
+		images = append(images, img)
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	json.NewEncoder(w).Encode(tags)
+	return images, rows.Err()
 }
 
-// --- 后台认证和中间件 ---
+// lowerTagsExpr 是转为小写后的图片标签数组，用于不区分大小写的标签匹配
+const lowerTagsExpr = `ARRAY(SELECT LOWER(t) FROM unnest(tags) AS t)`
 
-func authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
-		if err != nil {
-			http.Redirect(w, r, "/admin/login", http.StatusFound)
-			return
-		}
-		if !sessions[cookie.Value] {
-			http.Redirect(w, r, "/admin/login", http.StatusFound)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// randomFilterClause 返回随机选择使用的 WHERE 子句及其参数，权重为 0 和已移入回收站的图片总是被排除
+func randomFilterClause(f imageFilter) (string, []interface{}) {
+	conds, args := imageFilterConds([]string{"weight > 0", "deleted_at IS NULL"}, nil, f)
+	return " WHERE " + strings.Join(conds, " AND "), args
 }
 
-func adminLoginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		r.ParseForm()
-		if r.FormValue("username") == adminUsername && r.FormValue("password") == adminPassword {
-			sessionToken := uuid.NewString()
-			sessions[sessionToken] = true
-			http.SetCookie(w, &http.Cookie{
-				Name:    "session_token",
-				Value:   sessionToken,
-				Expires: time.Now().Add(12 * time.Hour),
-				Path:    "/",
-			})
-			http.Redirect(w, r, "/admin", http.StatusFound)
-			return
+// imageFilterConds 把过滤条件中的标签、排除标签、收藏和 AvoidID 追加到 conds 和 args，
+// 参数序号接着 args 已有的参数编号
+func imageFilterConds(conds []string, args []interface{}, f imageFilter) ([]string, []interface{}) {
+	// 查询标签已由 parseTagParams 转为小写，这里同样比较小写后的图片标签：
+	// @> 要求包含全部查询标签，&& 只要求有交集
+	if len(f.Tags) > 0 {
+		op := "@>"
+		if f.MatchAny {
+			op = "&&"
 		}
+		args = append(args, f.Tags)
+		conds = append(conds, fmt.Sprintf("%s %s $%d::text[]", lowerTagsExpr, op, len(args)))
+	}
+	if len(f.Exclude) > 0 {
+		args = append(args, f.Exclude)
+		conds = append(conds, fmt.Sprintf("NOT (%s && $%d::text[])", lowerTagsExpr, len(args)))
+	}
+	if f.Starred {
+		conds = append(conds, "starred")
 	}
-	templates.ExecuteTemplate(w, "login.html", nil)
+	if f.AvoidID > 0 {
+		args = append(args, f.AvoidID)
+		conds = append(conds, fmt.Sprintf("id <> $%d", len(args)))
+	}
+	return conds, args
 }
 
-func adminLogoutHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_token")
-	if err == nil {
-		delete(sessions, cookie.Value)
+// weightedRandomOrder 按权重随机排序：每行的排序键服从速率为权重的指数分布，
+// 取最小者时每行被选中的概率与权重成正比。用 1 - RANDOM() 避免对 0 取对数；%s 为权重表达式
+const weightedRandomOrder = `-LN(1 - RANDOM()) / %s`
+
+// seededRandomOrder 与 weightedRandomOrder 相同，但用 hashtext(id || 种子) 映射到 [0, 1) 代替 RANDOM()，
+// 相同的种子和相同的数据总会得到相同的排序；%d 为种子参数的序号，%s 为权重表达式
+const seededRandomOrder = `-LN(1 - (hashtext(id::text || $%d)::bigint + 2147483648) / 4294967296.0) / %s`
+
+// freshWeightExpr 是 fresh=1 时的权重：刚添加的图片为 weight 的 1+freshBoost 倍，额外部分每过一个半衰期减半，
+// 最终回落到 weight。指数上限为 60，避免很旧的图片在 POWER 中下溢报错；%d 为半衰期秒数参数的序号
+const freshWeightExpr = `(weight * (1 + ` + freshBoost + ` * POWER(0.5::float8, LEAST(GREATEST(EXTRACT(EPOCH FROM now() - created_at)::float8 / $%d, 0), 60))))`
+
+// freshBoost 是新图片在 fresh=1 时额外获得的权重倍数
+const freshBoost = "9"
+
+// freshHalfLife 是 fresh=1 时额外权重的半衰期，由 FRESH_HALF_LIFE 设置
+var freshHalfLife = 72 * time.Hour
+
+// randomImageQuery 生成 chooseRandomImages 执行的完整 SQL 和参数
+func randomImageQuery(f imageFilter, limit int) (string, []interface{}) {
+	where, args := randomFilterClause(f)
+	query := `SELECT ` + imageColumns + ` FROM images` + where
+	weight := "weight"
+	if f.Fresh {
+		args = append(args, freshHalfLife.Seconds())
+		weight = fmt.Sprintf(freshWeightExpr, len(args))
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:   "session_token",
-		Value:  "",
-		MaxAge: -1,
-		Path:   "/",
-	})
-	http.Redirect(w, r, "/admin/login", http.StatusFound)
+	order := fmt.Sprintf(weightedRandomOrder, weight)
+	if f.Seed != "" {
+		args = append(args, f.Seed)
+		order = fmt.Sprintf(seededRandomOrder, len(args), weight)
+	}
+	if f.NearColor != "" {
+		args = append(args, f.NearRGB[0], f.NearRGB[1], f.NearRGB[2])
+		order = nearColorOrder(len(args)-2) + ", " + order
+	}
+	if f.MinWidth > 0 {
+		args = append(args, f.MinWidth)
+		order = fmt.Sprintf(`CASE WHEN width >= $%d THEN 0 WHEN COALESCE(width, 0) = 0 THEN 1 ELSE 2 END, %s`, len(args), order)
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` ORDER BY %s LIMIT $%d`, order, len(args))
+	return query, args
 }
 
-// --- 后台 CRUD 操作 ---
+// widthClass 与 chooseRandomImages 中的排序规则一致：0 为宽度达标，1 为尺寸未知，2 为偏小
+func widthClass(img Image, minWidth int) int {
+	switch {
+	case minWidth <= 0 || img.Width >= minWidth:
+		return 0
+	case img.Width == 0:
+		return 1
+	default:
+		return 2
+	}
+}
 
-func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := dbpool.Query(context.Background(), "SELECT id, url, tags FROM images ORDER BY id DESC")
+// parseTagParams 把查询参数值（可重复、也可逗号分隔）拆分为标签，用 normalizeTag 规范化并去重。
+// 标签数量超过 maxQueryTags 时返回错误，防止构造超大的 SQL 数组参数。
+func parseTagParams(values []string) ([]string, error) {
+	var raw []string
+	for _, v := range values {
+		raw = append(raw, strings.Split(v, ",")...)
+	}
+
+	tags := normalizeTags(raw)
+	if len(tags) > maxQueryTags {
+		return nil, fmt.Errorf("标签数量不能超过 %d 个", maxQueryTags)
+	}
+	return tags, nil
+}
+
+// parseMinWidth 从 min_width 以及 dpr/viewport_width 参数计算期望的最小图片宽度，
+// 两者同时给出时取较大值，均未给出时返回 0
+func parseMinWidth(q url.Values) (int, error) {
+	minWidth := 0
+	if v := q.Get("min_width"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("min_width 必须是非负整数")
+		}
+		minWidth = n
+	}
+
+	viewport := q.Get("viewport_width")
+	if viewport == "" {
+		return minWidth, nil
+	}
+	vw, err := strconv.Atoi(viewport)
+	if err != nil || vw <= 0 || vw > 10000 {
+		return 0, fmt.Errorf("viewport_width 必须是 1-10000 之间的整数")
+	}
+	dpr := 1.0
+	if v := q.Get("dpr"); v != "" {
+		dpr, err = strconv.ParseFloat(v, 64)
+		if err != nil || dpr <= 0 || dpr > 4 {
+			return 0, fmt.Errorf("dpr 必须是 0-4 之间的数字")
+		}
+	}
+	return max(minWidth, int(math.Ceil(float64(vw)*dpr))), nil
+}
+
+func randomImageAPIHandler(w http.ResponseWriter, r *http.Request) {
+	randomImageRequests.WithLabelValues("api").Inc()
+	filter, err := parseImageFilter(r.URL.Query())
 	if err != nil {
-		http.Error(w, "无法获取图片列表", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
+	img, err := pickRandomImage(r.Context(), filter)
+	if errors.Is(err, errNoImageFound) {
+		// 轮询的客户端可以通过 allow_empty=1 把"暂时没有匹配"与真正的错误区分开
+		if r.URL.Query().Get("allow_empty") == "1" {
+			w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("随机选择图片失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("提供 API 数据", "tags", filter.Tags, "image_id", img.ID, "url", img.URL)
+	// 客户端下次请求时可通过 ?last= 回传该 id，避免连续拿到同一张图片
+	w.Header().Set("X-Image-Id", strconv.Itoa(img.ID))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	json.NewEncoder(w).Encode(publicImage{Image: img})
+}
+
+// 随机图片列表 count 参数的默认值和上限
+const (
+	defaultRandomImagesCount = 10
+	maxRandomImagesCount     = 50
+)
+
+// randomImagesAPIHandler 一次返回至多 count 张互不重复的随机图片，过滤参数与 /api/random-image 相同。
+// 匹配的图片不足 count 张时返回全部匹配的图片，没有匹配时返回空数组
+func randomImagesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	randomImageRequests.WithLabelValues("api_list").Inc()
+	filter, err := parseImageFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	count := defaultRandomImagesCount
+	if v := r.URL.Query().Get("count"); v != "" {
+		if count, err = strconv.Atoi(v); err != nil || count <= 0 {
+			http.Error(w, "count 必须是正整数", http.StatusBadRequest)
+			return
+		}
+		count = min(count, maxRandomImagesCount)
+	}
+	images, err := chooseRandomImages(r.Context(), filter, count)
+	if err != nil {
+		requestLogger(r).Error("随机选择图片失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	for _, img := range images {
+		recordView(img.ID)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	json.NewEncoder(w).Encode(publicImages(images))
+}
+
+// randomImageProxyHandler 返回一张随机图片。远程图片默认由本服务拉取后转发（mode=proxy），
+// 客户端只和本服务通信，可以利用缓存、ETag，也不会暴露图床地址，但图片流量全部经过本服务。
+// mode=redirect 改为 302 跳转到图床地址，节省本服务带宽，代价是客户端直接访问图床，
+// 图床防盗链或不可达时无法兜底。本地图片不受 mode 影响，始终直接返回。
+func randomImageProxyHandler(w http.ResponseWriter, r *http.Request) {
+	randomImageRequests.WithLabelValues("proxy").Inc()
+	// 没有指定标签时使用访客通过 /api/pref 保存的偏好标签
+	q := r.URL.Query()
+	applyPreferredTag(w, r, q)
+	filter, err := parseImageFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := parseImageServeOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Variant = applySizePreset(opts.Variant, filter.Tags, q)
+	img, err := pickRandomImage(r.Context(), filter)
+	if errors.Is(err, errNoImageFound) {
+		if servePlaceholder(w, r, filter.Tags) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("随机选择图片失败", "err", err)
+		if serveFallbackImage(w, http.StatusInternalServerError) {
+			return
+		}
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("提供图片", "tags", filter.Tags, "image_id", img.ID, "url", img.URL)
+	// 客户端下次请求时可通过 ?last= 回传该 id，避免连续拿到同一张图片
+	w.Header().Set("X-Image-Id", strconv.Itoa(img.ID))
+
+	opts.CacheControl = revalidateCacheControl
+	if imageCacheControl != "" {
+		opts.CacheControl = imageCacheControl
+	}
+	serveImageBytes(w, r, img, opts)
+}
+
+// imageByIDFromQuery 按 ?id= 读取一张图片，id 无效或不存在时返回 errNoImageFound
+func imageByIDFromQuery(r *http.Request) (Image, error) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		return Image{}, errNoImageFound
+	}
+	img, err := scanImage(readQueryRow(r.Context(), "SELECT "+imageColumns+" FROM images WHERE id=$1 AND deleted_at IS NULL", id))
+	if err == pgx.ErrNoRows {
+		return Image{}, errNoImageFound
+	}
+	return img, err
+}
+
+// imageAPIHandler 按 id 返回单张图片的 JSON，便于客户端重新获取之前随机到的图片
+func imageAPIHandler(w http.ResponseWriter, r *http.Request) {
+	img, err := imageByIDFromQuery(r)
+	if errors.Is(err, errNoImageFound) {
+		http.Error(w, "未找到该图片", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("查询图片失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Image-Id", strconv.Itoa(img.ID))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(publicImage{Image: img})
+}
+
+// imageHandler 按 id 输出图片内容，支持与 /random-image 相同的 mode 和缩放、格式参数。
+// 不计入浏览量，浏览量只统计随机返回的次数
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseImageServeOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	img, err := imageByIDFromQuery(r)
+	if errors.Is(err, errNoImageFound) {
+		http.Error(w, "未找到该图片", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("查询图片失败", "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Image-Id", strconv.Itoa(img.ID))
+	// 同一个 id 对应的图片内容基本不变，总是允许缓存；URL 被修改后客户端可以凭 ETag 重新验证
+	opts.CacheControl = stableImageCacheControl
+	serveImageBytes(w, r, img, opts)
+}
+
+// imageServeOptions 是输出图片内容时客户端可以指定的参数
+type imageServeOptions struct {
+	Mode    string      // proxy（默认）或 redirect
+	Variant variantSpec // 缩放和格式转换
+	// CacheControl 由处理函数设置，成功返回图片（或跳转）时写入 Cache-Control 响应头
+	CacheControl string
+}
+
+const (
+	// noCacheControl 禁止客户端和 CDN 缓存，保证每次请求都重新随机
+	noCacheControl = "no-cache, no-store, must-revalidate"
+	// revalidateCacheControl 是随机图片的默认值：允许保存，但每次使用前都要向本服务重新验证，
+	// 因此仍然每次重新随机；随机到与客户端已有的同一张图片时，凭 ETag 得到 304 而不必重新下载
+	revalidateCacheControl = "no-cache"
+	// stableImageCacheControl 用于按 id 取图，内容稳定，允许客户端和 CDN 缓存一天
+	stableImageCacheControl = "public, max-age=86400"
+)
+
+// parseImageServeOptions 解析 mode 以及缩放、格式相关参数，应在选择图片之前调用，参数错误时不做无用的查询
+func parseImageServeOptions(r *http.Request) (imageServeOptions, error) {
+	opts := imageServeOptions{Mode: r.URL.Query().Get("mode")}
+	if opts.Mode != "" && opts.Mode != "proxy" && opts.Mode != "redirect" {
+		return opts, errors.New("mode 参数只能是 proxy 或 redirect")
+	}
+	var err error
+	opts.Variant, err = parseVariantSpec(r)
+	return opts, err
+}
+
+// serveImageBytes 输出一张图片的内容，随机图片和按 id 取图共用：
+// 按需缩放或转换格式，本地图片直接读文件，远程图片按 mode 转发或跳转
+func serveImageBytes(w http.ResponseWriter, r *http.Request, img Image, opts imageServeOptions) {
+	spec, mode := opts.Variant.negotiate(), opts.Mode
+	if r.URL.Query().Get("format") == "" && (spec.Width > 0 || spec.Height > 0) {
+		// 未指定 format 时缩放结果的格式取决于 Accept 头
+		w.Header().Add("Vary", "Accept")
+	}
+
+	// 设置了 ALLOWED_IMAGE_HOSTS 时，白名单之外的图床地址既不转发也不跳转
+	if !imageURLAllowed(img.URL) {
+		requestLogger(r).Warn("图片地址不在图床白名单中", "image_id", img.ID, "url", img.URL)
+		http.Error(w, "图片地址不在允许的图床列表中", http.StatusBadGateway)
+		return
+	}
+
+	// 下面的成功响应都使用处理函数指定的 Cache-Control，出错时由 imageError 改回禁止缓存
+	w.Header().Set("Cache-Control", opts.CacheControl)
+
+	// 需要缩放或转换格式时总是由服务端处理，mode=redirect 不生效；源图读取失败时按原图处理
+	if spec.active() && serveVariant(w, r, img.URL, spec) {
+		return
+	}
+
+	// 如果是本地 URL，直接从本地目录提供服务
+	if strings.HasPrefix(img.URL, "/local/") {
+		filePath, err := safeLocalPath(strings.TrimPrefix(img.URL, "/local/"))
+		if err != nil {
+			requestLogger(r).Warn("本地图片路径无效", "image_id", img.ID, "url", img.URL, "err", err)
+			imageError(w, "404 page not found", http.StatusNotFound)
+			return
+		}
+		serveLocalFile(w, r, filePath)
+		return
+	}
+
+	if mode == "redirect" {
+		// 跳转与图片内容使用相同的 Cache-Control：未设置 CACHE_CONTROL 时随机跳转每次都会重新验证
+		http.Redirect(w, r, img.URL, http.StatusFound)
+		return
+	}
+
+	if remoteImageCache != nil {
+		if contentType, data, ok := remoteImageCache.Get(img.URL); ok {
+			w.Header().Set("X-Cache", "HIT")
+			serveBytes(w, r, contentType, data)
+			return
+		}
+	}
+
+	// HEAD 请求只需要响应头，先向图床发 HEAD；图床不支持时退回下面的 GET，响应体由 net/http 丢弃
+	if r.Method == http.MethodHead && serveRemoteHead(w, r, img) {
+		return
+	}
+
+	// 客户端只请求部分内容时把 Range 转发给图床，图床支持时直接转发返回的片段，不必下载整张图片。
+	// 带 If-Range 的请求不转发：客户端的校验值是本服务生成的 ETag，图床无法识别
+	var upstreamHeader http.Header
+	forwardRange := r.Header.Get("Range") != "" && r.Header.Get("If-Range") == ""
+	if forwardRange {
+		upstreamHeader = http.Header{"Range": {r.Header.Get("Range")}}
+	}
+
+	fetchStart := time.Now()
+	resp, err := getWithRetryHeader(r.Context(), httpClient, img.URL, upstreamHeader)
+	if err != nil {
+		proxyFetchFailures.WithLabelValues("request").Inc()
+		requestLogger(r).Error("请求图床图片失败", "image_id", img.ID, "url", img.URL, "err", err)
+		imageError(w, "无法获取图床图片", http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+
+	if forwardRange && (resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable) {
+		forwardRangeResponse(w, r, img, resp)
+		return
+	}
+	// 图床忽略 Range 时返回完整图片，按普通请求处理，由 serveBytes 在本地截取客户端请求的范围
+	if resp.StatusCode != http.StatusOK {
+		proxyFetchFailures.WithLabelValues("status").Inc()
+		requestLogger(r).Error("图床返回错误状态码", "image_id", img.ID, "url", img.URL, "status", resp.StatusCode)
+		imageError(w, fmt.Sprintf("图床返回错误: %d", resp.StatusCode), http.StatusBadGateway)
+		return
+	}
+
+	// 先把图片读入内存以便计算 ETag 和写入缓存；超过 maxProxyBufferBytes 的图片直接流式转发
+	contentType := resp.Header.Get("Content-Type")
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxProxyBufferBytes+1))
+	if err != nil {
+		proxyFetchFailures.WithLabelValues("read").Inc()
+		requestLogger(r).Error("读取图床图片失败", "image_id", img.ID, "url", img.URL, "err", err)
+		imageError(w, "无法获取图床图片", http.StatusBadGateway)
+		return
+	}
+	proxyFetchDuration.Observe(time.Since(fetchStart).Seconds())
+	if int64(len(data)) > maxProxyBufferBytes {
+		w.Header().Set("Content-Type", contentType)
+		if _, err := io.Copy(w, io.MultiReader(bytes.NewReader(data), resp.Body)); err != nil {
+			requestLogger(r).Warn("将图片流写入响应失败", "image_id", img.ID, "err", err)
+		}
+		return
+	}
+
+	// 图床明确要求 no-store 时不缓存
+	if remoteImageCache != nil && !strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		w.Header().Set("X-Cache", "MISS")
+		remoteImageCache.Put(img.URL, contentType, data)
+	}
+	serveBytes(w, r, contentType, data)
+}
+
+// forwardRangeResponse 把图床对 Range 请求的 206 或 416 响应原样转发给客户端，片段不写入缓存
+func forwardRangeResponse(w http.ResponseWriter, r *http.Request, img Image, resp *http.Response) {
+	for _, name := range []string{"Content-Type", "Content-Range", "Content-Length"} {
+		if v := resp.Header.Get(name); v != "" {
+			w.Header().Set(name, v)
+		}
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		w.Header().Set("Cache-Control", noCacheControl)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		requestLogger(r).Warn("将图片片段写入响应失败", "image_id", img.ID, "err", err)
+	}
+}
+
+// serveRemoteHead 用图床对 HEAD 请求的响应头回答客户端的 HEAD 请求，不读取图片内容。
+// 图床返回非 200 或缺少 Content-Type 时返回 false，由调用方按 GET 处理
+func serveRemoteHead(w http.ResponseWriter, r *http.Request, img Image) bool {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodHead, img.URL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		requestLogger(r).Warn("向图床发送 HEAD 请求失败", "image_id", img.ID, "url", img.URL, "err", err)
+		return false
+	}
+	resp.Body.Close()
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK || contentType == "" {
+		return false
+	}
+	w.Header().Set("Content-Type", contentType)
+	if resp.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
+// maxProxyBufferBytes 是代理远程图片时为计算 ETag 而整体读入内存的大小上限
+const maxProxyBufferBytes = 32 << 20
+
+// serveBytes 以内容的 MD5 作为 ETag 输出图片，客户端带上匹配的 If-None-Match 时返回 304。
+// Cache-Control 由调用方预先设置
+func serveBytes(w http.ResponseWriter, r *http.Request, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// serveLocalFile 输出本地图片，ETag 由修改时间和文件大小生成，避免每次请求都读取整个文件计算摘要
+func serveLocalFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		imageError(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		imageError(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// imageError 输出文本错误，并把调用方为图片设置的 Cache-Control 改回禁止缓存，避免 CDN 缓存错误响应
+func imageError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Cache-Control", noCacheControl)
+	http.Error(w, msg, code)
+}
+
+// placeholderSettingKey 返回标签对应的占位图设置项，tag 为空时为全局占位图
+func placeholderSettingKey(tag string) string {
+	if tag == "" {
+		return "not_found_image"
+	}
+	return "not_found_image:" + tag
+}
+
+// servePlaceholder 在没有匹配图片时输出占位图，只查询了一个标签时先查找该标签的占位图，再查找全局占位图，
+// 最后使用 FALLBACK_IMAGE_PATH 兜底。占位图以 404 状态码返回，<img> 仍能正常显示，客户端也能知道没有匹配。
+// 没有可用的占位图时返回 false，由调用方输出文本错误。
+func servePlaceholder(w http.ResponseWriter, r *http.Request, tags []string) bool {
+	keys := []string{placeholderSettingKey("")}
+	if len(tags) == 1 {
+		keys = append([]string{placeholderSettingKey(tags[0])}, keys...)
+	}
+	for _, key := range keys {
+		fileName, ok, err := getSetting(r.Context(), key)
+		if err != nil {
+			requestLogger(r).Error("读取占位图设置失败", "key", key, "err", err)
+			break
+		}
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(localImagesPath, fileName))
+		if err != nil {
+			requestLogger(r).Error("读取占位图失败", "file", fileName, "err", err)
+			continue
+		}
+		writePlaceholder(w, data, http.StatusNotFound)
+		return true
+	}
+	return serveFallbackImage(w, http.StatusNotFound)
+}
+
+// serveFallbackImage 以给定状态码输出 FALLBACK_IMAGE_PATH 兜底图片，未配置时返回 false
+func serveFallbackImage(w http.ResponseWriter, status int) bool {
+	if fallbackImage == nil {
+		return false
+	}
+	writePlaceholder(w, fallbackImage, status)
+	return true
+}
+
+// writePlaceholder 输出占位图内容，占位图不应被缓存，以免图库恢复后客户端仍显示占位图
+func writePlaceholder(w http.ResponseWriter, data []byte, status int) {
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func serveIndexPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join("web", "static", "index.html"))
+}
+
+func tagsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT DISTINCT unnest(tags) as tag FROM images WHERE deleted_at IS NULL ORDER BY tag;`
+	rows, err := readQuery(r.Context(), query)
+	if err != nil {
+		http.Error(w, "无法获取标签列表", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// TagCount 是 /api/tags/counts 返回的单个标签及其图片数量
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+func tagCountsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT unnest(tags) AS tag, COUNT(*) AS count FROM images WHERE deleted_at IS NULL GROUP BY tag ORDER BY count DESC, tag;`
+	rows, err := readQuery(r.Context(), query)
+	if err != nil {
+		http.Error(w, "无法获取标签统计", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	counts := []TagCount{}
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			continue
+		}
+		counts = append(counts, tc)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(counts)
+}
+
+// maxTagSuggestions 是 /api/tags/suggest 返回的标签数量上限
+const maxTagSuggestions = 10
+
+// escapeLike 转义 LIKE 模式中的通配符，使输入按字面匹配
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// tagSuggestAPIHandler 返回包含 q 的标签（不区分大小写），以 q 开头的排在前面，其次按使用次数排序，
+// 最多 maxTagSuggestions 个。q 为空时返回使用最多的标签，供编辑页自动补全
+func tagSuggestAPIHandler(w http.ResponseWriter, r *http.Request) {
+	q := escapeLike(strings.TrimSpace(r.URL.Query().Get("q")))
+	query := `SELECT tag FROM images, unnest(tags) AS tag
+		WHERE deleted_at IS NULL AND tag ILIKE '%' || $1 || '%'
+		GROUP BY tag ORDER BY tag ILIKE $1 || '%' DESC, COUNT(*) DESC, tag LIMIT $2`
+	rows, err := readQuery(r.Context(), query, q, maxTagSuggestions)
+	if err != nil {
+		requestLogger(r).Error("查询标签建议失败", "err", err)
+		http.Error(w, "无法获取标签建议", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func imageBlurhashHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "无效的图片 ID", http.StatusBadRequest)
+		return
+	}
+	var hash *string
+	err = readQueryRow(r.Context(), "SELECT blurhash FROM images WHERE id=$1 AND deleted_at IS NULL", id).Scan(&hash)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "未找到该图片", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("查询 blurhash 失败", "id", id, "err", err)
+		http.Error(w, "查询图片失败", http.StatusInternalServerError)
+		return
+	}
+	if hash == nil || *hash == "" {
+		http.Error(w, "该图片暂无 blurhash", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]string{"blurhash": *hash})
+}
+
+// --- 后台认证和中间件 ---
+
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 带 Bearer 令牌的请求来自脚本而不是浏览器，不会被跨站伪造，因此不校验 CSRF 令牌
+		if token, ok := bearerToken(r); ok && apiToken != "" {
+			if !validAPIToken(token) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="rangpic"`)
+				http.Error(w, "API 令牌无效", http.StatusUnauthorized)
+				return
+			}
+			req := withAuditValues(r)
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, req)
+			auditRequest(req, "api-token", rec.statusCode())
+			return
+		}
+		cookie, err := r.Cookie("session_token")
+		if err != nil {
+			http.Redirect(w, r, "/admin/login", http.StatusFound)
+			return
+		}
+		csrf, ok, err := lookupSession(r.Context(), cookie.Value)
+		if err != nil {
+			requestLogger(r).Error("查询会话失败", "err", err)
+			http.Error(w, "无法验证登录状态", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Redirect(w, r, "/admin/login", http.StatusFound)
+			return
+		}
+		if !csrfSafeMethod(r.Method) && !validCSRFToken(r, csrf) {
+			requestLogger(r).Warn("CSRF 令牌校验失败", "path", r.URL.Path)
+			http.Error(w, "CSRF 令牌无效，请刷新页面后重试", http.StatusForbidden)
+			return
+		}
+		// 处理函数解析的表单保存在传入的请求副本上，审计时要读取同一个副本
+		req := withAuditValues(withCSRFToken(r, csrf))
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, req)
+		auditRequest(req, sessionActor(cookie.Value), rec.statusCode())
+	})
+}
+
+func adminLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		ip := clientIP(r)
+		if ok, wait := loginGuard.allow(ip, time.Now()); !ok {
+			w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			http.Error(w, "登录失败次数过多，请稍后再试", http.StatusTooManyRequests)
+			return
+		}
+		r.ParseForm()
+		if checkAdminCredentials(r.FormValue("username"), r.FormValue("password")) {
+			loginGuard.reset(ip)
+			sessionToken, expiresAt, err := createSession(r.Context())
+			if err != nil {
+				http.Error(w, "创建会话失败", http.StatusInternalServerError)
+				return
+			}
+			recordAudit(r, sessionActor(sessionToken), "login", r.FormValue("username"), "登录成功", http.StatusFound)
+			http.SetCookie(w, &http.Cookie{
+				Name:     "session_token",
+				Value:    sessionToken,
+				Expires:  expiresAt,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   cookieSecure,
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, r, "/admin", http.StatusFound)
+			return
+		}
+		loginGuard.fail(ip, time.Now())
+		requestLogger(r).Warn("登录失败", "ip", ip)
+		recordAudit(r, "anonymous", "login", truncateRunes(r.FormValue("username"), 64), "用户名或密码错误", http.StatusUnauthorized)
+	}
+	render(w, "login.html", nil)
+}
+
+// bearerToken 取出 Authorization: Bearer 请求头中的令牌
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// validAPIToken 以恒定时间比较请求携带的令牌与 API_TOKEN，未配置 API_TOKEN 时一律拒绝
+func validAPIToken(token string) bool {
+	return apiToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1
+}
+
+// checkAdminCredentials 以恒定时间比较用户名，密码优先用 bcrypt 哈希校验；
+// 用户名不匹配时也会完成密码校验，避免通过响应时间猜出用户名
+func checkAdminCredentials(username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(adminUsername)) == 1
+	var passOK bool
+	if adminPassHash != nil {
+		passOK = bcrypt.CompareHashAndPassword(adminPassHash, []byte(password)) == nil
+	} else {
+		passOK = subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) == 1
+	}
+	return userOK && passOK
+}
+
+func adminLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("session_token")
+	if err == nil {
+		recordAudit(r, sessionActor(cookie.Value), "logout", "", "", http.StatusFound)
+		if err := deleteSession(r.Context(), cookie.Value); err != nil {
+			requestLogger(r).Error("删除会话失败", "err", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/admin/login", http.StatusFound)
+}
+
+// --- 后台 CRUD 操作 ---
+
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	data := DashboardData{
+		Page:        page,
+		Query:       strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q"))),
+		Sort:        r.URL.Query().Get("sort"),
+		Flash:       popFlash(w, r),
+		CSRFToken:   csrfToken(r),
+		Maintenance: maintenanceOn.Load(),
+	}
+	if _, ok := dashboardSorts[data.Sort]; !ok {
+		data.Sort = "id"
+	}
+
+	// q 非空时按 URL 子串或完整标签搜索，标签比较不区分大小写
+	where := " WHERE deleted_at IS NULL"
+	var args []interface{}
+	if data.Query != "" {
+		where += ` AND (url ILIKE '%' || $1 || '%' OR $1 = ANY(` + lowerTagsExpr + `))`
+		args = append(args, data.Query)
+	}
+
+	if err := dbpool.QueryRow(r.Context(), "SELECT COUNT(*) FROM images"+where, args...).Scan(&data.Total); err != nil {
+		http.Error(w, "无法获取图片列表", http.StatusInternalServerError)
+		return
+	}
+	data.TotalPages = max(1, (data.Total+dashboardPageSize-1)/dashboardPageSize)
+
+	args = append(args, dashboardPageSize, (page-1)*dashboardPageSize)
+	rows, err := dbpool.Query(r.Context(),
+		fmt.Sprintf("SELECT %s FROM images%s ORDER BY %s LIMIT $%d OFFSET $%d", imageColumns, where, dashboardSorts[data.Sort], len(args)-1, len(args)),
+		args...)
+	if err != nil {
+		http.Error(w, "无法获取图片列表", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			requestLogger(r).Error("扫描图片数据失败", "err", err)
+			continue
+		}
+		data.Images = append(data.Images, img)
+	}
+	render(w, "dashboard.html", data)
+}
+
+func adminAddImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		imgURL := r.FormValue("url")
+		imageType := r.FormValue("image_type")
+		otherTagsStr := r.FormValue("other_tags")
+
+		finalTags := normalizeTags(append([]string{imageType}, strings.Split(otherTagsStr, ",")...))
+
+		weight, err := parseWeight(r.FormValue("weight"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateImageURL(imgURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// 勾选检查时确认 URL 确实指向图片，避免失效链接进入图库；个别图床拒绝探测请求时可取消勾选跳过
+		if r.FormValue("validate") == "1" {
+			if err := checkImageURL(r.Context(), imgURL); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// 本地文件记录内容哈希，同一文件以不同文件名再次添加时提醒管理员
+		hash, err := localContentHash(imgURL)
+		if err != nil {
+			requestLogger(r).Warn("计算本地文件哈希失败", "url", imgURL, "err", err)
+		}
+
+		_, err = dbpool.Exec(context.Background(), "INSERT INTO images (url, tags, weight, source, author, content_hash) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)",
+			imgURL, finalTags, weight, strings.TrimSpace(r.FormValue("source")), strings.TrimSpace(r.FormValue("author")), hash)
+		if isUniqueViolation(err) {
+			http.Error(w, duplicateURLMessage(r.Context(), imgURL), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "添加图片失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		refreshWeightsInUse(r.Context())
+		wakeMetadataWorker()
+		if strings.HasPrefix(imgURL, "/local/") {
+			warmupThumbnails(strings.TrimPrefix(imgURL, "/local/"))
+		}
+		if dupID, err := sameContentImage(r.Context(), hash, imgURL); err != nil {
+			requestLogger(r).Warn("查询相同内容的图片失败", "err", err)
+		} else if dupID != 0 {
+			setFlash(w, fmt.Sprintf("已添加，但该文件与图片 #%d 的内容完全相同，可能是重复图片", dupID))
+		}
+		http.Redirect(w, r, "/admin", http.StatusFound)
+		return
+	}
+
+	// 预填充来自本地素材库的文件
+	img := Image{Weight: 1}
+	if localFile := r.URL.Query().Get("local_file"); localFile != "" {
+		if _, err := safeLocalPath(localFile); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		img.URL = "/local/" + localFile
+	}
+
+	render(w, "edit.html", EditPageData{Image: img, CSRFToken: csrfToken(r)})
+}
+
+// batchImage 是 POST /api/images 请求体中的单个条目
+type batchImage struct {
+	URL  string   `json:"url"`
+	Tags []string `json:"tags"`
+}
+
+// batchAddResult 汇总批量添加的结果，URL 已存在的条目计入 Skipped
+type batchAddResult struct {
+	Inserted int `json:"inserted"`
+	Skipped  int `json:"skipped"`
+}
+
+// validateImageURL 检查 URL 非空且格式正确，本地素材使用 /local/ 前缀且不能指向素材库之外，其余必须是 http(s) 绝对地址
+func validateImageURL(imgURL string) error {
+	if imgURL == "" {
+		return errors.New("URL 不能为空")
+	}
+	if strings.HasPrefix(imgURL, "/local/") {
+		// 本地路径之后会被用来读取文件和生成缩略图，不能指向素材库之外
+		_, err := safeLocalPath(strings.TrimPrefix(imgURL, "/local/"))
+		return err
+	}
+	u, err := url.Parse(imgURL)
+	if err != nil {
+		return fmt.Errorf("URL 格式错误: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("URL 必须是 http(s) 地址或 /local/ 本地路径")
+	}
+	if !hostAllowed(u.Hostname()) {
+		return fmt.Errorf("主机 %s 不在 ALLOWED_IMAGE_HOSTS 白名单中", u.Hostname())
+	}
+	return nil
+}
+
+// maxBatchItems 和 maxBatchBodyBytes 限制 POST /api/images 一次提交的图片数量和请求体大小，
+// 整批在一个事务中插入，过大的批次会长时间占用连接
+const (
+	maxBatchItems     = 1000
+	maxBatchBodyBytes = 4 << 20
+)
+
+// checkImageURL 请求远程 URL，确认返回 2xx 且 Content-Type 为 image/*。先发 HEAD，
+// 图床不支持 HEAD（返回非 2xx）时再用 GET 只读取响应头确认。本地图片不检查。
+// 地址由管理员提交，与下载到本地素材库一样经过 validateDownloadURL 校验并使用 downloadClient，防止 SSRF
+func checkImageURL(ctx context.Context, imgURL string) error {
+	_, err := probeImageURL(ctx, imgURL)
+	return err
+}
+
+// probeImageURL 与 checkImageURL 相同，同时返回最后一次请求的状态码，未能发出请求或本地图片时为 0
+func probeImageURL(ctx context.Context, imgURL string) (int, error) {
+	if strings.HasPrefix(imgURL, "/local/") {
+		return 0, nil
+	}
+	u, err := validateDownloadURL(ctx, imgURL)
+	if err != nil {
+		return 0, fmt.Errorf("不允许访问该地址: %w", err)
+	}
+	var resp *http.Response
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+		if err != nil {
+			return 0, fmt.Errorf("URL 格式错误: %w", err)
+		}
+		resp, err = downloadClient.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("无法访问该 URL: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			break
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("该 URL 返回状态码 %d，请确认链接有效", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "image/") {
+		return resp.StatusCode, fmt.Errorf("该 URL 的 Content-Type 为 %q，不是图片。如确认链接有效，可取消勾选“检查 URL”后重新保存", contentType)
+	}
+	return resp.StatusCode, nil
+}
+
+func batchAddImagesHandler(w http.ResponseWriter, r *http.Request) {
+	var items []batchImage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)).Decode(&items); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("请求体不能超过 %d 字节", maxBatchBodyBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "请求体必须是 JSON 数组: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(items) > maxBatchItems {
+		http.Error(w, fmt.Sprintf("一次最多添加 %d 张图片", maxBatchItems), http.StatusRequestEntityTooLarge)
+		return
+	}
+	for i := range items {
+		items[i].URL = strings.TrimSpace(items[i].URL)
+		if err := validateImageURL(items[i].URL); err != nil {
+			http.Error(w, fmt.Sprintf("第 %d 条: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		items[i].Tags = normalizeTags(items[i].Tags)
+		if items[i].Tags == nil {
+			// 没有标签时保存为空数组而不是 NULL
+			items[i].Tags = []string{}
+		}
+	}
+
+	// 整批在一个事务中插入，出现意外的数据库错误时全部回滚
+	tx, err := dbpool.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "无法开始事务", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	var result batchAddResult
+	var inserted []string
+	for _, item := range items {
+		tag, err := tx.Exec(r.Context(), "INSERT INTO images (url, tags) VALUES ($1, $2) ON CONFLICT (url) DO NOTHING", item.URL, item.Tags)
+		if err != nil {
+			requestLogger(r).Error("批量添加图片失败，已回滚", "err", err)
+			http.Error(w, "添加图片失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			result.Skipped++
+		} else {
+			result.Inserted++
+			inserted = append(inserted, item.URL)
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "提交事务失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	addAuditValues(r, "url", inserted...)
+	addAuditValues(r, "inserted", strconv.Itoa(result.Inserted))
+	addAuditValues(r, "skipped", strconv.Itoa(result.Skipped))
+	if result.Inserted > 0 {
+		refreshWeightsInUse(r.Context())
+		wakeMetadataWorker()
+		for _, imgURL := range inserted {
+			if strings.HasPrefix(imgURL, "/local/") {
+				warmupThumbnails(strings.TrimPrefix(imgURL, "/local/"))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(result)
+}
+
+// maxImageWeight 是单张图片权重的上限
+const maxImageWeight = 1000
+
+// parseWeight 解析表单中的权重，留空时为默认值 1，0 表示永不返回
+func parseWeight(v string) (int, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > maxImageWeight {
+		return 0, fmt.Errorf("权重必须是 0-%d 之间的整数", maxImageWeight)
+	}
+	return n, nil
+}
+
+func adminEditImageHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		imgURL := r.FormValue("url")
+		imageType := r.FormValue("image_type")
+		otherTagsStr := r.FormValue("other_tags")
+
+		finalTags := normalizeTags(append([]string{imageType}, strings.Split(otherTagsStr, ",")...))
+
+		weight, err := parseWeight(r.FormValue("weight"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateImageURL(imgURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hash, err := localContentHash(imgURL)
+		if err != nil {
+			requestLogger(r).Warn("计算本地文件哈希失败", "url", imgURL, "err", err)
+		}
+
+		// URL 变化时清空元数据，交由后台任务重新计算
+		_, err = dbpool.Exec(context.Background(), `UPDATE images SET url=$1, tags=$2, weight=$3,
+			source = NULLIF($5, ''), author = NULLIF($6, ''), content_hash = $7,
+			blurhash = CASE WHEN url = $1 THEN blurhash END,
+			color = CASE WHEN url = $1 THEN color END,
+			width = CASE WHEN url = $1 THEN width END,
+			height = CASE WHEN url = $1 THEN height END,
+			bytes = CASE WHEN url = $1 THEN bytes END,
+			phash = CASE WHEN url = $1 THEN phash END
+			WHERE id=$4`, imgURL, finalTags, weight, id, strings.TrimSpace(r.FormValue("source")), strings.TrimSpace(r.FormValue("author")), hash)
+		if isUniqueViolation(err) {
+			http.Error(w, duplicateURLMessage(r.Context(), imgURL), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "更新图片失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		refreshWeightsInUse(r.Context())
+		wakeMetadataWorker()
+		http.Redirect(w, r, "/admin", http.StatusFound)
+		return
+	}
+
+	img, err := scanImage(dbpool.QueryRow(context.Background(), "SELECT "+imageColumns+" FROM images WHERE id=$1 AND deleted_at IS NULL", id))
+	if err != nil {
+		http.Error(w, "未找到该图片", http.StatusNotFound)
+		return
+	}
+
+	data := EditPageData{Image: img, CSRFToken: csrfToken(r)}
+	var otherTags []string
+	for _, t := range img.Tags {
+		if t == "desktop" {
+			data.IsDesktop = true
+		} else if t == "mobile" {
+			data.IsMobile = true
+		} else {
+			otherTags = append(otherTags, t)
+		}
+	}
+	data.OtherTags = strings.Join(otherTags, ", ")
+
+	render(w, "edit.html", data)
+}
+
+func adminDeleteImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "无效的请求方法", http.StatusMethodNotAllowed)
+		return
+	}
+	// 单条删除和仪表盘勾选的批量删除都以 id 表单值提交
+	ids := formIDs(r)
+	if len(ids) == 0 {
+		setFlash(w, "未选择要删除的图片")
+		http.Redirect(w, r, "/admin", http.StatusFound)
+		return
+	}
+
+	// 只标记删除时间，图片移入回收站，可在 /admin/trash 中恢复
+	tag, err := dbpool.Exec(context.Background(), "UPDATE images SET deleted_at = now() WHERE id = ANY($1) AND deleted_at IS NULL", ids)
+	if err != nil {
+		http.Error(w, "删除图片失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	refreshWeightsInUse(r.Context())
+	setFlash(w, fmt.Sprintf("已将 %d 张图片移入回收站", tag.RowsAffected()))
+	redirectBack(w, r)
+}
+
+// adminStarImageHandler 切换图片的收藏状态，完成后回到来源页面以保留分页和搜索条件
+func adminStarImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "无效的图片 ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := dbpool.Exec(r.Context(), "UPDATE images SET starred = NOT starred WHERE id = $1", id); err != nil {
+		http.Error(w, "更新收藏状态失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectBack(w, r)
+}
+
+// redirectBack 操作完成后回到提交表单的后台页面，保留其分页和搜索条件；来源不是后台页面时回到仪表盘
+func redirectBack(w http.ResponseWriter, r *http.Request) {
+	back := "/admin"
+	if ref, err := url.Parse(r.Referer()); err == nil && (ref.Path == "/admin" || strings.HasPrefix(ref.Path, "/admin/")) {
+		back = ref.RequestURI()
+	}
+	http.Redirect(w, r, back, http.StatusFound)
+}
+
+// adminURLListHandler 逐行输出所有图片 URL，加上 tags=1 时输出与 image_urls.txt 相同的 url,tag1,tag2 格式，
+// 可直接作为种子数据重新导入
+func adminURLListHandler(w http.ResponseWriter, r *http.Request) {
+	withTags := r.URL.Query().Get("tags") == "1"
+	rows, err := dbpool.Query(r.Context(), "SELECT url, tags FROM images WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		http.Error(w, "无法获取图片列表", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	for rows.Next() {
+		var imgURL string
+		var tags []string
+		if err := rows.Scan(&imgURL, &tags); err != nil {
+			requestLogger(r).Error("扫描图片数据失败", "err", err)
+			continue
+		}
+		bw.WriteString(imgURL)
+		if withTags && len(tags) > 0 {
+			bw.WriteString("," + strings.Join(tags, ","))
+		}
+		bw.WriteString("\n")
+	}
+	if err := rows.Err(); err != nil {
+		requestLogger(r).Warn("导出 URL 列表中断", "err", err)
+	}
+}
+
+func adminImageDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "未找到该图片", http.StatusNotFound)
+		return
+	}
+	img, err := scanImage(dbpool.QueryRow(r.Context(), "SELECT "+imageColumns+" FROM images WHERE id=$1 AND deleted_at IS NULL", id))
+	if err != nil {
+		http.Error(w, "未找到该图片", http.StatusNotFound)
+		return
+	}
+
+	details := ImageDetails{Image: img}
+	if strings.HasPrefix(img.URL, "/local/") {
+		details.Local = true
+		// 路径不在素材库之内时只返回数据库中的信息，不读取任何文件
+		if filePath, err := safeLocalPath(strings.TrimPrefix(img.URL, "/local/")); err != nil {
+			details.Reachability.Error = "本地路径无效"
+		} else {
+			if info, err := os.Stat(filePath); err == nil {
+				details.FileSize = info.Size()
+				details.Reachability.OK = true
+			} else {
+				details.Reachability.Error = "本地文件不存在"
+			}
+			if f, err := os.Open(filePath); err == nil {
+				head := make([]byte, 512)
+				n, _ := io.ReadFull(f, head)
+				f.Close()
+				details.MimeType = http.DetectContentType(head[:n])
+			}
+		}
+	} else {
+		details.Reachability = remoteReachability(r.Context(), img.URL)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(details)
+}
+
+// adminPlaceholdersHandler 管理"无匹配图片"时使用的占位图，占位图取自本地素材库
+func adminPlaceholdersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		tag := normalizeTag(r.FormValue("tag"))
+		key := placeholderSettingKey(tag)
+		var err error
+		if r.FormValue("action") == "delete" {
+			err = deleteSetting(r.Context(), key)
+		} else {
+			fileName := r.FormValue("file_name")
+			filePath, pathErr := safeLocalPath(fileName)
+			if pathErr != nil {
+				http.Error(w, pathErr.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, statErr := os.Stat(filePath); statErr != nil {
+				http.Error(w, "本地素材库中不存在该文件", http.StatusBadRequest)
+				return
+			}
+			err = setSetting(r.Context(), key, fileName)
+		}
+		if err != nil {
+			http.Error(w, "保存占位图设置失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/placeholders", http.StatusFound)
+		return
+	}
+
+	data := PlaceholdersPageData{CSRFToken: csrfToken(r)}
+	rows, err := dbpool.Query(r.Context(), "SELECT key, value FROM settings WHERE key = $1 OR key LIKE $2 ORDER BY key",
+		placeholderSettingKey(""), placeholderSettingKey("")+":%")
+	if err != nil {
+		http.Error(w, "无法读取占位图设置", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			continue
+		}
+		data.Placeholders = append(data.Placeholders, PlaceholderSetting{
+			Tag:      strings.TrimPrefix(strings.TrimPrefix(key, placeholderSettingKey("")), ":"),
+			FileName: value,
+		})
+	}
+	if files, err := listLocalFiles(); err == nil {
+		for _, f := range files {
+			data.LocalFiles = append(data.LocalFiles, f.Name)
+		}
+	}
+	render(w, "placeholders.html", data)
+}
+
+// statsTopN 是统计页面列出的最常返回图片数量
+const statsTopN = 20
+
+// adminStatsHandler 列出被返回次数最多的图片
+func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := dbpool.Query(r.Context(), "SELECT "+imageColumns+" FROM images WHERE views > 0 AND deleted_at IS NULL ORDER BY views DESC, id LIMIT $1", statsTopN)
+	if err != nil {
+		http.Error(w, "无法获取统计数据", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
 	var images []Image
 	for rows.Next() {
-		var img Image
-		if err := rows.Scan(&img.ID, &img.URL, &img.Tags); err != nil {
-			log.Printf("扫描图片数据失败: %v", err)
+		img, err := scanImage(rows)
+		if err != nil {
+			requestLogger(r).Error("扫描图片数据失败", "err", err)
 			continue
 		}
 		images = append(images, img)
 	}
-	templates.ExecuteTemplate(w, "dashboard.html", images)
+	render(w, "stats.html", images)
+}
+
+// --- 后台本地素材库操作 ---
+
+// localFileHandler 处理 /local/<路径>，按 safeLocalPath 的规则校验后返回本地素材库中的文件，
+// 不列出目录，也不提供 .thumbs 等内部目录中的文件
+func localFileHandler(w http.ResponseWriter, r *http.Request) {
+	filePath, err := safeLocalPath(strings.TrimPrefix(r.URL.Path, "/local/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	serveLocalFile(w, r, filePath)
 }
 
-func adminAddImageHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		r.ParseForm()
-		imgURL := r.FormValue("url")
-		imageType := r.FormValue("image_type")
-		otherTagsStr := r.FormValue("other_tags")
-
-		var finalTags []string
-		if imageType != "" {
-			finalTags = append(finalTags, imageType)
+// listLocalFiles 递归列出本地素材库中的文件，Name 为以 / 分隔的相对路径。
+// 以点开头的文件和目录（.thumbs 缓存、写入中的临时文件）会被跳过
+func listLocalFiles() ([]LocalFile, error) {
+	var files []LocalFile
+	err := filepath.WalkDir(localImagesPath, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
-		for _, t := range strings.Split(otherTagsStr, ",") {
-			if trimmed := strings.TrimSpace(t); trimmed != "" {
-				finalTags = append(finalTags, trimmed)
+		if p == localImagesPath {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
-
-		_, err := dbpool.Exec(context.Background(), "INSERT INTO images (url, tags) VALUES ($1, $2)", imgURL, finalTags)
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
 		if err != nil {
-			http.Error(w, "添加图片失败: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil
 		}
-		http.Redirect(w, r, "/admin", http.StatusFound)
+		rel, err := filepath.Rel(localImagesPath, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, LocalFile{Name: filepath.ToSlash(rel), ModTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+func adminLocalFilesHandler(w http.ResponseWriter, r *http.Request) {
+	files, err := listLocalFiles()
+	if err != nil {
+		http.Error(w, "无法读取本地图片目录", http.StatusInternalServerError)
 		return
 	}
 
-	// 预填充来自本地素材库的文件
-	localFile := r.URL.Query().Get("local_file")
-	img := Image{URL: "/local/" + localFile}
+	data := LocalFilesPageData{Files: files, Flash: popFlash(w, r), CSRFToken: csrfToken(r)}
+	render(w, "local_files.html", data)
+}
 
-	templates.ExecuteTemplate(w, "edit.html", EditPageData{Image: img})
+// localSubdir 校验上传和下载时指定的子目录并在需要时创建，返回规范化的相对路径，空字符串表示根目录
+func localSubdir(dir string) (string, error) {
+	dir = strings.Trim(strings.TrimSpace(dir), "/")
+	if dir == "" {
+		return "", nil
+	}
+	dirPath, err := safeLocalPath(dir)
+	if err != nil {
+		return "", fmt.Errorf("无效的子目录: %q", dir)
+	}
+	// safeLocalPath 只拒绝 thumb/ 下的文件，目录本身也不能是 thumb，否则其中的文件都无法访问
+	if first, _, _ := strings.Cut(dir, "/"); first == "thumb" {
+		return "", fmt.Errorf("thumb 目录名为缩略图保留: %q", dir)
+	}
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return "", err
+	}
+	return dir, nil
 }
 
-func adminEditImageHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if r.Method == http.MethodPost {
-		r.ParseForm()
-		imgURL := r.FormValue("url")
-		imageType := r.FormValue("image_type")
-		otherTagsStr := r.FormValue("other_tags")
+// adminUploadHandler 接收 multipart 上传的一个或多个图片文件并保存到本地素材库。
+// 任一文件不是图片时整个请求返回 400 且不保存任何文件；超过大小限制的文件会被跳过。
+func adminUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "无效请求", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "解析上传内容失败: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	headers := r.MultipartForm.File["files"]
+	if len(headers) == 0 {
+		http.Error(w, "请选择要上传的文件", http.StatusBadRequest)
+		return
+	}
+	dir, err := localSubdir(r.FormValue("dir"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		var finalTags []string
-		if imageType != "" {
-			finalTags = append(finalTags, imageType)
+	var accepted []*multipart.FileHeader
+	var skipped []string
+	for _, fh := range headers {
+		if fh.Size > maxUploadBytes {
+			skipped = append(skipped, fh.Filename)
+			continue
 		}
-		for _, t := range strings.Split(otherTagsStr, ",") {
-			if trimmed := strings.TrimSpace(t); trimmed != "" {
-				finalTags = append(finalTags, trimmed)
-			}
+		contentType, err := sniffUpload(fh)
+		if err != nil {
+			http.Error(w, "读取上传文件失败: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(contentType, "image/") {
+			http.Error(w, fmt.Sprintf("文件 %s 不是图片 (%s)", fh.Filename, contentType), http.StatusBadRequest)
+			return
 		}
+		accepted = append(accepted, fh)
+	}
 
-		_, err := dbpool.Exec(context.Background(), "UPDATE images SET url=$1, tags=$2 WHERE id=$3", imgURL, finalTags, id)
+	for _, fh := range accepted {
+		name, err := saveUpload(fh, dir)
 		if err != nil {
-			http.Error(w, "更新图片失败: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "保存文件失败: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		http.Redirect(w, r, "/admin", http.StatusFound)
-		return
+		warmupThumbnails(name)
 	}
 
-	var img Image
-	err := dbpool.QueryRow(context.Background(), "SELECT id, url, tags FROM images WHERE id=$1", id).Scan(&img.ID, &img.URL, &img.Tags)
-	if err != nil {
-		http.Error(w, "未找到该图片", http.StatusNotFound)
-		return
+	msg := fmt.Sprintf("已上传 %d 个文件", len(accepted))
+	if len(skipped) > 0 {
+		msg += fmt.Sprintf("，%d 个文件超过 %d MB 已跳过: %s", len(skipped), maxUploadBytes>>20, strings.Join(skipped, ", "))
 	}
+	setFlash(w, msg)
+	http.Redirect(w, r, "/admin/local_files", http.StatusFound)
+}
 
-	data := EditPageData{Image: img}
-	var otherTags []string
-	for _, t := range img.Tags {
-		if t == "desktop" {
-			data.IsDesktop = true
-		} else if t == "mobile" {
-			data.IsMobile = true
-		} else {
-			otherTags = append(otherTags, t)
-		}
+// sniffUpload 读取上传文件的前 512 字节判断真实类型，不信任客户端声明的 Content-Type
+func sniffUpload(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
 	}
-	data.OtherTags = strings.Join(otherTags, ", ")
-
-	templates.ExecuteTemplate(w, "edit.html", data)
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
 }
 
-func adminDeleteImageHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "无效的请求方法", http.StatusMethodNotAllowed)
-		return
+// saveUpload 以清理后的文件名把上传文件保存到子目录 dir，重名时追加随机后缀，返回以 / 分隔的相对路径
+func saveUpload(fh *multipart.FileHeader, dir string) (string, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return "", err
 	}
-	r.ParseForm()
-	id := r.FormValue("id")
-	_, err := dbpool.Exec(context.Background(), "DELETE FROM images WHERE id=$1", id)
+	defer src.Close()
+
+	dst, name, err := createLocalFile(path.Join(dir, sanitizeFileName(fh.Filename)))
 	if err != nil {
-		http.Error(w, "删除图片失败: "+err.Error(), http.StatusInternalServerError)
-		return
+		return "", err
 	}
-	http.Redirect(w, r, "/admin", http.StatusFound)
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+	return name, dst.Close()
 }
 
-// --- 后台本地素材库操作 ---
-
-func adminLocalFilesHandler(w http.ResponseWriter, r *http.Request) {
-	files, err := os.ReadDir(localImagesPath)
+// createLocalFile 在素材库中新建文件 name（以 / 分隔的相对路径），不会覆盖已有文件：
+// 同名文件已存在时在扩展名前追加随机后缀再创建。返回打开的文件和实际使用的相对路径
+func createLocalFile(name string) (*os.File, string, error) {
+	p, err := safeLocalPath(name)
 	if err != nil {
-		http.Error(w, "无法读取本地图片目录", http.StatusInternalServerError)
-		return
+		return nil, "", err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if !errors.Is(err, fs.ErrExist) {
+		return f, name, err
+	}
+	ext := path.Ext(name)
+	name = strings.TrimSuffix(name, ext) + "-" + uuid.NewString()[:8] + ext
+	if p, err = safeLocalPath(name); err != nil {
+		return nil, "", err
 	}
+	f, err = os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	return f, name, err
+}
 
-	var localFiles []LocalFile
-	for _, file := range files {
-		info, err := file.Info()
-		if err == nil && !info.IsDir() {
-			localFiles = append(localFiles, LocalFile{Name: file.Name(), ModTime: info.ModTime()})
+// sanitizeFileName 只保留文件名部分，并把字母、数字、点、横线、下划线以外的字符替换为下划线
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' {
+			return r
 		}
+		return '_'
+	}, name)
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = uuid.NewString()
 	}
-
-	templates.ExecuteTemplate(w, "local_files.html", localFiles)
+	return name
 }
 
 func adminDownloadURLHandler(w http.ResponseWriter, r *http.Request) {
@@ -477,46 +2399,56 @@ func adminDownloadURLHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "URL 不能为空", http.StatusBadRequest)
 		return
 	}
-
-	resp, err := httpClient.Get(fileURL)
+	parsedURL, err := validateDownloadURL(r.Context(), fileURL)
 	if err != nil {
-		http.Error(w, "下载失败: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, fmt.Sprintf("下载失败，源站返回状态码: %d", resp.StatusCode), http.StatusInternalServerError)
+		http.Error(w, "不允许下载该地址: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// 从 URL 解析文件名，如果无法解析则用 UUID
-	parsedURL, err := url.Parse(fileURL)
-	var fileName string
-	if err == nil && filepath.Base(parsedURL.Path) != "." && filepath.Base(parsedURL.Path) != "/" {
-		fileName = filepath.Base(parsedURL.Path)
-	} else {
-		fileName = uuid.NewString() + ".jpg" // 默认后缀
-	}
-
-	localPath := filepath.Join(localImagesPath, fileName)
-
-	outFile, err := os.Create(localPath)
+	dir, err := localSubdir(r.FormValue("dir"))
 	if err != nil {
-		http.Error(w, "无法在本地创建文件: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer outFile.Close()
 
-	_, err = io.Copy(outFile, resp.Body)
-	if err != nil {
-		http.Error(w, "保存文件失败: "+err.Error(), http.StatusInternalServerError)
+	if _, err := downloadToLocal(r.Context(), parsedURL, dir); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errDownloadTooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), code)
 		return
 	}
 
 	http.Redirect(w, r, "/admin/local_files", http.StatusFound)
 }
 
+// safeLocalPath 校验本地素材库中以 / 分隔的相对路径（如 wallpapers/a.jpg）并返回其完整路径。
+// 路径不能为空、不能以 / 开头、不能包含反斜杠或多余的分隔符，每一级都不能是 ".." 或以点开头
+// （点开头的是 .thumbs 等内部目录）；顶层的 thumb 目录留给缩略图路由。解析后的路径必须仍在 localImagesPath 之下
+func safeLocalPath(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("文件名不能为空")
+	}
+	if strings.Contains(name, `\`) || path.Clean("/"+name) != "/"+name {
+		return "", fmt.Errorf("无效的文件名: %q", name)
+	}
+	segments := strings.Split(name, "/")
+	for _, seg := range segments {
+		if strings.HasPrefix(seg, ".") {
+			return "", fmt.Errorf("无效的文件名: %q", name)
+		}
+	}
+	if len(segments) > 1 && segments[0] == "thumb" {
+		return "", fmt.Errorf("thumb 目录名为缩略图保留: %q", name)
+	}
+	p := filepath.Join(localImagesPath, filepath.FromSlash(name))
+	rel, err := filepath.Rel(localImagesPath, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("无效的文件名: %q", name)
+	}
+	return p, nil
+}
+
 func adminRenameFileHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "无效请求", http.StatusMethodNotAllowed)
@@ -526,13 +2458,16 @@ func adminRenameFileHandler(w http.ResponseWriter, r *http.Request) {
 	oldName := r.FormValue("old_name")
 	newName := r.FormValue("new_name")
 
-	if oldName == "" || newName == "" || strings.Contains(newName, "/") {
-		http.Error(w, "文件名不能为空且不能包含斜杠", http.StatusBadRequest)
+	oldPath, err := safeLocalPath(oldName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	newPath, err := safeLocalPath(newName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	oldPath := filepath.Join(localImagesPath, oldName)
-	newPath := filepath.Join(localImagesPath, newName)
 
 	if err := os.Rename(oldPath, newPath); err != nil {
 		http.Error(w, "重命名失败: "+err.Error(), http.StatusInternalServerError)
@@ -542,19 +2477,84 @@ func adminRenameFileHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin/local_files", http.StatusFound)
 }
 
+// adminMoveFileHandler 把本地文件移动到子目录 dir（为空时移到根目录），目录不存在时自动创建。
+// 引用该文件的图片 URL 和占位图设置在同一事务中改为新路径，文件移动失败时回滚，已发布的图片不会失效
+func adminMoveFileHandler(w http.ResponseWriter, r *http.Request) {
+	oldName := r.FormValue("file_name")
+	oldPath, err := safeLocalPath(oldName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dir, err := localSubdir(r.FormValue("dir"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	newName := path.Join(dir, path.Base(oldName))
+	if newName == oldName {
+		http.Redirect(w, r, "/admin/local_files", http.StatusFound)
+		return
+	}
+	newPath, err := safeLocalPath(newName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		http.Error(w, fmt.Sprintf("目标位置已存在文件 %s", newName), http.StatusConflict)
+		return
+	}
+
+	tx, err := dbpool.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "无法开始事务", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+	tag, err := tx.Exec(r.Context(), "UPDATE images SET url = $2 WHERE url = $1", "/local/"+oldName, "/local/"+newName)
+	if isUniqueViolation(err) {
+		http.Error(w, duplicateURLMessage(r.Context(), "/local/"+newName), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "更新图片 URL 失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, err = tx.Exec(r.Context(), "UPDATE settings SET value = $2 WHERE (key = $3 OR key LIKE $4) AND value = $1",
+		oldName, newName, placeholderSettingKey(""), placeholderSettingKey("")+":%")
+	if err != nil {
+		http.Error(w, "更新占位图设置失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		http.Error(w, "移动文件失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		// 数据库没有更新，把文件移回原处，保持与图片 URL 一致
+		if rerr := os.Rename(newPath, oldPath); rerr != nil {
+			requestLogger(r).Error("移回文件失败", "from", newName, "to", oldName, "err", rerr)
+		}
+		http.Error(w, "更新图片 URL 失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("已移动本地文件", "from", oldName, "to", newName, "images", tag.RowsAffected())
+	setFlash(w, fmt.Sprintf("已将 %s 移动到 %s，更新了 %d 张图片的 URL", oldName, newName, tag.RowsAffected()))
+	http.Redirect(w, r, "/admin/local_files", http.StatusFound)
+}
+
 func adminDeleteFileHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "无效请求", http.StatusMethodNotAllowed)
 		return
 	}
 	r.ParseForm()
-	fileName := r.FormValue("file_name")
-	if fileName == "" {
-		http.Error(w, "文件名不能为空", http.StatusBadRequest)
+	filePath, err := safeLocalPath(r.FormValue("file_name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	filePath := filepath.Join(localImagesPath, fileName)
 	if err := os.Remove(filePath); err != nil {
 		http.Error(w, "删除文件失败: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -565,14 +2565,65 @@ func adminDeleteFileHandler(w http.ResponseWriter, r *http.Request) {
 
 // --- HTML 模板 ---
 
-func parseTemplates() {
-	templates = template.New("").Funcs(template.FuncMap{
-		"join": strings.Join,
-	})
-	template.Must(templates.Parse(loginTemplate))
-	template.Must(templates.Parse(dashboardTemplate))
-	template.Must(templates.Parse(editTemplate))
-	template.Must(templates.Parse(localFilesTemplate))
+// timeAgo 把时间格式化为"3 天前"这样的相对时间，超过 30 天时显示日期
+func timeAgo(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "刚刚"
+	case d < time.Hour:
+		return fmt.Sprintf("%d 分钟前", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%d 小时前", int(d/time.Hour))
+	case d < 30*24*time.Hour:
+		return fmt.Sprintf("%d 天前", int(d/(24*time.Hour)))
+	default:
+		return t.Format("2006-01-02")
+	}
+}
+
+// render 渲染整页模板。页面先完整渲染到内存再写出，避免模板出错时返回半截页面；
+// 同时用 renderSlots 限制并发渲染数，繁忙时短暂等待后返回 503，而不是让内存无限增长。
+func render(w http.ResponseWriter, name string, data interface{}) {
+	select {
+	case renderSlots <- struct{}{}:
+		defer func() { <-renderSlots }()
+	case <-time.After(2 * time.Second):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "服务器繁忙，请稍后重试", http.StatusServiceUnavailable)
+		return
+	}
+
+	t, err := templateProvider.Templates()
+	if err != nil {
+		slog.Error("加载模板失败", "err", err)
+		http.Error(w, "页面渲染失败", http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		slog.Error("渲染模板失败", "template", name, "err", err)
+		http.Error(w, "页面渲染失败", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// setFlash 设置一条在下次页面渲染时显示一次的提示消息
+func setFlash(w http.ResponseWriter, msg string) {
+	http.SetCookie(w, &http.Cookie{Name: "flash", Value: url.QueryEscape(msg), Path: "/admin", MaxAge: 60})
+}
+
+// popFlash 读取并清除提示消息
+func popFlash(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie("flash")
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{Name: "flash", Value: "", Path: "/admin", MaxAge: -1})
+	msg, _ := url.QueryUnescape(cookie.Value)
+	return msg
 }
 
 const loginTemplate = `{{define "login.html"}}<!DOCTYPE html><html><head><title>登录</title><style>body{font-family: sans-serif;}</style></head><body>
@@ -583,59 +2634,192 @@ const loginTemplate = `{{define "login.html"}}<!DOCTYPE html><html><head><title>
 </form></body></html>{{end}}`
 
 const dashboardTemplate = `{{define "dashboard.html"}}<!DOCTYPE html><html><head><title>管理后台</title><style>body{font-family: sans-serif;} table,th,td{border: 1px solid black; border-collapse: collapse; padding: 5px;} a,button{margin-right: 10px;}</style></head><body>
-<h1>图片列表</h1>
-<p><a href="/admin/add">添加新图片</a> | <a href="/admin/local_files">本地素材库</a> | <a href="/admin/logout">登出</a></p>
+<h1>图片列表 ({{.Total}})</h1>
+<p><a href="/admin/add">添加新图片</a> | <a href="/admin/local_files">本地素材库</a> | <a href="/admin/placeholders">占位图设置</a> | <a href="/admin/stats">访问统计</a> | <a href="/admin/tags">标签管理</a> | <a href="/admin/duplicates">相似图片</a> | <a href="/admin/trash">回收站</a> | <a href="/admin/audit">审计日志</a> | <a href="/admin/export.csv">导出 CSV</a> | <a href="/admin/export.json">导出 JSON</a> | <a href="/admin/logout">登出</a></p>
+<form method="post" action="/admin/backfill" target="_blank">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+  <button type="submit">补算缺少的元数据</button>
+</form>
+<form method="post" action="/admin/maintenance">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+  {{if .Maintenance}}<strong>维护模式已开启，公开页面和接口返回 503。</strong>
+  <input type="hidden" name="enabled" value="0">
+  <button type="submit">关闭维护模式</button>
+  {{else}}<input type="hidden" name="enabled" value="1">
+  <button type="submit" onclick="return confirm('开启后公开页面和接口将返回 503，确定吗？');">开启维护模式</button>
+  {{end}}
+</form>
+<form method="get" action="/admin">
+  <input type="text" name="q" value="{{.Query}}" placeholder="搜索 URL 或标签">
+  <select name="sort">
+    <option value="id"{{if eq .Sort "id"}} selected{{end}}>按 ID</option>
+    <option value="newest"{{if eq .Sort "newest"}} selected{{end}}>最新添加</option>
+    <option value="oldest"{{if eq .Sort "oldest"}} selected{{end}}>最早添加</option>
+  </select>
+  <button type="submit">搜索</button>
+  {{if .Query}}<a href="/admin">清除</a>{{end}}
+</form>
+{{if .Flash}}<p><strong>{{.Flash}}</strong></p>{{end}}
+<form id="bulk" method="post" action="/admin/delete">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+  <button type="submit" onclick="return confirm('确定将选中的图片移入回收站吗？');">删除选中</button>
+  <input type="text" name="tag" placeholder="标签">
+  <button type="submit" formaction="/admin/tags/bulk" name="op" value="add">为选中添加标签</button>
+  <button type="submit" formaction="/admin/tags/bulk" name="op" value="remove">从选中移除标签</button>
+</form>
 <table>
-  <tr><th>ID</th><th>URL</th><th>Tags</th><th>操作</th></tr>
-  {{range .}}
+  <tr><th></th><th>收藏</th><th>ID</th><th>URL</th><th>Tags</th><th>作者</th><th>浏览量</th><th>添加时间</th><th>操作</th></tr>
+  {{range .Images}}
   <tr>
+    <td><input type="checkbox" name="id" value="{{.ID}}" form="bulk"></td>
+    <td>
+      <form method="post" action="/admin/star" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+        <input type="hidden" name="id" value="{{.ID}}">
+        <button type="submit" title="{{if .Starred}}取消收藏{{else}}收藏{{end}}">{{if .Starred}}★{{else}}☆{{end}}</button>
+      </form>
+    </td>
     <td>{{.ID}}</td>
     <td><a href="{{.URL}}" target="_blank">{{.URL}}</a></td>
     <td>{{join .Tags ", "}}</td>
+    <td>{{if .Source}}<a href="{{.Source}}" target="_blank">{{or .Author "来源"}}</a>{{else}}{{.Author}}{{end}}</td>
+    <td>{{.Views}}</td>
+    <td title="{{.CreatedAt.Format "2006-01-02 15:04:05"}}">{{timeAgo .CreatedAt}}</td>
     <td>
       <a href="/admin/edit?id={{.ID}}">编辑</a>
       <form method="post" action="/admin/delete" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
         <input type="hidden" name="id" value="{{.ID}}">
-        <button type="submit" onclick="return confirm('确定删除吗？');">删除</button>
+        <button type="submit" onclick="return confirm('确定将这张图片移入回收站吗？');">删除</button>
       </form>
     </td>
   </tr>
   {{end}}
-</table></body></html>{{end}}`
+</table>
+<p>
+  {{if gt .Page 1}}<a href="/admin?page={{sub .Page 1}}&q={{.Query}}&sort={{.Sort}}">上一页</a>{{end}}
+  第 {{.Page}} / {{.TotalPages}} 页
+  {{if lt .Page .TotalPages}}<a href="/admin?page={{add .Page 1}}&q={{.Query}}&sort={{.Sort}}">下一页</a>{{end}}
+</p>
+<h2>导入</h2>
+<form method="post" action="/admin/import.csv" enctype="multipart/form-data">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+  CSV 文件 (id,url,tags，标签以 | 分隔): <input type="file" name="file" accept=".csv,text/csv">
+  <button type="submit">导入</button>
+</form>
+<form method="post" action="/admin/import.json" enctype="multipart/form-data">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+  JSON 文件 (导出 JSON 得到的图片数组): <input type="file" name="file" accept=".json,application/json">
+  <button type="submit">导入</button>
+</form></body></html>{{end}}`
 
 const editTemplate = `{{define "edit.html"}}<!DOCTYPE html><html><head><title>{{if .Image.ID}}编辑{{else}}添加{{end}}图片</title><style>body{font-family: sans-serif;} input{width: 500px; margin-bottom: 10px;}</style></head><body>
 <h1>{{if .Image.ID}}编辑图片 ID: {{.Image.ID}}{{else}}添加新图片{{end}}</h1>
 <form method="post">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
   <p><strong>URL:</strong><br>
     <input type="text" name="url" value="{{.Image.URL}}">
+    {{if not .Image.ID}}<br><label><input type="checkbox" name="validate" value="1" checked style="width:auto;"> 检查 URL（保存前确认链接可访问且是图片）</label>{{end}}
+    <br><img id="url-preview" alt="预览" style="max-width: 300px; max-height: 200px; display: none;">
   </p>
   <p><strong>类型:</strong><br>
     <label><input type="radio" name="image_type" value="desktop" {{if .IsDesktop}}checked{{end}}> 电脑端</label>
     <label><input type="radio" name="image_type" value="mobile" {{if .IsMobile}}checked{{end}}> 手机端</label>
   </p>
   <p><strong>其他标签 (逗号分隔):</strong><br>
-    <input type="text" name="other_tags" value="{{.OtherTags}}">
+    <input type="text" name="other_tags" value="{{.OtherTags}}" list="tag-suggestions" autocomplete="off">
+    <datalist id="tag-suggestions"></datalist>
+  </p>
+  <p><strong>权重 (越大越容易被选中，0 为不参与随机):</strong><br>
+    <input type="number" name="weight" min="0" max="1000" value="{{.Image.Weight}}">
+  </p>
+  <p><strong>作者 (可选):</strong><br>
+    <input type="text" name="author" value="{{.Image.Author}}">
+  </p>
+  <p><strong>来源链接 (可选):</strong><br>
+    <input type="text" name="source" value="{{.Image.Source}}">
   </p>
   <button type="submit">保存</button>
 </form>
-<p><a href="/admin">返回列表</a></p></body></html>{{end}}`
+<p><a href="/admin">返回列表</a></p>
+<script>
+// 输入 URL 后通过 /admin/preview 加载预览，本地图片直接显示；无法加载时隐藏预览
+(function() {
+  var input = document.querySelector('input[name="url"]');
+  var img = document.getElementById('url-preview');
+  var timer;
+  img.addEventListener('load', function() { img.style.display = ''; });
+  img.addEventListener('error', function() { img.style.display = 'none'; });
+  function update() {
+    var value = input.value.trim();
+    if (!value) { img.removeAttribute('src'); img.style.display = 'none'; return; }
+    img.src = value.indexOf('/local/') === 0 ? value : '/admin/preview?url=' + encodeURIComponent(value);
+  }
+  input.addEventListener('input', function() {
+    clearTimeout(timer);
+    timer = setTimeout(update, 400);
+  });
+  update();
+})();
+// 按正在输入的最后一个标签查询已有标签，补全时保留前面已填写的标签
+(function() {
+  var input = document.querySelector('input[name="other_tags"]');
+  var list = document.getElementById('tag-suggestions');
+  var timer;
+  input.addEventListener('input', function() {
+    clearTimeout(timer);
+    timer = setTimeout(function() {
+      var value = input.value;
+      var cut = value.lastIndexOf(',') + 1;
+      var prefix = value.slice(0, cut);
+      var q = value.slice(cut).trim();
+      if (!q) { list.innerHTML = ''; return; }
+      fetch('/api/tags/suggest?q=' + encodeURIComponent(q)).then(function(resp) { return resp.json(); }).then(function(tags) {
+        list.innerHTML = '';
+        tags.forEach(function(tag) {
+          var option = document.createElement('option');
+          option.value = prefix + (prefix ? ' ' : '') + tag;
+          list.appendChild(option);
+        });
+      }).catch(function() {});
+    }, 200);
+  });
+})();
+</script></body></html>{{end}}`
 
 const localFilesTemplate = `{{define "local_files.html"}}<!DOCTYPE html><html><head><title>本地素材库</title><style>body{font-family: sans-serif;} table,th,td{border: 1px solid black; border-collapse: collapse; padding: 5px;} a,button{margin-right: 10px;}</style></head><body>
 <h1>本地素材库</h1>
 <p><a href="/admin">返回图片列表</a></p>
+{{if .Flash}}<p><strong>{{.Flash}}</strong></p>{{end}}
 <h2>从 URL 下载新素材</h2>
 <form method="post" action="/admin/download">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
   <input type="text" name="url" size="100" placeholder="输入图片 URL">
+  <input type="text" name="dir" placeholder="子目录（可选，如 wallpapers）">
   <button type="submit">下载</button>
 </form>
-<h2>已下载素材 ({{len .}})</h2>
+<form method="post" action="/admin/download/bulk">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+  <p><textarea name="urls" rows="6" cols="100" placeholder="批量下载：每行一个图片 URL，最多 100 个"></textarea></p>
+  <input type="text" name="dir" placeholder="子目录（可选，如 wallpapers）">
+  <button type="submit">批量下载</button>
+</form>
+<h2>上传本地图片</h2>
+<form method="post" action="/admin/upload" enctype="multipart/form-data">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+  <input type="file" name="files" accept="image/*" multiple>
+  <input type="text" name="dir" placeholder="子目录（可选，如 wallpapers）">
+  <button type="submit">上传</button>
+</form>
+<h2>已下载素材 ({{len .Files}})</h2>
 <table>
   <tr><th>预览</th><th>文件名</th><th>修改时间</th><th>操作</th></tr>
-  {{range .}}
+  {{range .Files}}
   <tr>
-    <td><a href="/local/{{.Name}}" target="_blank"><img src="/local/{{.Name}}" alt="{{.Name}}" height="50"></a></td>
+    <td><a href="/local/{{.Name}}" target="_blank"><img src="/local/thumb/{{.Name}}" alt="{{.Name}}" height="50" loading="lazy"></a></td>
     <td>
       <form method="post" action="/admin/rename_file" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
         <input type="hidden" name="old_name" value="{{.Name}}">
         <input type="text" name="new_name" value="{{.Name}}">
         <button type="submit">重命名</button>
@@ -644,7 +2828,14 @@ const localFilesTemplate = `{{define "local_files.html"}}<!DOCTYPE html><html><h
     <td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td>
     <td>
       <a href="/admin/add?local_file={{.Name}}">发布到图库</a>
+      <form method="post" action="/admin/move_file" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+        <input type="hidden" name="file_name" value="{{.Name}}">
+        <input type="text" name="dir" placeholder="目标子目录" size="12">
+        <button type="submit">移动</button>
+      </form>
       <form method="post" action="/admin/delete_file" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
         <input type="hidden" name="file_name" value="{{.Name}}">
         <button type="submit" onclick="return confirm('确定删除这个本地文件吗？');">删除</button>
       </form>
@@ -652,3 +2843,187 @@ const localFilesTemplate = `{{define "local_files.html"}}<!DOCTYPE html><html><h
   </tr>
   {{end}}
 </table></body></html>{{end}}`
+
+const bulkDownloadTemplate = `{{define "bulk_download.html"}}<!DOCTYPE html><html><head><title>批量下载结果</title><style>body{font-family: sans-serif;} table,th,td{border: 1px solid black; border-collapse: collapse; padding: 5px;}</style></head><body>
+<h1>批量下载结果</h1>
+<p><a href="/admin/local_files">返回本地素材库</a></p>
+<p>成功 {{.Succeeded}} 个，失败 {{.Failed}} 个。</p>
+<table>
+  <tr><th>URL</th><th>结果</th></tr>
+  {{range .Results}}
+  <tr>
+    <td>{{.URL}}</td>
+    <td>{{if .Err}}失败：{{.Err}}{{else}}已保存为 <a href="/local/{{.FileName}}" target="_blank">{{.FileName}}</a>{{end}}</td>
+  </tr>
+  {{end}}
+</table></body></html>{{end}}`
+
+const placeholdersTemplate = `{{define "placeholders.html"}}<!DOCTYPE html><html><head><title>占位图设置</title><style>body{font-family: sans-serif;} table,th,td{border: 1px solid black; border-collapse: collapse; padding: 5px;} a,button{margin-right: 10px;}</style></head><body>
+<h1>占位图设置</h1>
+<p><a href="/admin">返回图片列表</a></p>
+<p>当 <code>/random-image</code> 按标签找不到图片时，优先返回该标签的占位图，其次返回全局占位图，都未设置时返回 <code>FALLBACK_IMAGE_PATH</code> 指定的兜底图片（状态码 404），也没有兜底图片时才返回文本 404。JSON 接口不受影响。</p>
+<table>
+  <tr><th>标签</th><th>占位图</th><th>操作</th></tr>
+  {{range .Placeholders}}
+  <tr>
+    <td>{{if .Tag}}{{.Tag}}{{else}}(全局){{end}}</td>
+    <td><a href="/local/{{.FileName}}" target="_blank"><img src="/local/{{.FileName}}" alt="{{.FileName}}" height="50"></a> {{.FileName}}</td>
+    <td>
+      <form method="post" action="/admin/placeholders" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+        <input type="hidden" name="action" value="delete">
+        <input type="hidden" name="tag" value="{{.Tag}}">
+        <button type="submit" onclick="return confirm('确定删除这个占位图设置吗？');">删除</button>
+      </form>
+    </td>
+  </tr>
+  {{end}}
+</table>
+<h2>添加或修改占位图</h2>
+<form method="post" action="/admin/placeholders">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+  标签 (留空为全局): <input type="text" name="tag">
+  本地文件: <input type="text" name="file_name" list="local-files">
+  <datalist id="local-files">{{range .LocalFiles}}<option value="{{.}}">{{end}}</datalist>
+  <button type="submit">保存</button>
+</form></body></html>{{end}}`
+
+const statsTemplate = `{{define "stats.html"}}<!DOCTYPE html><html><head><title>访问统计</title><style>body{font-family: sans-serif;} table,th,td{border: 1px solid black; border-collapse: collapse; padding: 5px;}</style></head><body>
+<h1>返回次数最多的图片</h1>
+<p><a href="/admin">返回图片列表</a></p>
+<table>
+  <tr><th>排名</th><th>ID</th><th>URL</th><th>Tags</th><th>浏览量</th></tr>
+  {{range $i, $img := .}}
+  <tr>
+    <td>{{add $i 1}}</td>
+    <td><a href="/admin/edit?id={{$img.ID}}">{{$img.ID}}</a></td>
+    <td><a href="{{$img.URL}}" target="_blank">{{$img.URL}}</a></td>
+    <td>{{join $img.Tags ", "}}</td>
+    <td>{{$img.Views}}</td>
+  </tr>
+  {{else}}
+  <tr><td colspan="5">暂无数据</td></tr>
+  {{end}}
+</table>
+</body></html>{{end}}`
+
+const tagsTemplate = `{{define "tags.html"}}<!DOCTYPE html><html><head><title>标签管理</title><style>body{font-family: sans-serif;} table,th,td{border: 1px solid black; border-collapse: collapse; padding: 5px;} a,button{margin-right: 10px;}</style></head><body>
+<h1>标签管理</h1>
+<p><a href="/admin">返回图片列表</a></p>
+{{if .Flash}}<p><strong>{{.Flash}}</strong></p>{{end}}
+<table>
+  <tr><th>标签</th><th>图片数</th><th>重命名 / 合并</th><th>删除</th></tr>
+  {{range .Tags}}
+  <tr>
+    <td><a href="/admin?q={{.Tag}}">{{.Tag}}</a></td>
+    <td>{{.Count}}</td>
+    <td>
+      <form method="post" action="/admin/tags/rename" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+        <input type="hidden" name="from" value="{{.Tag}}">
+        <input type="text" name="to" placeholder="新标签名" required>
+        <label><input type="checkbox" name="merge" value="1"> 合并到已有标签</label>
+        <button type="submit">保存</button>
+      </form>
+    </td>
+    <td>
+      <form method="post" action="/admin/tags/delete" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+        <input type="hidden" name="tag" value="{{.Tag}}">
+        <button type="submit" onclick="return confirm('确定从所有图片上删除这个标签吗？');">删除</button>
+      </form>
+    </td>
+  </tr>
+  {{else}}
+  <tr><td colspan="4">暂无标签</td></tr>
+  {{end}}
+</table>
+</body></html>{{end}}`
+
+const trashTemplate = `{{define "trash.html"}}<!DOCTYPE html><html><head><title>回收站</title><style>body{font-family: sans-serif;} table,th,td{border: 1px solid black; border-collapse: collapse; padding: 5px;} a,button{margin-right: 10px;}</style></head><body>
+<h1>回收站</h1>
+<p><a href="/admin">返回图片列表</a></p>
+<p>删除的图片会在回收站中保留 30 天，之后自动永久删除。</p>
+{{if .Flash}}<p><strong>{{.Flash}}</strong></p>{{end}}
+<table>
+  <tr><th>ID</th><th>URL</th><th>Tags</th><th>删除时间</th><th>操作</th></tr>
+  {{range .Images}}
+  <tr>
+    <td>{{.ID}}</td>
+    <td><a href="{{.URL}}" target="_blank">{{.URL}}</a></td>
+    <td>{{join .Tags ", "}}</td>
+    <td>{{.DeletedAt.Format "2006-01-02 15:04:05"}}</td>
+    <td>
+      <form method="post" action="/admin/trash/restore" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+        <input type="hidden" name="id" value="{{.ID}}">
+        <button type="submit">恢复</button>
+      </form>
+      <form method="post" action="/admin/trash/purge" style="display:inline;">
+        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+        <input type="hidden" name="id" value="{{.ID}}">
+        <button type="submit" onclick="return confirm('永久删除后无法恢复，确定吗？');">永久删除</button>
+      </form>
+    </td>
+  </tr>
+  {{else}}
+  <tr><td colspan="5">回收站是空的</td></tr>
+  {{end}}
+</table>
+</body></html>{{end}}`
+
+const auditTemplate = `{{define "audit.html"}}<!DOCTYPE html><html><head><title>审计日志</title><style>body{font-family: sans-serif;} table,th,td{border: 1px solid black; border-collapse: collapse; padding: 5px;} td.detail{max-width: 480px; word-break: break-all;}</style></head><body>
+<h1>审计日志</h1>
+<p><a href="/admin">返回图片列表</a></p>
+<table>
+  <tr><th>时间</th><th>操作者</th><th>IP</th><th>操作</th><th>对象</th><th>详情</th><th>状态码</th></tr>
+  {{range .Entries}}
+  <tr>
+    <td title="{{timeAgo .CreatedAt}}">{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+    <td>{{.Actor}}</td>
+    <td>{{.IP}}</td>
+    <td>{{.Action}}</td>
+    <td>{{.Target}}</td>
+    <td class="detail">{{.Detail}}</td>
+    <td>{{.Status}}</td>
+  </tr>
+  {{else}}
+  <tr><td colspan="7">还没有审计记录</td></tr>
+  {{end}}
+</table>
+<p>
+  {{if gt .Page 1}}<a href="/admin/audit?page={{sub .Page 1}}">上一页</a>{{end}}
+  第 {{.Page}} / {{.TotalPages}} 页
+  {{if lt .Page .TotalPages}}<a href="/admin/audit?page={{add .Page 1}}">下一页</a>{{end}}
+</p>
+</body></html>{{end}}`
+
+const duplicatesTemplate = `{{define "duplicates.html"}}<!DOCTYPE html><html><head><title>相似图片</title><style>body{font-family: sans-serif;} table,th,td{border: 1px solid black; border-collapse: collapse; padding: 5px;} a,button{margin-right: 10px;} img{max-width: 160px; max-height: 120px;}</style></head><body>
+<h1>相似图片</h1>
+<p><a href="/admin">返回图片列表</a></p>
+<form method="get" action="/admin/duplicates">
+  最大汉明距离 (0-16，越大越宽松): <input type="number" name="distance" min="0" max="16" value="{{.Distance}}">
+  <button type="submit">刷新</button>
+</form>
+{{range $i, $group := .Groups}}
+<h3>第 {{add $i 1}} 组 ({{len $group}} 张)</h3>
+<form method="post" action="/admin/delete">
+  <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+  <table>
+    <tr><th>删除</th><th>预览</th><th>ID</th><th>URL</th><th>尺寸</th></tr>
+    {{range $j, $img := $group}}
+    <tr>
+      <td><input type="checkbox" name="id" value="{{$img.ID}}"{{if $j}} checked{{end}}></td>
+      <td><img src="/image?id={{$img.ID}}&w=160" loading="lazy"></td>
+      <td><a href="/admin/edit?id={{$img.ID}}">{{$img.ID}}</a></td>
+      <td><a href="{{$img.URL}}" target="_blank">{{$img.URL}}</a></td>
+      <td>{{if $img.Width}}{{$img.Width}}x{{$img.Height}}{{end}}</td>
+    </tr>
+    {{end}}
+  </table>
+  <button type="submit" onclick="return confirm('确定将勾选的图片移入回收站吗？');">删除勾选（默认保留第一张）</button>
+</form>
+{{else}}
+<p>没有找到相似的图片。</p>
+{{end}}
+</body></html>{{end}}`
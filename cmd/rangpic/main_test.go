@@ -0,0 +1,1004 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// TestMain 设置 loadConfig 通常负责的默认配置，测试不读取环境变量
+func TestMain(m *testing.M) {
+	maxQueryTags = 20
+	renderSlots = make(chan struct{}, 8)
+	maxUploadBytes = 20 << 20
+	maxDownloadBytes = 50 << 20
+	os.Exit(m.Run())
+}
+
+// testDB 连接 TEST_DATABASE_URL 指向的测试数据库、建表并清空数据，未设置时跳过需要数据库的测试
+func testDB(t testing.TB) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("未设置 TEST_DATABASE_URL，跳过需要数据库的测试")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("连接测试数据库失败: %v", err)
+	}
+	dbpool = pool
+	seedDataPath = t.TempDir() + "/seed.txt"
+	t.Cleanup(func() {
+		pool.Close()
+		dbpool = nil
+	})
+	if err := initDB(ctx); err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "TRUNCATE images, settings, sessions, audit_log RESTART IDENTITY"); err != nil {
+		t.Fatalf("清空测试表失败: %v", err)
+	}
+}
+
+// insertTestImage 向测试数据库插入一张图片并返回其 id
+func insertTestImage(t testing.TB, url string, tags ...string) int {
+	t.Helper()
+	if tags == nil {
+		tags = []string{}
+	}
+	var id int
+	if err := dbpool.QueryRow(context.Background(), "INSERT INTO images (url, tags) VALUES ($1, $2) RETURNING id", url, tags).Scan(&id); err != nil {
+		t.Fatalf("插入测试图片失败: %v", err)
+	}
+	return id
+}
+
+func TestChooseRandomImageTags(t *testing.T) {
+	testDB(t)
+	insertTestImage(t, "https://example.com/desktop.jpg", "desktop")
+	both := insertTestImage(t, "https://example.com/both.jpg", "Desktop", "nature")
+	insertTestImage(t, "https://example.com/city.jpg", "city")
+	ctx := context.Background()
+
+	img, err := chooseRandomImage(ctx, imageFilter{Tags: []string{"desktop"}})
+	if err != nil || !containsFold(img.Tags, "desktop") {
+		t.Errorf("单个标签应返回带该标签的图片，got %+v, %v", img, err)
+	}
+	if _, err := chooseRandomImage(ctx, imageFilter{Tags: []string{"city", "nature"}}); err != errNoImageFound {
+		t.Errorf("没有图片同时带两个标签时应返回 errNoImageFound，got %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		img, err := chooseRandomImage(ctx, imageFilter{Tags: []string{"desktop", "nature"}})
+		if err != nil || img.ID != both {
+			t.Fatalf("两个标签都匹配时只能返回图片 %d，got %+v, %v", both, img, err)
+		}
+	}
+}
+
+func containsFold(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRemoteReachability(t *testing.T) {
+	defer func(v bool) { allowPrivateDownload = v }(allowPrivateDownload)
+	allowPrivateDownload = true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+		case "/slow.png":
+			time.Sleep(200 * time.Millisecond)
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path   string
+		ok     bool
+		status int
+	}{
+		{"/ok.png", true, http.StatusOK},
+		{"/missing.png", false, http.StatusNotFound},
+		{"/page", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		got := remoteReachability(context.Background(), srv.URL+tt.path)
+		if got.OK != tt.ok || got.Status != tt.status {
+			t.Errorf("%s: got ok=%v status=%d, want ok=%v status=%d", tt.path, got.OK, got.Status, tt.ok, tt.status)
+		}
+		if !tt.ok && got.Error == "" {
+			t.Errorf("%s: 不可达时应返回错误原因", tt.path)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if got := remoteReachability(ctx, srv.URL+"/slow.png"); got.OK || got.Error == "" {
+		t.Errorf("超时的请求应视为不可达，got %+v", got)
+	}
+}
+
+func TestParseTagParamsLimit(t *testing.T) {
+	defer func(n int) { maxQueryTags = n }(maxQueryTags)
+	maxQueryTags = 3
+
+	tags, err := parseTagParams([]string{"a,b", "c"})
+	if err != nil || len(tags) != 3 {
+		t.Fatalf("恰好 %d 个标签应被接受，got %v, %v", maxQueryTags, tags, err)
+	}
+	// 重复的标签去重后计数
+	if _, err := parseTagParams([]string{"a,b,c", "A"}); err != nil {
+		t.Fatalf("去重后未超限的标签应被接受: %v", err)
+	}
+	if _, err := parseTagParams([]string{"a,b,c,d"}); err == nil {
+		t.Fatalf("%d 个标签应被拒绝", maxQueryTags+1)
+	}
+}
+
+func TestParseImageFilterTagLimit(t *testing.T) {
+	defer func(n int) { maxQueryTags = n }(maxQueryTags)
+	maxQueryTags = 3
+
+	q := url.Values{"tags": {"a,b"}, "exclude": {"c"}}
+	if _, err := parseImageFilter(q); err != nil {
+		t.Fatalf("tag 与 exclude 合计 %d 个应被接受: %v", maxQueryTags, err)
+	}
+	q.Add("exclude", "d")
+	if _, err := parseImageFilter(q); err == nil {
+		t.Fatalf("tag 与 exclude 合计 %d 个应被拒绝", maxQueryTags+1)
+	}
+}
+
+func BenchmarkRandomImageQuery(b *testing.B) {
+	f := imageFilter{Tags: []string{"desktop", "nature"}, Exclude: []string{"nsfw"}, MinWidth: 1920, Seed: "abc", Fresh: true}
+	for i := 0; i < b.N; i++ {
+		randomImageQuery(f, 1)
+	}
+}
+
+// BenchmarkChooseRandomImage 在 5000 张图片上比较按 id 定位和 ORDER BY 加权随机排序两种选择方式
+func BenchmarkChooseRandomImage(b *testing.B) {
+	testDB(b)
+	ctx := context.Background()
+	if _, err := dbpool.Exec(ctx, "INSERT INTO images (url, tags) SELECT 'https://example.com/' || g || '.jpg', ARRAY['bench'] FROM generate_series(1, 5000) AS g"); err != nil {
+		b.Fatal(err)
+	}
+	// 删除一部分图片，让 id 变得稀疏
+	if _, err := dbpool.Exec(ctx, "DELETE FROM images WHERE id % 7 = 0"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("id_seek", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := chooseByIDSeek(ctx, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("order_by_random", func(b *testing.B) {
+		// 带标签的过滤不走 id 定位，但候选集与上面相同
+		f := imageFilter{Tags: []string{"bench"}}
+		for i := 0; i < b.N; i++ {
+			if _, err := chooseRandomImages(ctx, f, 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// testPNG 返回一张 w×h 的纯色 PNG
+func testPNG(t testing.TB, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServeImageBytesNotModified(t *testing.T) {
+	data := testPNG(t, 4, 4, color.White)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = dir
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, img := range []Image{{ID: 1, URL: upstream.URL + "/a.png"}, {ID: 2, URL: "/local/a.png"}} {
+		opts := imageServeOptions{CacheControl: revalidateCacheControl}
+		rec := httptest.NewRecorder()
+		serveImageBytes(rec, httptest.NewRequest(http.MethodGet, "/random-image", nil), img, opts)
+		etag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: 首次请求应返回 200 和 ETag，got %d %q", img.URL, rec.Code, etag)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "no-cache" {
+			t.Errorf("%s: Cache-Control = %q，随机图片应允许重新验证", img.URL, cc)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/random-image", nil)
+		req.Header.Set("If-None-Match", etag)
+		rec = httptest.NewRecorder()
+		serveImageBytes(rec, req, img, opts)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s: 带匹配的 If-None-Match 应返回 304，got %d (%d 字节)", img.URL, rec.Code, rec.Body.Len())
+		}
+	}
+}
+
+func TestTagCountsAPIHandler(t *testing.T) {
+	testDB(t)
+	insertTestImage(t, "https://example.com/1.jpg", "desktop", "nature")
+	insertTestImage(t, "https://example.com/2.jpg", "desktop")
+	deleted := insertTestImage(t, "https://example.com/3.jpg", "nature")
+	if _, err := dbpool.Exec(context.Background(), "UPDATE images SET deleted_at = now() WHERE id = $1", deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	tagCountsAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/tags/counts", nil))
+	var got []TagCount
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []TagCount{{"desktop", 2}, {"nature", 1}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %v, want %v（回收站中的图片不计入）", got, want)
+	}
+}
+
+func TestBatchAddImagesHandler(t *testing.T) {
+	testDB(t)
+	insertTestImage(t, "https://example.com/existing.jpg")
+	count := func() int {
+		var n int
+		if err := dbpool.QueryRow(context.Background(), "SELECT COUNT(*) FROM images").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	body := `[{"url": "https://example.com/existing.jpg"}, {"url": "https://example.com/new.jpg", "tags": ["A", "a"]},
+		{"url": "https://example.com/other.jpg"}, {"url": "https://example.com/new.jpg"}]`
+	rec := httptest.NewRecorder()
+	batchAddImagesHandler(rec, httptest.NewRequest(http.MethodPost, "/api/images", strings.NewReader(body)))
+	var result batchAddResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("status %d: %v", rec.Code, err)
+	}
+	if result != (batchAddResult{Inserted: 2, Skipped: 2}) {
+		t.Errorf("已存在和批次内重复的 URL 应计为跳过，got %+v", result)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("images 表应有 3 行，got %d", n)
+	}
+	var nullTags int
+	if err := dbpool.QueryRow(context.Background(), "SELECT COUNT(*) FROM images WHERE tags IS NULL").Scan(&nullTags); err != nil {
+		t.Fatal(err)
+	}
+	if nullTags != 0 {
+		t.Errorf("没有标签的条目应保存为空数组，有 %d 行为 NULL", nullTags)
+	}
+
+	rec = httptest.NewRecorder()
+	body = `[{"url": "https://example.com/fourth.jpg"}, {"url": "not a url"}]`
+	batchAddImagesHandler(rec, httptest.NewRequest(http.MethodPost, "/api/images", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("包含无效 URL 时应返回 400，got %d", rec.Code)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("整批被拒绝时不应插入任何图片，got %d 行", n)
+	}
+}
+
+func TestBatchAddImagesLimits(t *testing.T) {
+	// 超限的请求在查询数据库之前被拒绝，不需要数据库
+	many := "[" + strings.Repeat(`{"url": "https://example.com/a.jpg"},`, maxBatchItems) + `{"url": "https://example.com/a.jpg"}]`
+	huge := `[{"url": "https://example.com/a.jpg", "tags": ["` + strings.Repeat("a", maxBatchBodyBytes) + `"]}]`
+	for name, body := range map[string]string{"条目过多": many, "请求体过大": huge} {
+		rec := httptest.NewRecorder()
+		batchAddImagesHandler(rec, httptest.NewRequest(http.MethodPost, "/api/images", strings.NewReader(body)))
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+func TestSafeLocalPath(t *testing.T) {
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = "/srv/images"
+
+	for _, name := range []string{"a.jpg", "sub/a.jpg", "a..b.jpg"} {
+		got, err := safeLocalPath(name)
+		if err != nil || got != filepath.Join("/srv/images", name) {
+			t.Errorf("%q: got %q, %v", name, got, err)
+		}
+	}
+	for _, name := range []string{
+		"", "../../etc/passwd", "..", "sub/../../a.jpg", "/etc/passwd", "sub//a.jpg",
+		`..\..\a.jpg`, ".hidden", "sub/.git/config", "./a.jpg", "thumb/150/a.jpg",
+	} {
+		if got, err := safeLocalPath(name); err == nil {
+			t.Errorf("%q 应被拒绝，got %q", name, got)
+		}
+	}
+}
+
+// uploadRequest 构造把 files（文件名到内容）作为 files 字段上传的 multipart 请求
+func uploadRequest(t *testing.T, files map[string][]byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, data := range files {
+		fw, err := mw.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/admin/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestAdminUploadHandler(t *testing.T) {
+	defer func(p string, n int64) { localImagesPath, maxUploadBytes = p, n }(localImagesPath, maxUploadBytes)
+	localImagesPath = t.TempDir()
+	small := testPNG(t, 2, 2, color.Black)
+	maxUploadBytes = int64(len(small))
+
+	req := uploadRequest(t, map[string][]byte{
+		"a.png":       small,
+		"b c.png":     small,
+		"too-big.png": append(append([]byte{}, small...), 0),
+	})
+	rec := httptest.NewRecorder()
+	adminUploadHandler(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("上传图片应跳转回文件列表，got %d: %s", rec.Code, rec.Body)
+	}
+	for _, name := range []string{"a.png", "b_c.png"} {
+		if data, err := os.ReadFile(filepath.Join(localImagesPath, name)); err != nil || !bytes.Equal(data, small) {
+			t.Errorf("%s 未正确保存: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(localImagesPath, "too-big.png")); !os.IsNotExist(err) {
+		t.Error("超过大小限制的文件应被跳过")
+	}
+
+	req = uploadRequest(t, map[string][]byte{"c.png": small, "notes.png": []byte("just text")})
+	rec = httptest.NewRecorder()
+	adminUploadHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("包含非图片文件时应返回 400，got %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(localImagesPath, "c.png")); !os.IsNotExist(err) {
+		t.Error("请求被拒绝时不应保存任何文件")
+	}
+}
+
+func TestImageDetailsLocalFile(t *testing.T) {
+	testDB(t)
+	root := t.TempDir()
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = filepath.Join(root, "library")
+	if err := os.MkdirAll(localImagesPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	data := testPNG(t, 4, 4, color.White)
+	os.WriteFile(filepath.Join(localImagesPath, "a.png"), data, 0o644)
+	os.WriteFile(filepath.Join(root, "secret.png"), data, 0o644)
+
+	details := func(id int) ImageDetails {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/admin/image/"+strconv.Itoa(id)+"/details", nil)
+		r.SetPathValue("id", strconv.Itoa(id))
+		rec := httptest.NewRecorder()
+		adminImageDetailsHandler(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var d ImageDetails
+		if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	d := details(insertTestImage(t, "/local/a.png"))
+	if !d.Local || !d.Reachability.OK || d.FileSize != int64(len(data)) || d.MimeType != "image/png" {
+		t.Errorf("本地文件的详情不正确: %+v", d)
+	}
+	// 绕过校验写入的越界路径不应暴露素材库之外文件的信息
+	d = details(insertTestImage(t, "/local/../secret.png"))
+	if d.Reachability.OK || d.FileSize != 0 || d.MimeType != "" || d.Reachability.Error == "" {
+		t.Errorf("越界路径不应读取文件: %+v", d)
+	}
+}
+
+func TestLocalSubdir(t *testing.T) {
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+
+	for in, want := range map[string]string{"": "", " /": "", "wallpapers": "wallpapers", "/a/b/": "a/b"} {
+		got, err := localSubdir(in)
+		if err != nil || got != want {
+			t.Errorf("localSubdir(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(localImagesPath, "a", "b")); err != nil || !info.IsDir() {
+		t.Errorf("子目录应被创建: %v", err)
+	}
+	for _, dir := range []string{"thumb", "thumb/150", "../x", ".thumbs", "a/../.."} {
+		if got, err := localSubdir(dir); err == nil {
+			t.Errorf("localSubdir(%q) 应被拒绝，got %q", dir, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(localImagesPath, "thumb")); !os.IsNotExist(err) {
+		t.Error("被拒绝的子目录不应被创建")
+	}
+}
+
+func TestListLocalFilesNested(t *testing.T) {
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+	for _, name := range []string{"top.png", "a/one.png", "a/b/two.png", ".thumbs/150/top.png", "a/.tmp-upload"} {
+		p := filepath.Join(localImagesPath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := listLocalFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "a/b/two.png,a/one.png,top.png" {
+		t.Errorf("listLocalFiles = %v", names)
+	}
+}
+
+func TestPublicImageHidesAdminFields(t *testing.T) {
+	data, err := json.Marshal(publicImages([]Image{{ID: 1, URL: "https://example.com/a.jpg", Tags: []string{"a"}, Weight: 5, Views: 42}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got[0]["weight"]; ok {
+		t.Errorf("公开 JSON 不应包含 weight: %s", data)
+	}
+	if _, ok := got[0]["views"]; ok {
+		t.Errorf("公开 JSON 不应包含 views: %s", data)
+	}
+	if got[0]["id"] != 1.0 || got[0]["url"] != "https://example.com/a.jpg" {
+		t.Errorf("其他字段应保持不变: %s", data)
+	}
+	if data, _ := json.Marshal(publicImages(nil)); string(data) != "[]" {
+		t.Errorf("没有图片时应编码为空数组，got %s", data)
+	}
+}
+
+func TestZeroWeightNeverChosen(t *testing.T) {
+	testDB(t)
+	hidden := insertTestImage(t, "https://example.com/hidden.jpg", "a")
+	visible := insertTestImage(t, "https://example.com/visible.jpg", "a")
+	ctx := context.Background()
+	if _, err := dbpool.Exec(ctx, "UPDATE images SET weight = 0 WHERE id = $1", hidden); err != nil {
+		t.Fatal(err)
+	}
+	images, err := chooseRandomImages(ctx, imageFilter{Tags: []string{"a"}}, 10)
+	if err != nil || len(images) != 1 || images[0].ID != visible {
+		t.Errorf("权重为 0 的图片不应被选中，got %+v, %v", images, err)
+	}
+	if _, err := dbpool.Exec(ctx, "UPDATE images SET weight = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chooseRandomImage(ctx, imageFilter{Tags: []string{"a"}}); err != errNoImageFound {
+		t.Errorf("所有图片权重都为 0 时应返回 errNoImageFound，got %v", err)
+	}
+}
+
+func TestAvoidLastFallsBackToOnlyMatch(t *testing.T) {
+	testDB(t)
+	only := insertTestImage(t, "https://example.com/only.jpg", "rare")
+	other := insertTestImage(t, "https://example.com/other.jpg", "common")
+	insertTestImage(t, "https://example.com/other2.jpg", "common")
+	ctx := context.Background()
+
+	img, err := chooseRandomImage(ctx, imageFilter{Tags: []string{"rare"}, AvoidID: only})
+	if err != nil || img.ID != only {
+		t.Errorf("只有一张匹配的图片时应忽略 last 仍返回它，got %+v, %v", img, err)
+	}
+	for i := 0; i < 10; i++ {
+		img, err := chooseRandomImage(ctx, imageFilter{Tags: []string{"common"}, AvoidID: other})
+		if err != nil || img.ID == other {
+			t.Fatalf("还有其他候选时不应返回 last 指定的图片，got %+v, %v", img, err)
+		}
+	}
+}
+
+func TestImageAttribution(t *testing.T) {
+	testDB(t)
+	credited := insertTestImage(t, "https://example.com/credited.jpg")
+	plain := insertTestImage(t, "https://example.com/plain.jpg")
+	ctx := context.Background()
+	if _, err := dbpool.Exec(ctx, "UPDATE images SET source = $1, author = $2 WHERE id = $3", "https://unsplash.com/photos/x", "Jane Doe", credited); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		id             int
+		source, author string
+	}{{credited, "https://unsplash.com/photos/x", "Jane Doe"}, {plain, "", ""}} {
+		img, err := scanImage(dbpool.QueryRow(ctx, "SELECT "+imageColumns+" FROM images WHERE id = $1", tt.id))
+		if err != nil {
+			t.Fatalf("署名为 NULL 的图片也应能正常扫描: %v", err)
+		}
+		if img.Source != tt.source || img.Author != tt.author {
+			t.Errorf("图片 %d: got %q %q, want %q %q", tt.id, img.Source, img.Author, tt.source, tt.author)
+		}
+		data, _ := json.Marshal(publicImage{Image: img})
+		if has := strings.Contains(string(data), `"author"`); has != (tt.author != "") {
+			t.Errorf("图片 %d: 只有填写了署名时 JSON 才包含 author: %s", tt.id, data)
+		}
+	}
+}
+
+func TestServeImageBytesHead(t *testing.T) {
+	data := testPNG(t, 8, 8, color.Black)
+	var upstreamMethods []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamMethods = append(upstreamMethods, r.Method)
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method != http.MethodHead {
+			w.Write(data)
+		}
+	}))
+	defer upstream.Close()
+
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+	if err := os.WriteFile(filepath.Join(localImagesPath, "a.png"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, img := range []Image{{ID: 1, URL: upstream.URL + "/a.png"}, {ID: 2, URL: "/local/a.png"}} {
+		rec := httptest.NewRecorder()
+		serveImageBytes(rec, httptest.NewRequest(http.MethodHead, "/random-image", nil), img, imageServeOptions{CacheControl: revalidateCacheControl})
+		if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+			t.Errorf("%s: HEAD 应返回 200 且没有响应体，got %d (%d 字节)", img.URL, rec.Code, rec.Body.Len())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+			t.Errorf("%s: Content-Type = %q", img.URL, ct)
+		}
+		if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(len(data)) {
+			t.Errorf("%s: Content-Length = %q, want %d", img.URL, cl, len(data))
+		}
+	}
+	if len(upstreamMethods) != 1 || upstreamMethods[0] != http.MethodHead {
+		t.Errorf("远程图片的 HEAD 请求应只向图床发 HEAD，got %v", upstreamMethods)
+	}
+}
+
+func TestForwardRangeResponse(t *testing.T) {
+	data := testPNG(t, 32, 32, color.White)
+	var upstreamRanges []string
+	honorRange := true
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRanges = append(upstreamRanges, r.Header.Get("Range"))
+		w.Header().Set("Content-Type", "image/png")
+		if !honorRange {
+			w.Write(data)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer upstream.Close()
+
+	defer func(c *imageCache) { remoteImageCache = c }(remoteImageCache)
+	remoteImageCache = newImageCache(1<<20, time.Minute)
+	img := Image{ID: 1, URL: upstream.URL + "/a.png"}
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		upstreamRanges = nil
+		req := httptest.NewRequest(http.MethodGet, "/random-image", nil)
+		req.Header = header
+		rec := httptest.NewRecorder()
+		serveImageBytes(rec, req, img, imageServeOptions{CacheControl: revalidateCacheControl})
+		return rec
+	}
+
+	// 图床支持 Range 时原样转发 206 片段，片段不写入缓存
+	rec := serve(http.Header{"Range": {"bytes=0-9"}})
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), data[:10]) {
+		t.Fatalf("Range 请求应返回前 10 字节的 206，got %d (%d 字节)", rec.Code, rec.Body.Len())
+	}
+	if got, want := rec.Header().Get("Content-Range"), fmt.Sprintf("bytes 0-9/%d", len(data)); got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+	if rec.Header().Get("Content-Type") != "image/png" || rec.Header().Get("Content-Length") != "10" || rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("响应头未正确转发: %v", rec.Header())
+	}
+	if len(upstreamRanges) != 1 || upstreamRanges[0] != "bytes=0-9" {
+		t.Errorf("Range 应转发给图床，got %q", upstreamRanges)
+	}
+	if _, _, ok := remoteImageCache.Get(img.URL); ok {
+		t.Error("片段不应写入缓存")
+	}
+
+	// 超出范围时转发 416，且不允许缓存
+	rec = serve(http.Header{"Range": {fmt.Sprintf("bytes=%d-", len(data)+100)}})
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("超出范围的 Range 应返回 416，got %d", rec.Code)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != noCacheControl {
+		t.Errorf("416 的 Cache-Control = %q", cc)
+	}
+
+	// 带 If-Range 时不转发 Range，由本地按完整图片处理
+	rec = serve(http.Header{"Range": {"bytes=0-9"}, "If-Range": {`"stale"`}})
+	if len(upstreamRanges) != 1 || upstreamRanges[0] != "" {
+		t.Errorf("带 If-Range 的请求不应转发 Range，got %q", upstreamRanges)
+	}
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Errorf("If-Range 不匹配时应返回完整图片，got %d (%d 字节)", rec.Code, rec.Body.Len())
+	}
+
+	// 图床忽略 Range 时在本地截取片段
+	honorRange = false
+	remoteImageCache = newImageCache(1<<20, time.Minute)
+	rec = serve(http.Header{"Range": {"bytes=10-19"}})
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), data[10:20]) {
+		t.Errorf("图床忽略 Range 时应在本地返回 206 片段，got %d (%d 字节)", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestCheckImageURLRejectsPrivateHosts(t *testing.T) {
+	requested := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.Header().Set("Content-Type", "image/png")
+	}))
+	defer srv.Close()
+
+	for _, u := range []string{srv.URL + "/a.png", "http://169.254.169.254/latest/meta-data/", "file:///etc/passwd"} {
+		if err := checkImageURL(context.Background(), u); err == nil {
+			t.Errorf("%s: 内网或非 http 地址应被拒绝", u)
+		}
+	}
+	if requested {
+		t.Error("被拒绝的地址不应被请求")
+	}
+	if err := checkImageURL(context.Background(), "/local/a.png"); err != nil {
+		t.Errorf("本地图片不检查: %v", err)
+	}
+}
+
+func TestImageDetailsHidesTrashedImage(t *testing.T) {
+	testDB(t)
+	id := insertTestImage(t, "/local/trashed.png", "cat")
+	if _, err := dbpool.Exec(context.Background(), "UPDATE images SET deleted_at = now() WHERE id = $1", id); err != nil {
+		t.Fatalf("移入回收站失败: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/admin/image/"+strconv.Itoa(id)+"/details", nil)
+	r.SetPathValue("id", strconv.Itoa(id))
+	rec := httptest.NewRecorder()
+	adminImageDetailsHandler(rec, r)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestStarredFilterExcludesUnstarred(t *testing.T) {
+	testDB(t)
+	starred := insertTestImage(t, "https://example.com/starred.jpg", "a")
+	insertTestImage(t, "https://example.com/plain.jpg", "a")
+	insertTestImage(t, "https://example.com/plain2.jpg", "a")
+	ctx := context.Background()
+	if _, err := dbpool.Exec(ctx, "UPDATE images SET starred = true WHERE id = $1", starred); err != nil {
+		t.Fatal(err)
+	}
+	images, err := chooseRandomImages(ctx, imageFilter{Starred: true}, 10)
+	if err != nil || len(images) != 1 || images[0].ID != starred {
+		t.Errorf("starred=1 时只应返回收藏的图片，got %+v, %v", images, err)
+	}
+	if _, err := chooseRandomImage(ctx, imageFilter{Starred: true, Tags: []string{"b"}}); err != errNoImageFound {
+		t.Errorf("没有收藏的图片带该标签时应返回 errNoImageFound，got %v", err)
+	}
+}
+
+func TestRandomImagePlaceholderWhenNothingMatches(t *testing.T) {
+	testDB(t)
+	defer func(p string, f []byte) { localImagesPath, fallbackImage = p, f }(localImagesPath, fallbackImage)
+	localImagesPath = t.TempDir()
+	fallbackImage = nil
+	insertTestImage(t, "https://example.com/cat.jpg", "cat")
+	tagged, global := testPNG(t, 2, 2, color.White), testPNG(t, 3, 3, color.Black)
+	os.WriteFile(filepath.Join(localImagesPath, "dog.png"), tagged, 0o644)
+	os.WriteFile(filepath.Join(localImagesPath, "none.png"), global, 0o644)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		randomImageProxyHandler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	if rec := get("/random-image?tag=dog"); rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") == "image/png" {
+		t.Errorf("没有任何占位图时应返回文本 404，got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	fallbackImage = testPNG(t, 1, 1, color.Black)
+	if rec := get("/random-image?tag=dog"); rec.Code != http.StatusNotFound || !bytes.Equal(rec.Body.Bytes(), fallbackImage) {
+		t.Errorf("只配置了 FALLBACK_IMAGE_PATH 时应返回兜底图片，got %d", rec.Code)
+	}
+
+	ctx := context.Background()
+	if err := setSetting(ctx, placeholderSettingKey(""), "none.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setSetting(ctx, placeholderSettingKey("dog"), "dog.png"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target string
+		want   []byte
+	}{
+		{"/random-image?tag=dog", tagged},
+		{"/random-image?tag=bird", global},
+		{"/random-image?tag=dog&tag=cat", global},
+	}
+	for _, tt := range tests {
+		rec := get(tt.target)
+		if rec.Code != http.StatusNotFound || !bytes.Equal(rec.Body.Bytes(), tt.want) {
+			t.Errorf("%s: status = %d, 返回的不是预期的占位图", tt.target, rec.Code)
+		}
+		if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+			t.Errorf("%s: 占位图不应被缓存，Cache-Control = %q", tt.target, cc)
+		}
+	}
+	if rec := get("/random-image?tag=cat&mode=redirect"); rec.Code != http.StatusFound {
+		t.Errorf("有匹配的图片时不应返回占位图，got %d", rec.Code)
+	}
+}
+
+func TestRandomImageQueryPlaceholders(t *testing.T) {
+	f := imageFilter{
+		Tags:      []string{"cat"},
+		Exclude:   []string{"nsfw"},
+		AvoidID:   7,
+		Seed:      "abc",
+		Fresh:     true,
+		NearColor: "#102030",
+		NearRGB:   [3]int{16, 32, 48},
+		MinWidth:  800,
+	}
+	query, args := randomImageQuery(f, 5)
+
+	// 每个参数都被引用，且没有引用不存在的参数
+	used := make(map[int]bool)
+	for _, m := range regexp.MustCompile(`\$(\d+)`).FindAllStringSubmatch(query, -1) {
+		n, _ := strconv.Atoi(m[1])
+		if n < 1 || n > len(args) {
+			t.Fatalf("查询引用了不存在的参数 $%d（共 %d 个参数）: %s", n, len(args), query)
+		}
+		used[n] = true
+	}
+	if len(used) != len(args) {
+		t.Errorf("共 %d 个参数，查询只引用了 %d 个: %s", len(args), len(used), query)
+	}
+
+	// 各表达式引用的参数与其取值对应
+	arg := func(pattern string) interface{} {
+		t.Helper()
+		m := regexp.MustCompile(pattern).FindStringSubmatch(query)
+		if m == nil {
+			t.Fatalf("查询中没有匹配 %s 的表达式: %s", pattern, query)
+		}
+		n, _ := strconv.Atoi(m[1])
+		return args[n-1]
+	}
+	if v := arg(`created_at\)::float8 / \$(\d+)`); v != freshHalfLife.Seconds() {
+		t.Errorf("fresh 半衰期参数 = %v, want %v", v, freshHalfLife.Seconds())
+	}
+	if v := arg(`hashtext\(id::text \|\| \$(\d+)\)`); v != "abc" {
+		t.Errorf("seed 参数 = %v, want abc", v)
+	}
+	if v := arg(`substr\(color, 2, 2\)\)::bit\(8\)::int - \$(\d+)\)`); v != 16 {
+		t.Errorf("near_color 红色通道参数 = %v, want 16", v)
+	}
+	if v := arg(`substr\(color, 6, 2\)\)::bit\(8\)::int - \$(\d+)\)`); v != 48 {
+		t.Errorf("near_color 蓝色通道参数 = %v, want 48", v)
+	}
+	if v := arg(`width >= \$(\d+)`); v != 800 {
+		t.Errorf("min_width 参数 = %v, want 800", v)
+	}
+	if v := arg(`id <> \$(\d+)`); v != 7 {
+		t.Errorf("last 参数 = %v, want 7", v)
+	}
+	if v := arg(`LIMIT \$(\d+)$`); v != 5 {
+		t.Errorf("LIMIT 参数 = %v, want 5", v)
+	}
+	// fresh 与 seed 同时使用时，确定性排序仍使用衰减后的权重
+	if !strings.Contains(query, "4294967296.0) / (weight * (1 + ") {
+		t.Errorf("seed 排序没有使用 fresh 权重: %s", query)
+	}
+}
+
+func TestRandomImageQueryCombinedRuns(t *testing.T) {
+	testDB(t)
+	insertTestImage(t, "https://example.com/a.jpg", "cat")
+	f := imageFilter{Tags: []string{"cat"}, Seed: "abc", Fresh: true, NearColor: "#102030", NearRGB: [3]int{16, 32, 48}, MinWidth: 800}
+	images, err := chooseRandomImages(context.Background(), f, 5)
+	if err != nil || len(images) != 1 {
+		t.Errorf("组合过滤条件的查询应能执行，got %+v, %v", images, err)
+	}
+}
+
+// moveFileSetup 在临时素材库中创建 a.png 并发布为图片，返回图片 id
+func moveFileSetup(t *testing.T) int {
+	t.Helper()
+	localImagesPath = t.TempDir()
+	if err := os.WriteFile(filepath.Join(localImagesPath, "a.png"), testPNG(t, 2, 2, color.White), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return insertTestImage(t, "/local/a.png", "cat")
+}
+
+func imageURL(t *testing.T, id int) string {
+	t.Helper()
+	var u string
+	if err := dbpool.QueryRow(context.Background(), "SELECT url FROM images WHERE id = $1", id).Scan(&u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestMoveFileUpdatesImageURL(t *testing.T) {
+	testDB(t)
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	id := moveFileSetup(t)
+	if err := setSetting(context.Background(), placeholderSettingKey("cat"), "a.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	adminMoveFileHandler(rec, postForm("/admin/move_file", url.Values{"file_name": {"a.png"}, "dir": {"sub/dir"}}))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if got := imageURL(t, id); got != "/local/sub/dir/a.png" {
+		t.Errorf("图片 URL = %q, want /local/sub/dir/a.png", got)
+	}
+	if v, _, _ := getSetting(context.Background(), placeholderSettingKey("cat")); v != "sub/dir/a.png" {
+		t.Errorf("占位图设置 = %q, want sub/dir/a.png", v)
+	}
+	if _, err := os.Stat(filepath.Join(localImagesPath, "sub", "dir", "a.png")); err != nil {
+		t.Errorf("文件没有移动到新位置: %v", err)
+	}
+}
+
+func TestMoveFileRollsBackOnCommitFailure(t *testing.T) {
+	testDB(t)
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	id := moveFileSetup(t)
+
+	// 延迟到提交时才检查的约束触发器让事务在 os.Rename 之后的 Commit 中失败
+	ctx := context.Background()
+	_, err := dbpool.Exec(ctx, `
+		CREATE OR REPLACE FUNCTION test_fail_commit() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION 'commit rejected by test'; END $$ LANGUAGE plpgsql;
+		CREATE CONSTRAINT TRIGGER test_fail_commit AFTER UPDATE ON images
+			DEFERRABLE INITIALLY DEFERRED FOR EACH ROW EXECUTE FUNCTION test_fail_commit();`)
+	if err != nil {
+		t.Fatalf("创建触发器失败: %v", err)
+	}
+	t.Cleanup(func() {
+		dbpool.Exec(ctx, "DROP TRIGGER IF EXISTS test_fail_commit ON images; DROP FUNCTION IF EXISTS test_fail_commit()")
+	})
+
+	rec := httptest.NewRecorder()
+	adminMoveFileHandler(rec, postForm("/admin/move_file", url.Values{"file_name": {"a.png"}, "dir": {"sub"}}))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if got := imageURL(t, id); got != "/local/a.png" {
+		t.Errorf("提交失败后图片 URL 应保持不变，got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(localImagesPath, "a.png")); err != nil {
+		t.Errorf("提交失败后文件应移回原处: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(localImagesPath, "sub", "a.png")); !os.IsNotExist(err) {
+		t.Errorf("提交失败后新位置不应留下文件: %v", err)
+	}
+}
+
+func TestCacheControlByEndpoint(t *testing.T) {
+	testDB(t)
+	defer func(p, cc string) { localImagesPath, imageCacheControl = p, cc }(localImagesPath, imageCacheControl)
+	localImagesPath = t.TempDir()
+	os.WriteFile(filepath.Join(localImagesPath, "a.png"), testPNG(t, 2, 2, color.White), 0o644)
+	id := insertTestImage(t, "/local/a.png", "cat")
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		target    string
+		configure string // CACHE_CONTROL
+		want      string
+	}{
+		{"随机图片默认重新验证", randomImageProxyHandler, "/random-image", "", revalidateCacheControl},
+		{"随机图片使用 CACHE_CONTROL", randomImageProxyHandler, "/random-image", "public, max-age=3600", "public, max-age=3600"},
+		{"按 id 取图总是允许缓存", imageHandler, "/image?id=" + strconv.Itoa(id), "", stableImageCacheControl},
+		{"按 id 取图不受 CACHE_CONTROL 影响", imageHandler, "/image?id=" + strconv.Itoa(id), "public, max-age=60", stableImageCacheControl},
+		{"JSON 接口禁止缓存", randomImageAPIHandler, "/api/random-image", "public, max-age=3600", noCacheControl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageCacheControl = tt.configure
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if got := rec.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control = %q, want %q (status %d)", got, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestImageErrorResetsCacheControl(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Cache-Control", stableImageCacheControl)
+	imageError(rec, "图床返回错误", http.StatusBadGateway)
+	if got := rec.Header().Get("Cache-Control"); got != noCacheControl {
+		t.Errorf("出错时 Cache-Control = %q, want %q", got, noCacheControl)
+	}
+}
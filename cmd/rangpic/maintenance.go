@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// --- 维护模式 ---
+
+// maintenanceSettingKey 是 settings 表中保存维护模式开关的设置项，值为 "1" 表示开启
+const maintenanceSettingKey = "maintenance_mode"
+
+// maintenanceRefreshInterval 是从数据库重新读取开关的间隔，多实例部署时其他实例在该时间内跟随切换
+const maintenanceRefreshInterval = 15 * time.Second
+
+// maintenanceOn 缓存维护模式开关，中间件每个请求都会读取，避免每次都查询数据库
+var maintenanceOn atomic.Bool
+
+// maintenancePage 是维护期间返回给公开页面和接口的页面
+const maintenancePage = `<!DOCTYPE html><html lang="zh-CN"><head><meta charset="UTF-8"><title>维护中</title><style>body{font-family: sans-serif; text-align: center; padding-top: 20vh; color: #333;}</style></head><body>
+<h1>站点维护中</h1>
+<p>我们正在进行数据维护，请稍后再来。</p>
+</body></html>`
+
+// loadMaintenanceMode 从 settings 表读取维护模式开关，出错时保持当前状态
+func loadMaintenanceMode(ctx context.Context) {
+	value, _, err := getSetting(ctx, maintenanceSettingKey)
+	if err != nil {
+		slog.Error("读取维护模式设置失败", "err", err)
+		return
+	}
+	on := value == "1"
+	if maintenanceOn.Swap(on) != on {
+		slog.Info("维护模式状态变化", "enabled", on)
+	}
+}
+
+// startMaintenanceWatcher 定期重新读取维护模式开关，使其他实例上的切换也能生效
+func startMaintenanceWatcher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(maintenanceRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				loadMaintenanceMode(ctx)
+			}
+		}
+	}()
+}
+
+// maintenanceExempt 判断请求在维护期间是否仍然放行：后台页面（包括登录页）、健康检查和监控指标，
+// 以及带有效会话或 API 令牌的请求（管理员在后台预览图片时会访问 /local/ 等公开路径）
+func maintenanceExempt(r *http.Request) bool {
+	p := r.URL.Path
+	if p == "/admin" || strings.HasPrefix(p, "/admin/") || p == "/healthz" || p == "/readyz" || p == "/metrics" {
+		return true
+	}
+	if token, ok := bearerToken(r); ok && apiToken != "" {
+		return validAPIToken(token)
+	}
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return false
+	}
+	_, ok, err := lookupSession(r.Context(), cookie.Value)
+	if err != nil {
+		requestLogger(r).Error("查询会话失败", "err", err)
+	}
+	return ok
+}
+
+// maintenanceMiddleware 在维护模式开启时对公开页面和接口返回 503 和一个简短的维护页面，后台不受影响
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !maintenanceOn.Load() || maintenanceExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", noCacheControl)
+		w.Header().Set("Retry-After", "300")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if r.Method != http.MethodHead {
+			w.Write([]byte(maintenancePage))
+		}
+	})
+}
+
+// adminMaintenanceHandler 开启或关闭维护模式，enabled=1 开启，其他值关闭
+func adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	on := r.FormValue("enabled") == "1"
+	var err error
+	if on {
+		err = setSetting(r.Context(), maintenanceSettingKey, "1")
+	} else {
+		err = deleteSetting(r.Context(), maintenanceSettingKey)
+	}
+	if err != nil {
+		http.Error(w, "保存维护模式设置失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	maintenanceOn.Store(on)
+	requestLogger(r).Info("切换维护模式", "enabled", on)
+	if on {
+		setFlash(w, "维护模式已开启，公开页面和接口暂时返回 503")
+	} else {
+		setFlash(w, "维护模式已关闭")
+	}
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	defer func(on bool) { maintenanceOn.Store(on) }(maintenanceOn.Load())
+	defer func(v string) { apiToken = v }(apiToken)
+	apiToken = "secret"
+	h := maintenanceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	// 请求都不带 session_token，不会查询数据库
+	tests := []struct {
+		method string
+		path   string
+		bearer string
+		want   int
+	}{
+		{http.MethodGet, "/random-image", "", http.StatusServiceUnavailable},
+		{http.MethodHead, "/random-image", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/api/random-image", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/administrator", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/random-image", "wrong", http.StatusServiceUnavailable},
+		{http.MethodGet, "/random-image", "secret", http.StatusOK},
+		{http.MethodGet, "/admin", "", http.StatusOK},
+		{http.MethodGet, "/admin/login", "", http.StatusOK},
+		{http.MethodGet, "/healthz", "", http.StatusOK},
+		{http.MethodGet, "/metrics", "", http.StatusOK},
+	}
+	maintenanceOn.Store(true)
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.bearer)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s (bearer %q): status = %d, want %d", tt.method, tt.path, tt.bearer, rec.Code, tt.want)
+			continue
+		}
+		if tt.want != http.StatusServiceUnavailable {
+			continue
+		}
+		if rec.Header().Get("Retry-After") == "" || rec.Header().Get("Cache-Control") != noCacheControl {
+			t.Errorf("%s %s: 维护响应缺少 Retry-After 或不应被缓存: %v", tt.method, tt.path, rec.Header())
+		}
+		if (rec.Body.Len() == 0) != (tt.method == http.MethodHead) {
+			t.Errorf("%s %s: 响应体 %d 字节", tt.method, tt.path, rec.Body.Len())
+		}
+	}
+
+	maintenanceOn.Store(false)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/random-image", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("关闭维护模式后 status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
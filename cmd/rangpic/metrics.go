@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// --- Prometheus 指标 ---
+
+var (
+	randomImageRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rangpic_random_image_requests_total",
+		Help: "随机图片请求总数，按接口区分",
+	}, []string{"endpoint"})
+
+	randomImageSelections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rangpic_random_image_selections_total",
+		Help: "数据库随机选择的次数，按结果区分（found/not_found/error）",
+	}, []string{"result"})
+
+	proxyFetchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rangpic_proxy_fetch_failures_total",
+		Help: "拉取图床图片失败的次数，按原因区分",
+	}, []string{"reason"})
+
+	proxyFetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rangpic_proxy_fetch_duration_seconds",
+		Help:    "拉取图床图片的耗时",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	httpResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rangpic_http_responses_total",
+		Help: "HTTP 响应数，按状态码区分",
+	}, []string{"code"})
+)
+
+// observeSelection 按随机选择的结果累加计数
+func observeSelection(err error) {
+	switch {
+	case err == nil:
+		randomImageSelections.WithLabelValues("found").Inc()
+	case errors.Is(err, errNoImageFound):
+		randomImageSelections.WithLabelValues("not_found").Inc()
+	default:
+		randomImageSelections.WithLabelValues("error").Inc()
+	}
+}
+
+func observeResponse(status int) {
+	httpResponses.WithLabelValues(strconv.Itoa(status)).Inc()
+}
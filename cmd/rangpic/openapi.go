@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// --- OpenAPI 描述 ---
+
+// 以下结构只包含本服务用到的 OpenAPI 3 字段
+type openAPIDoc struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       openAPIInfo                `json:"info"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components openAPIComponents          `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIPathItem struct {
+	Get *openAPIOperation `json:"get,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref         string                    `json:"$ref,omitempty"`
+	Type        string                    `json:"type,omitempty"`
+	Format      string                    `json:"format,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Enum        []string                  `json:"enum,omitempty"`
+	Minimum     *int                      `json:"minimum,omitempty"`
+	Maximum     *int                      `json:"maximum,omitempty"`
+	Items       *openAPISchema            `json:"items,omitempty"`
+	Properties  map[string]*openAPISchema `json:"properties,omitempty"`
+	Required    []string                  `json:"required,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+// openAPIRef 返回指向 components/schemas 中某个结构的引用
+func openAPIRef(name string) *openAPISchema {
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+// openAPIInt 返回指向 n 的指针，用于 minimum/maximum
+func openAPIInt(n int) *int {
+	return &n
+}
+
+// jsonResponse 返回内容为 application/json 的响应描述
+func jsonResponse(description string, schema *openAPISchema) openAPIResponse {
+	return openAPIResponse{Description: description, Content: map[string]openAPIMediaType{
+		"application/json": {Schema: schema},
+	}}
+}
+
+// imageFilterParameters 是随机图片接口共用的过滤参数，与 parseImageFilter 对应
+func imageFilterParameters() []openAPIParameter {
+	str := &openAPISchema{Type: "string"}
+	return []openAPIParameter{
+		{Name: "tag", In: "query", Description: "要求包含的标签，可重复传入，也可逗号分隔", Schema: &openAPISchema{Type: "array", Items: str}},
+		{Name: "tags", In: "query", Description: "逗号分隔的标签，兼容旧客户端", Schema: str},
+		{Name: "match", In: "query", Description: "all 需包含全部标签，any 包含任一标签即可", Schema: &openAPISchema{Type: "string", Enum: []string{"all", "any"}}},
+		{Name: "exclude", In: "query", Description: "排除包含这些标签的图片，可重复传入", Schema: &openAPISchema{Type: "array", Items: str}},
+		{Name: "min_width", In: "query", Description: "优先返回宽度不小于该值的图片", Schema: &openAPISchema{Type: "integer", Minimum: openAPIInt(1)}},
+		{Name: "viewport_width", In: "query", Description: "视口宽度，与 dpr 相乘后作为最小宽度", Schema: &openAPISchema{Type: "integer", Minimum: openAPIInt(1), Maximum: openAPIInt(10000)}},
+		{Name: "dpr", In: "query", Description: "设备像素比，默认 1", Schema: &openAPISchema{Type: "number"}},
+		{Name: "last", In: "query", Description: "上一次拿到的图片 id，尽量不连续返回同一张", Schema: &openAPISchema{Type: "integer", Minimum: openAPIInt(1)}},
+		{Name: "seed", In: "query", Description: "相同种子在数据不变时总是返回同一张图片", Schema: str},
+		{Name: "starred", In: "query", Description: "为 1 时只在收藏的图片中选择", Schema: &openAPISchema{Type: "string", Enum: []string{"1"}}},
+		{Name: "fresh", In: "query", Description: "为 1 时新添加的图片更容易被选中", Schema: &openAPISchema{Type: "string", Enum: []string{"1"}}},
+		{Name: "balanced", In: "query", Description: "为 1 时先按图片数的倒数为权重选择一个标签分类，再在其中选择图片，小分类更容易出现（只对返回单张图片的接口生效）", Schema: &openAPISchema{Type: "string", Enum: []string{"1"}}},
+		{Name: "near_color", In: "query", Description: "RRGGBB 格式的颜色，优先返回主色调接近的图片", Schema: str},
+	}
+}
+
+// openAPIDocument 描述本服务的公开 JSON 接口，新增或修改接口时需同步更新
+var openAPIDocument = func() openAPIDoc {
+	errorResponse := openAPIResponse{Description: "错误信息（纯文本）", Content: map[string]openAPIMediaType{
+		"text/plain": {Schema: &openAPISchema{Type: "string"}},
+	}}
+	str := &openAPISchema{Type: "string"}
+	idParam := openAPIParameter{Name: "id", In: "query", Required: true, Description: "图片 id", Schema: &openAPISchema{Type: "integer", Minimum: openAPIInt(1)}}
+
+	return openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "RangPic API",
+			Description: "随机图片服务的公开 JSON 接口",
+			Version:     "1.0.0",
+		},
+		Paths: map[string]openAPIPathItem{
+			"/api/random-image": {Get: &openAPIOperation{
+				OperationID: "getRandomImage",
+				Summary:     "随机返回一张图片的信息",
+				Parameters: append(imageFilterParameters(), openAPIParameter{
+					Name: "allow_empty", In: "query", Description: "为 1 时没有匹配的图片返回 204 而不是 404", Schema: &openAPISchema{Type: "string", Enum: []string{"1"}},
+				}),
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("随机选中的图片", openAPIRef("Image")),
+					"204": {Description: "没有匹配的图片（allow_empty=1 时）"},
+					"400": errorResponse,
+					"404": errorResponse,
+				},
+			}},
+			"/api/random-images": {Get: &openAPIOperation{
+				OperationID: "getRandomImages",
+				Summary:     "一次返回多张互不重复的随机图片",
+				Parameters: append(imageFilterParameters(), openAPIParameter{
+					Name: "count", In: "query", Description: "返回的数量，默认 10", Schema: &openAPISchema{Type: "integer", Minimum: openAPIInt(1), Maximum: openAPIInt(maxRandomImagesCount)},
+				}),
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("随机选中的图片，没有匹配时为空数组", &openAPISchema{Type: "array", Items: openAPIRef("Image")}),
+					"400": errorResponse,
+				},
+			}},
+			"/api/image": {Get: &openAPIOperation{
+				OperationID: "getImage",
+				Summary:     "按 id 返回单张图片的信息",
+				Parameters:  []openAPIParameter{idParam},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("图片信息", openAPIRef("Image")),
+					"404": errorResponse,
+				},
+			}},
+			"/api/tags": {Get: &openAPIOperation{
+				OperationID: "listTags",
+				Summary:     "按字母顺序返回所有标签",
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("标签列表", &openAPISchema{Type: "array", Items: str}),
+				},
+			}},
+			"/api/tags/counts": {Get: &openAPIOperation{
+				OperationID: "listTagCounts",
+				Summary:     "按图片数量从多到少返回每个标签的使用次数",
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("标签及其图片数量", &openAPISchema{Type: "array", Items: openAPIRef("TagCount")}),
+				},
+			}},
+			"/api/tags/suggest": {Get: &openAPIOperation{
+				OperationID: "suggestTags",
+				Summary:     "返回包含 q 的已有标签，用于自动补全",
+				Parameters:  []openAPIParameter{{Name: "q", In: "query", Description: "要查找的片段，不区分大小写", Schema: str}},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("至多 10 个标签，以 q 开头的在前", &openAPISchema{Type: "array", Items: str}),
+				},
+			}},
+			"/api/random-image.datauri": {Get: &openAPIOperation{
+				OperationID: "getRandomImageDataURI",
+				Summary:     "随机返回一张图片的 data URI",
+				Parameters:  imageFilterParameters(),
+				Responses: map[string]openAPIResponse{
+					"200": {Description: "data:<类型>;base64,<内容>", Content: map[string]openAPIMediaType{
+						"text/plain": {Schema: str},
+					}},
+					"404": errorResponse,
+					"413": errorResponse,
+				},
+			}},
+		},
+		Components: openAPIComponents{Schemas: map[string]*openAPISchema{
+			"Image": {
+				Type:     "object",
+				Required: []string{"id", "url", "tags", "created_at", "starred"},
+				Properties: map[string]*openAPISchema{
+					"id":         {Type: "integer"},
+					"url":        {Type: "string", Description: "图片地址，本地图片以 /local/ 开头"},
+					"tags":       {Type: "array", Items: str},
+					"blurhash":   {Type: "string"},
+					"color":      {Type: "string", Description: "主色调，#rrggbb"},
+					"width":      {Type: "integer"},
+					"height":     {Type: "integer"},
+					"bytes":      {Type: "integer", Format: "int64"},
+					"source":     {Type: "string"},
+					"author":     {Type: "string"},
+					"created_at": {Type: "string", Format: "date-time"},
+					"starred":    {Type: "boolean"},
+				},
+			},
+			"TagCount": {
+				Type:     "object",
+				Required: []string{"tag", "count"},
+				Properties: map[string]*openAPISchema{
+					"tag":   {Type: "string"},
+					"count": {Type: "integer"},
+				},
+			},
+		}},
+	}
+}()
+
+// openAPIHandler 输出描述公开 JSON 接口的 OpenAPI 3 文档，供生成类型化客户端使用
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(openAPIDocument)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// registerRoutes 在默认 ServeMux 上注册一次全部路由，重复注册会 panic
+var registerRoutes = sync.OnceValue(setupRoutes)
+
+func TestOpenAPIDocumentMatchesRoutes(t *testing.T) {
+	registerRoutes()
+	for p, item := range openAPIDocument.Paths {
+		if item.Get == nil {
+			t.Errorf("%s 没有描述任何操作", p)
+			continue
+		}
+		_, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, p, nil))
+		if _, route, ok := strings.Cut(pattern, " "); ok {
+			pattern = route
+		}
+		if pattern != p {
+			t.Errorf("文档中的 %s 没有对应的路由（匹配到 %q）", p, pattern)
+		}
+		if _, ok := item.Get.Responses["200"]; !ok {
+			t.Errorf("%s 缺少 200 响应", p)
+		}
+	}
+}
+
+func TestOpenAPIDocumentRefs(t *testing.T) {
+	data, err := json.Marshal(openAPIDocument)
+	if err != nil {
+		t.Fatalf("无法序列化 OpenAPI 文档: %v", err)
+	}
+	var doc openAPIDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" || len(doc.Paths) != len(openAPIDocument.Paths) {
+		t.Errorf("序列化后的文档不完整: openapi = %q, %d 个路径", doc.OpenAPI, len(doc.Paths))
+	}
+
+	// 所有 $ref 都指向 components/schemas 中存在的结构
+	var check func(where string, s *openAPISchema)
+	check = func(where string, s *openAPISchema) {
+		if s == nil {
+			return
+		}
+		if s.Ref != "" {
+			name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+			if _, exists := doc.Components.Schemas[name]; !ok || !exists {
+				t.Errorf("%s: 无效的引用 %s", where, s.Ref)
+			}
+		}
+		check(where, s.Items)
+		for _, prop := range s.Properties {
+			check(where, prop)
+		}
+	}
+	opIDs := make(map[string]string)
+	for p, item := range doc.Paths {
+		op := item.Get
+		if prev, dup := opIDs[op.OperationID]; dup {
+			t.Errorf("operationId %s 同时用于 %s 和 %s", op.OperationID, prev, p)
+		}
+		opIDs[op.OperationID] = p
+		for _, param := range op.Parameters {
+			check(p+" 参数 "+param.Name, param.Schema)
+		}
+		for code, resp := range op.Responses {
+			for _, media := range resp.Content {
+				check(p+" "+code, media.Schema)
+			}
+		}
+	}
+	for name, s := range doc.Components.Schemas {
+		check("components "+name, s)
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	openAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	var doc map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("响应不是有效的 JSON: %v", err)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// --- EXIF 方向校正 ---
+
+// jpegOrientation 读取 JPEG 数据中的 EXIF 方向标签（1-8），没有 EXIF 或标签无效时返回 1（正常方向）
+func jpegOrientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	o, err := tag.Int(0)
+	if err != nil || o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+// applyOrientation 按 EXIF 方向值旋转/翻转图片，返回正向显示的新图片，方向为 1 时原样返回。
+// 5-8 会交换宽高
+func applyOrientation(src image.Image, orientation int) image.Image {
+	sr := src.Bounds()
+	w, h := float64(sr.Dx()), float64(sr.Dy())
+	// 以源图左上角为原点时的映射 (u, v) -> (a*u + b*v + tx, d*u + e*v + ty)
+	var a, b, tx, d, e, ty float64
+	switch orientation {
+	case 2: // 水平翻转
+		a, tx, e = -1, w, 1
+	case 3: // 旋转 180°
+		a, tx, e, ty = -1, w, -1, h
+	case 4: // 垂直翻转
+		a, e, ty = 1, -1, h
+	case 5: // 沿左上-右下对角线翻转
+		b, d = 1, 1
+	case 6: // 顺时针旋转 90°
+		b, tx, d = -1, h, 1
+	case 7: // 沿右上-左下对角线翻转
+		b, tx, d, ty = -1, h, -1, w
+	case 8: // 逆时针旋转 90°
+		b, d, ty = 1, -1, w
+	default:
+		return src
+	}
+
+	dw, dh := sr.Dx(), sr.Dy()
+	if orientation >= 5 {
+		dw, dh = dh, dw
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	minX, minY := float64(sr.Min.X), float64(sr.Min.Y)
+	s2d := f64.Aff3{
+		a, b, tx - a*minX - b*minY,
+		d, e, ty - d*minX - e*minY,
+	}
+	// 只做 90° 的倍数旋转和翻转，像素一一对应，用最近邻即可保持原样
+	draw.NearestNeighbor.Transform(dst, s2d, src, sr, draw.Src, nil)
+	return dst
+}
+
+// fixJPEGOrientation 检查本地文件是否为带有非正常方向标签的 JPEG，是则把像素转正后重新保存。
+// 重新编码不会写入 EXIF，方向标签也随之去掉。返回是否改写了文件，非 JPEG 和已经正向的图片不做改动
+func fixJPEGOrientation(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if detectImageType(data, "") != "image/jpeg" {
+		return false, nil
+	}
+	orientation := jpegOrientation(data)
+	if orientation == 1 {
+		return false, nil
+	}
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("无法解码 JPEG: %w", err)
+	}
+
+	// 先写临时文件再改名，避免写到一半失败时损坏原文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := jpeg.Encode(tmp, applyOrientation(src, orientation), &jpeg.Options{Quality: 95}); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
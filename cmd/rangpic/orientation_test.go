@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// orientationSource 返回 3x2 的测试图，每个像素的颜色编码了它在源图中的坐标
+func orientationSource(min image.Point) *image.RGBA {
+	img := image.NewRGBA(image.Rectangle{Min: min, Max: min.Add(image.Pt(3, 2))})
+	for v := 0; v < 2; v++ {
+		for u := 0; u < 3; u++ {
+			img.Set(min.X+u, min.Y+v, color.RGBA{R: uint8(u * 50), G: uint8(v * 50), A: 255})
+		}
+	}
+	return img
+}
+
+func TestApplyOrientation(t *testing.T) {
+	const w, h = 3, 2
+	// 每个方向值下源图 (u, v) 处的像素在正向图片中的位置
+	tests := []struct {
+		orientation int
+		dst         func(u, v int) (int, int)
+	}{
+		{1, func(u, v int) (int, int) { return u, v }},
+		{2, func(u, v int) (int, int) { return w - 1 - u, v }},
+		{3, func(u, v int) (int, int) { return w - 1 - u, h - 1 - v }},
+		{4, func(u, v int) (int, int) { return u, h - 1 - v }},
+		{5, func(u, v int) (int, int) { return v, u }},
+		{6, func(u, v int) (int, int) { return h - 1 - v, u }},
+		{7, func(u, v int) (int, int) { return h - 1 - v, w - 1 - u }},
+		{8, func(u, v int) (int, int) { return v, w - 1 - u }},
+	}
+	for _, min := range []image.Point{{0, 0}, {5, 7}} {
+		src := orientationSource(min)
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%d/min=%v", tt.orientation, min), func(t *testing.T) {
+				got := applyOrientation(src, tt.orientation)
+				b := got.Bounds()
+				wantW, wantH := w, h
+				if tt.orientation >= 5 {
+					wantW, wantH = h, w
+				}
+				if b.Dx() != wantW || b.Dy() != wantH {
+					t.Fatalf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), wantW, wantH)
+				}
+				for v := 0; v < h; v++ {
+					for u := 0; u < w; u++ {
+						x, y := tt.dst(u, v)
+						want := src.RGBAAt(min.X+u, min.Y+v)
+						if c := color.RGBAModel.Convert(got.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA); c != want {
+							t.Errorf("源像素 (%d,%d) 应位于 (%d,%d)，那里是 %v，want %v", u, v, x, y, c, want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// --- 随机图片预选池 ---
+
+// pickPool 为每种查询条件缓存一小批预先随机选出的图片，并按固定间隔刷新。
+// 同一时刻涌入的大量请求（例如一个页面上有很多 <img>）共享同一批候选，
+// 每个刷新周期每种条件只查询一次数据库；代价是在一个刷新周期内只会从这批候选中挑选，
+// 相邻请求拿到同一张图片的概率比逐请求 ORDER BY RANDOM() 更高。
+type pickPool struct {
+	size    int
+	refresh time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*pickPoolEntry
+}
+
+type pickPoolEntry struct {
+	mu        sync.Mutex
+	images    []Image
+	fetchedAt time.Time
+}
+
+// maxPickPoolEntries 限制缓存的查询条件数量，防止随意构造的标签参数撑大内存
+const maxPickPoolEntries = 1000
+
+// randomPool 为 nil 表示未启用预选池，每个请求直接查询数据库
+var randomPool *pickPool
+
+func newPickPool(size int, refresh time.Duration) *pickPool {
+	return &pickPool{size: size, refresh: refresh, entries: make(map[string]*pickPoolEntry)}
+}
+
+// pickRandomImage 是处理函数挑选随机图片的入口，启用预选池时从池中挑选（按种子选择和分类均衡的请求除外）。
+// 浏览次数在这里而不是 chooseRandomImage 中记录，这样经由预选池的请求也会计入，调试接口则不会
+func pickRandomImage(ctx context.Context, f imageFilter) (img Image, err error) {
+	if randomPool != nil && f.Seed == "" && !f.Balanced {
+		img, err = randomPool.pick(ctx, f)
+	} else {
+		img, err = chooseRandomImage(ctx, f)
+	}
+	if err == nil {
+		recordView(img.ID)
+	}
+	return img, err
+}
+
+func (p *pickPool) pick(ctx context.Context, f imageFilter) (Image, error) {
+	key := f.key()
+
+	p.mu.Lock()
+	entry, ok := p.entries[key]
+	if !ok {
+		if len(p.entries) >= maxPickPoolEntries {
+			p.entries = make(map[string]*pickPoolEntry)
+		}
+		entry = &pickPoolEntry{}
+		p.entries[key] = entry
+	}
+	p.mu.Unlock()
+
+	// 刷新期间持有 entry 的锁，同一条件下的并发请求等待这一次查询而不是各自查询
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if time.Since(entry.fetchedAt) >= p.refresh {
+		// 池按不含 AvoidID 的条件填充，由下面的挑选步骤跳过上一次返回的图片
+		base := f
+		base.AvoidID = 0
+		images, err := chooseRandomImages(ctx, base, p.size)
+		if err != nil {
+			return Image{}, err
+		}
+		// 结果已按宽度偏好排序，只保留最优的一档，保持与逐请求选择相同的偏好语义
+		if len(images) > 0 {
+			best := widthClass(images[0], f.MinWidth)
+			for i, img := range images {
+				if widthClass(img, f.MinWidth) != best {
+					images = images[:i]
+					break
+				}
+			}
+		}
+		entry.images = images
+		entry.fetchedAt = time.Now()
+	}
+
+	if len(entry.images) == 0 {
+		return Image{}, errNoImageFound
+	}
+	img := entry.images[rand.Intn(len(entry.images))]
+	if img.ID == f.AvoidID && len(entry.images) > 1 {
+		// 与上一次相同时改取其余候选中的一张；只有这一张候选时仍然返回它
+		i := rand.Intn(len(entry.images) - 1)
+		if entry.images[i].ID == f.AvoidID {
+			i = len(entry.images) - 1
+		}
+		img = entry.images[i]
+	}
+	return img, nil
+}
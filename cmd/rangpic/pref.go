@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// --- 访客标签偏好 ---
+
+// prefCookieName 是保存访客偏好标签的 cookie 名
+const prefCookieName = "rangpic_pref_tag"
+
+// prefCookieMaxAge 是偏好 cookie 的有效期
+const prefCookieMaxAge = 365 * 24 * time.Hour
+
+// maxPrefTagLength 是偏好标签的最大字符数
+const maxPrefTagLength = 64
+
+// prefSecret 是偏好 cookie 的签名密钥，来自 PREF_COOKIE_SECRET。未设置时启动时随机生成，
+// 重启后之前签发的 cookie 全部失效，访客需要重新选择
+var prefSecret []byte
+
+// initPrefSecret 读取或生成偏好 cookie 的签名密钥
+func initPrefSecret(v string) {
+	if v != "" {
+		prefSecret = []byte(v)
+		return
+	}
+	prefSecret = make([]byte, 32)
+	if _, err := rand.Read(prefSecret); err != nil {
+		fatal("生成偏好 cookie 密钥失败", "err", err)
+	}
+	slog.Info("未设置 PREF_COOKIE_SECRET，使用随机密钥，重启后访客的标签偏好会失效")
+}
+
+// signPref 返回 "标签.签名" 形式的 cookie 值，两部分都是 base64url 编码
+func signPref(tag string) string {
+	mac := hmac.New(sha256.New, prefSecret)
+	mac.Write([]byte(tag))
+	return base64.RawURLEncoding.EncodeToString([]byte(tag)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyPref 校验 cookie 值的签名，有效时返回其中的标签
+func verifyPref(value string) (string, bool) {
+	encTag, encSig, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(encTag)
+	if err != nil {
+		return "", false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, prefSecret)
+	mac.Write(tag)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", false
+	}
+	return string(tag), true
+}
+
+// preferredTag 返回请求 cookie 中保存的偏好标签，没有 cookie 或签名无效时返回空字符串
+func preferredTag(r *http.Request) string {
+	cookie, err := r.Cookie(prefCookieName)
+	if err != nil {
+		return ""
+	}
+	tag, _ := verifyPref(cookie.Value)
+	return tag
+}
+
+// applyPreferredTag 在查询参数没有指定 tag/tags 时，把 cookie 中的偏好标签加入查询参数。
+// 此时响应取决于 cookie，因此加上 Vary: Cookie，避免共享缓存把一位访客的结果返回给其他人
+func applyPreferredTag(w http.ResponseWriter, r *http.Request, q url.Values) {
+	if q.Has("tag") || q.Has("tags") {
+		return
+	}
+	w.Header().Add("Vary", "Cookie")
+	if tag := preferredTag(r); tag != "" {
+		q.Set("tag", tag)
+	}
+}
+
+// sameOriginRequest 判断修改请求是否来自本站页面。浏览器提交时会带上 Origin（或 Referer），
+// 指向其他站点时说明是跨站伪造；两者都没有的请求来自脚本等非浏览器客户端，拿不到访客的 cookie，予以放行
+func sameOriginRequest(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	// 反向代理改写了 Host 时按 PUBLIC_BASE_URL 判断
+	public, err := url.Parse(publicBaseURL)
+	return publicBaseURL != "" && err == nil && strings.EqualFold(u.Host, public.Host)
+}
+
+// prefAPIHandler 读取或保存访客的偏好标签。GET 返回 {"tag": "..."}；POST 的 tag 参数非空时
+// 保存为签名 cookie，为空时清除。POST 只接受本站页面提交，防止其他网站替访客改写偏好
+func prefAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if !sameOriginRequest(r) {
+			requestLogger(r).Warn("拒绝跨站保存偏好标签", "origin", r.Header.Get("Origin"), "referer", r.Header.Get("Referer"))
+			http.Error(w, "请求来源无效", http.StatusForbidden)
+			return
+		}
+		tag := normalizeTag(r.FormValue("tag"))
+		if len([]rune(tag)) > maxPrefTagLength {
+			http.Error(w, "标签过长", http.StatusBadRequest)
+			return
+		}
+		cookie := &http.Cookie{
+			Name:     prefCookieName,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   cookieSecure,
+			SameSite: http.SameSiteLaxMode,
+		}
+		if tag == "" {
+			cookie.MaxAge = -1
+		} else {
+			cookie.Value = signPref(tag)
+			cookie.MaxAge = int(prefCookieMaxAge / time.Second)
+		}
+		http.SetCookie(w, cookie)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]string{"tag": tag})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	json.NewEncoder(w).Encode(map[string]string{"tag": preferredTag(r)})
+}
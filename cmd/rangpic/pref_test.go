@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestApplyPreferredTagOnlyWithoutExplicitTag(t *testing.T) {
+	defer func(s []byte) { prefSecret = s }(prefSecret)
+	initPrefSecret("test-secret")
+	valid := &http.Cookie{Name: prefCookieName, Value: signPref("cats")}
+	forged := &http.Cookie{Name: prefCookieName, Value: signPref("cats") + "x"}
+
+	tests := []struct {
+		name     string
+		query    string
+		cookie   *http.Cookie
+		wantTag  string
+		wantVary bool
+	}{
+		{"没有标签时使用偏好", "", valid, "cats", true},
+		{"显式 tag 优先", "tag=dogs", valid, "dogs", false},
+		{"显式 tags 优先", "tags=dogs,birds", valid, "", false},
+		{"签名无效的 cookie 被忽略", "", forged, "", true},
+		{"没有 cookie", "", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/random-image?"+tt.query, nil)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			rec := httptest.NewRecorder()
+			q := r.URL.Query()
+			applyPreferredTag(rec, r, q)
+			if got := q.Get("tag"); got != tt.wantTag {
+				t.Errorf("tag = %q, want %q", got, tt.wantTag)
+			}
+			if got := rec.Header().Get("Vary") == "Cookie"; got != tt.wantVary {
+				t.Errorf("Vary: Cookie = %v, want %v", got, tt.wantVary)
+			}
+		})
+	}
+}
+
+func TestPrefAPIRejectsCrossSitePost(t *testing.T) {
+	defer func(s []byte) { prefSecret = s }(prefSecret)
+	initPrefSecret("test-secret")
+	defer func(u string) { publicBaseURL = u }(publicBaseURL)
+	publicBaseURL = "https://pic.example.com"
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"同源 Origin", http.Header{"Origin": {"http://example.com"}}, http.StatusOK},
+		{"同源 Referer", http.Header{"Referer": {"http://example.com/"}}, http.StatusOK},
+		{"PUBLIC_BASE_URL", http.Header{"Origin": {"https://pic.example.com"}}, http.StatusOK},
+		{"非浏览器客户端", nil, http.StatusOK},
+		{"跨站 Origin", http.Header{"Origin": {"https://evil.example"}}, http.StatusForbidden},
+		{"跨站 Referer", http.Header{"Referer": {"https://evil.example/page"}}, http.StatusForbidden},
+		{"Origin 为 null", http.Header{"Origin": {"null"}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := postForm("/api/pref", url.Values{"tag": {"cats"}})
+			for k, vs := range tt.header {
+				r.Header[k] = vs
+			}
+			rec := httptest.NewRecorder()
+			prefAPIHandler(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if ct := rec.Header().Get("Content-Type"); tt.want == http.StatusOK && ct != "application/json; charset=utf-8" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			cookies := rec.Result().Cookies()
+			if tt.want == http.StatusOK && (len(cookies) != 1 || cookies[0].Value != signPref("cats")) {
+				t.Errorf("应设置偏好 cookie，got %v", cookies)
+			}
+			if tt.want != http.StatusOK && len(cookies) != 0 {
+				t.Errorf("被拒绝的请求不应设置 cookie，got %v", cookies)
+			}
+		})
+	}
+}
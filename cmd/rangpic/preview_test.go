@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestAdminPreview(t *testing.T) {
+	defer func(v bool, hosts []string) { allowPrivateDownload, allowedImageHosts = v, hosts }(allowPrivateDownload, allowedImageHosts)
+	png := testPNG(t, 2, 2, color.White)
+	srv := testImageServer(t, map[string][]byte{"/a.png": png, "/page": []byte("<html>hello</html>")})
+
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		hosts        []string
+		want         int
+	}{
+		{"内网地址", srv.URL + "/a.png", false, nil, http.StatusBadRequest},
+		{"元数据地址", "http://169.254.169.254/latest/meta-data", false, nil, http.StatusBadRequest},
+		{"不在白名单中", srv.URL + "/a.png", true, []string{"example.com"}, http.StatusBadRequest},
+		{"非 http 地址", "file:///etc/passwd", true, nil, http.StatusBadRequest},
+		{"缺少 url", "", true, nil, http.StatusBadRequest},
+		{"在白名单中", srv.URL + "/a.png", true, []string{"127.0.0.1"}, http.StatusOK},
+		{"不是图片", srv.URL + "/page", true, nil, http.StatusUnsupportedMediaType},
+		{"源站 404", srv.URL + "/missing.png", true, nil, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowPrivateDownload, allowedImageHosts = tt.allowPrivate, tt.hosts
+			rec := httptest.NewRecorder()
+			adminPreviewHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/preview?url="+url.QueryEscape(tt.url), nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d, body = %q", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want == http.StatusOK {
+				if !bytes.Equal(rec.Body.Bytes(), png) || rec.Header().Get("Content-Type") != "image/png" {
+					t.Errorf("预览内容或类型不正确: %q", rec.Header().Get("Content-Type"))
+				}
+				if rec.Header().Get("Cache-Control") != "private, no-store" {
+					t.Errorf("预览结果不应被缓存，Cache-Control = %q", rec.Header().Get("Cache-Control"))
+				}
+			}
+		})
+	}
+}
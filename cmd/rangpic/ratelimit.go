@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- 登录限流 ---
+
+// trustedProxyHops 是本服务前面可信反向代理的层数，来自 TRUST_PROXY，0 表示不信任 X-Forwarded-For
+var trustedProxyHops int
+
+// clientIP 返回请求方的 IP，默认取 RemoteAddr。设置了 trustedProxyHops 时从 X-Forwarded-For 的右侧数起：
+// 每层代理都把它看到的对端地址追加在末尾，最左侧的条目可以由客户端任意伪造，
+// 因此倒数第 trustedProxyHops 个条目才是最外层可信代理看到的客户端地址
+func clientIP(r *http.Request) string {
+	if trustedProxyHops > 0 {
+		var entries []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			for _, e := range strings.Split(v, ",") {
+				entries = append(entries, strings.TrimSpace(e))
+			}
+		}
+		if len(entries) > 0 {
+			// 条目少于代理层数时，请求没有经过全部代理，现有条目都由可信代理写入
+			ip := entries[max(0, len(entries)-trustedProxyHops)]
+			if net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginLimiter 记录每个 IP 最近的登录失败时间，窗口内失败次数达到上限后锁定一段时间
+type loginLimiter struct {
+	maxFailures int
+	window      time.Duration
+	lockout     time.Duration
+
+	mu        sync.Mutex
+	attempts  map[string]*loginAttempts
+	lastSweep time.Time
+}
+
+type loginAttempts struct {
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+var loginGuard = newLoginLimiter(5, time.Minute, time.Minute)
+
+func newLoginLimiter(maxFailures int, window, lockout time.Duration) *loginLimiter {
+	return &loginLimiter{maxFailures: maxFailures, window: window, lockout: lockout, attempts: make(map[string]*loginAttempts)}
+}
+
+// allow 判断该 IP 当前是否允许尝试登录，被锁定时返回剩余的锁定时间
+func (l *loginLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a, ok := l.attempts[ip]
+	if !ok {
+		return true, 0
+	}
+	if now.Before(a.lockedUntil) {
+		return false, a.lockedUntil.Sub(now)
+	}
+	return true, 0
+}
+
+// fail 记录一次登录失败，窗口内失败次数达到上限时开始锁定
+func (l *loginLimiter) fail(ip string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	a, ok := l.attempts[ip]
+	if !ok {
+		a = &loginAttempts{}
+		l.attempts[ip] = a
+	}
+	a.failures = append(pruneBefore(a.failures, now.Add(-l.window)), now)
+	if len(a.failures) >= l.maxFailures {
+		a.lockedUntil = now.Add(l.lockout)
+		a.failures = nil
+	}
+}
+
+// reset 在登录成功后清除该 IP 的失败记录
+func (l *loginLimiter) reset(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, ip)
+}
+
+// sweep 每个窗口最多执行一次，删除已无近期失败且未被锁定的 IP，防止记录无限增长
+func (l *loginLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	for ip, a := range l.attempts {
+		a.failures = pruneBefore(a.failures, now.Add(-l.window))
+		if len(a.failures) == 0 && !now.Before(a.lockedUntil) {
+			delete(l.attempts, ip)
+		}
+	}
+}
+
+// pruneBefore 去掉早于 cutoff 的时间点，times 按时间顺序排列
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// retryAfterSeconds 把剩余等待时间向上取整为 Retry-After 头需要的秒数
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// --- 公开接口限流 ---
+
+// publicLimiter 来自 PUBLIC_RATE_LIMIT（每个 IP 每分钟的请求数），为 nil 时不限流
+var publicLimiter *tokenBucketLimiter
+
+// tokenBucketLimiter 为每个 IP 维护一个令牌桶：桶容量为 burst，按 rate 持续补充，
+// 每个请求消耗一个令牌，桶空时拒绝
+type tokenBucketLimiter struct {
+	rate  float64 // 每秒补充的令牌数
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucketLimiter 创建每分钟补充 perMinute 个令牌、容量为 burst 的限流器
+func newTokenBucketLimiter(perMinute, burst int) *tokenBucketLimiter {
+	return &tokenBucketLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(max(1, burst)),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow 为 key 消耗一个令牌，没有令牌时返回 false 以及等到下一个令牌所需的时间
+func (l *tokenBucketLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep 每分钟最多执行一次，删除已经补满的令牌桶，防止记录无限增长；补满的桶与新建的桶没有区别
+func (l *tokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitMiddleware 按客户端 IP 限制请求频率，超过时返回 429 并在 Retry-After 中给出需要等待的秒数。
+// 只用于公开接口，后台路由不使用；limiter 为 nil 时不限流
+func rateLimitMiddleware(limiter *tokenBucketLimiter, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := limiter.allow(clientIP(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			http.Error(w, "请求过于频繁，请稍后重试", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(n int) { trustedProxyHops = n }(trustedProxyHops)
+	tests := []struct {
+		hops int
+		xff  []string
+		want string
+	}{
+		{0, []string{"1.1.1.1"}, "192.0.2.1"},
+		{1, nil, "192.0.2.1"},
+		{1, []string{"203.0.113.7"}, "203.0.113.7"},
+		// 客户端伪造的最左侧条目被忽略
+		{1, []string{"6.6.6.6, 203.0.113.7"}, "203.0.113.7"},
+		{1, []string{"6.6.6.6", "203.0.113.7"}, "203.0.113.7"},
+		{2, []string{"6.6.6.6, 203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{2, []string{"203.0.113.7"}, "203.0.113.7"},
+		{1, []string{"not-an-ip"}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		trustedProxyHops = tt.hops
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:5678"
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("hops=%d xff=%q: got %s, want %s", tt.hops, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLimiterLocksSixthAttempt(t *testing.T) {
+	l := newLoginLimiter(5, time.Minute, time.Minute)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.allow("1.2.3.4", now); !ok {
+			t.Fatalf("第 %d 次尝试不应被锁定", i+1)
+		}
+		l.fail("1.2.3.4", now)
+		now = now.Add(time.Second)
+	}
+	ok, wait := l.allow("1.2.3.4", now)
+	if ok || wait <= 0 {
+		t.Fatalf("连续失败 5 次后第 6 次尝试应被锁定，got ok=%v wait=%v", ok, wait)
+	}
+	if ok, _ := l.allow("5.6.7.8", now); !ok {
+		t.Error("其他 IP 不应受影响")
+	}
+	if ok, _ := l.allow("1.2.3.4", now.Add(time.Minute)); !ok {
+		t.Error("锁定时间过后应允许再次尝试")
+	}
+}
+
+func TestLoginLimiterResetAndWindow(t *testing.T) {
+	l := newLoginLimiter(5, time.Minute, time.Minute)
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		l.fail("1.2.3.4", now)
+	}
+	l.reset("1.2.3.4")
+	l.fail("1.2.3.4", now)
+	if ok, _ := l.allow("1.2.3.4", now); !ok {
+		t.Error("登录成功后失败计数应清零")
+	}
+
+	// 窗口之外的失败不计入
+	for i := 0; i < 4; i++ {
+		l.fail("5.6.7.8", now)
+	}
+	later := now.Add(2 * time.Minute)
+	l.fail("5.6.7.8", later)
+	if ok, _ := l.allow("5.6.7.8", later); !ok {
+		t.Error("一分钟之前的失败不应计入")
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	l := newTokenBucketLimiter(60, 10)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow("1.2.3.4", now); !ok {
+			t.Fatalf("突发的第 %d 个请求不应被拒绝", i+1)
+		}
+	}
+	ok, wait := l.allow("1.2.3.4", now)
+	if ok || wait != time.Second {
+		t.Fatalf("桶空后应拒绝并等待 1s，got ok=%v wait=%v", ok, wait)
+	}
+	if ok, _ := l.allow("5.6.7.8", now); !ok {
+		t.Error("其他 IP 不应受影响")
+	}
+
+	// 长时间空闲后令牌最多补到 burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow("1.2.3.4", later); !ok {
+			t.Fatalf("补满后的第 %d 个请求不应被拒绝", i+1)
+		}
+	}
+	if ok, _ := l.allow("1.2.3.4", later); ok {
+		t.Error("令牌不应超过 burst")
+	}
+}
+
+func TestTokenBucketSteadyState(t *testing.T) {
+	l := newTokenBucketLimiter(60, 5)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		l.allow("1.2.3.4", now)
+	}
+	// 桶空后每 250ms 请求一次，持续一分钟，只有按速率补充的 60 个通过
+	allowed := 0
+	for i := 0; i < 240; i++ {
+		now = now.Add(250 * time.Millisecond)
+		if ok, _ := l.allow("1.2.3.4", now); ok {
+			allowed++
+		}
+	}
+	if allowed != 60 {
+		t.Errorf("稳定状态下一分钟通过 %d 个请求，期望 60", allowed)
+	}
+
+	// 不超过速率的客户端不会被拒绝
+	l = newTokenBucketLimiter(60, 1)
+	for i := 0; i < 120; i++ {
+		now = now.Add(time.Second)
+		if ok, wait := l.allow("5.6.7.8", now); !ok {
+			t.Fatalf("每秒一次的第 %d 个请求被拒绝，wait=%v", i+1, wait)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	h := rateLimitMiddleware(newTokenBucketLimiter(60, 2), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	codes := make([]int, 3)
+	var rec *httptest.ResponseRecorder
+	for i := range codes {
+		rec = httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/random-image", nil)
+		r.RemoteAddr = "192.0.2.1:5678"
+		h.ServeHTTP(rec, r)
+		codes[i] = rec.Code
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("状态码 = %v，期望 [200 200 429]", codes)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 响应缺少 Retry-After")
+	}
+}
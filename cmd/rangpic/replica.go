@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// --- 只读副本 ---
+
+var (
+	// replicaPool 为 nil 表示未配置只读副本，所有读查询都走主库
+	replicaPool *pgxpool.Pool
+	// replicaFallback 控制副本出现连接或超时错误时是否自动在主库上重试
+	replicaFallback = true
+)
+
+// querier 是 readQuery 用到的连接池方法，*pgxpool.Pool 满足该接口
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// shouldFallbackToPrimary 判断副本上的错误是否值得在主库重试：连接失败、连接中断、超时、
+// 副本关闭或与恢复冲突时重试；无结果、SQL 错误以及其他无法确认是连接问题的错误直接返回给调用方。
+// 请求本身已被取消或超时时也不重试。
+func shouldFallbackToPrimary(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(err, pgx.ErrNoRows) || ctx.Err() != nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P") || pgErr.Code == "40001"
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// readQuery 在副本上执行只读查询，必要时退回主库
+func readQuery(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if replicaPool == nil {
+		return dbpool.Query(ctx, sql, args...)
+	}
+	return queryWithFallback(ctx, replicaPool, dbpool, sql, args...)
+}
+
+// queryWithFallback 在 replica 上执行查询，出现 shouldFallbackToPrimary 认可的错误时改在 primary 上重试。
+// pgx 的部分错误要到读取结果时才出现在 rows.Err() 中，因此返回的 rows 在读到第一行之前出错也会回退
+func queryWithFallback(ctx context.Context, replica, primary querier, sql string, args ...interface{}) (pgx.Rows, error) {
+	rows, err := replica.Query(ctx, sql, args...)
+	if !replicaFallback {
+		return rows, err
+	}
+	if shouldFallbackToPrimary(ctx, err) {
+		slog.Warn("只读副本查询失败，改用主库重试", "err", err)
+		return primary.Query(ctx, sql, args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &fallbackRows{Rows: rows, ctx: ctx, primary: primary, sql: sql, args: args}, nil
+}
+
+// fallbackRows 包装副本返回的结果集：第一次 Next 就因连接错误失败时关闭副本结果，改读主库的结果。
+// 已经读到过数据后再出错则不重试，避免调用方收到重复的行
+type fallbackRows struct {
+	pgx.Rows
+	ctx     context.Context
+	primary querier
+	sql     string
+	args    []interface{}
+	started bool
+	// err 是主库重试本身失败的错误，此时副本的结果集已关闭
+	err error
+}
+
+func (r *fallbackRows) Next() bool {
+	if r.Rows.Next() {
+		r.started = true
+		return true
+	}
+	if r.started {
+		return false
+	}
+	r.started = true
+	err := r.Rows.Err()
+	if !shouldFallbackToPrimary(r.ctx, err) {
+		return false
+	}
+	slog.Warn("只读副本读取结果失败，改用主库重试", "err", err)
+	r.Rows.Close()
+	rows, err := r.primary.Query(r.ctx, r.sql, r.args...)
+	if err != nil {
+		r.err = err
+		return false
+	}
+	r.Rows = rows
+	return rows.Next()
+}
+
+func (r *fallbackRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.Rows.Err()
+}
+
+// readQueryRow 与 readQuery 相同，但返回单行结果；错误在 Scan 时才会出现，因此回退也在 Scan 中进行
+func readQueryRow(ctx context.Context, sql string, args ...interface{}) rowScanner {
+	if replicaPool == nil {
+		return dbpool.QueryRow(ctx, sql, args...)
+	}
+	return fallbackRow{ctx: ctx, replica: replicaPool, primary: dbpool, sql: sql, args: args}
+}
+
+type fallbackRow struct {
+	ctx              context.Context
+	replica, primary querier
+	sql              string
+	args             []interface{}
+}
+
+func (r fallbackRow) Scan(dest ...interface{}) error {
+	err := r.replica.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	if replicaFallback && shouldFallbackToPrimary(r.ctx, err) {
+		slog.Warn("只读副本查询失败，改用主库重试", "err", err)
+		return r.primary.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// fakeRows 依次返回 values 中的整数，读完后 Err 返回 err
+type fakeRows struct {
+	pgx.Rows
+	values []int
+	err    error
+	pos    int
+	closed bool
+}
+
+func (r *fakeRows) Next() bool {
+	if r.pos < len(r.values) {
+		r.pos++
+		return true
+	}
+	return false
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	*dest[0].(*int) = r.values[r.pos-1]
+	return nil
+}
+
+func (r *fakeRows) Err() error { return r.err }
+func (r *fakeRows) Close()     { r.closed = true }
+
+type fakeRow struct {
+	value int
+	err   error
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int) = r.value
+	return nil
+}
+
+// fakeQuerier 模拟一个连接池：queryErr 非空时 Query 直接失败，否则返回 rows
+type fakeQuerier struct {
+	rows     *fakeRows
+	queryErr error
+	row      fakeRow
+	queries  int
+}
+
+func (q *fakeQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	q.queries++
+	if q.queryErr != nil {
+		return nil, q.queryErr
+	}
+	return q.rows, nil
+}
+
+func (q *fakeQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	q.queries++
+	return q.row
+}
+
+func readAll(t *testing.T, rows pgx.Rows) ([]int, error) {
+	t.Helper()
+	defer rows.Close()
+	var got []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	return got, rows.Err()
+}
+
+var (
+	connErr  = &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	sqlErr   = &pgconn.PgError{Code: "42P01", Message: "relation does not exist"}
+	shutdown = &pgconn.PgError{Code: "57P01", Message: "terminating connection due to administrator command"}
+)
+
+func TestShouldFallbackToPrimary(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"nil", context.Background(), nil, false},
+		{"no rows", context.Background(), pgx.ErrNoRows, false},
+		{"sql error", context.Background(), sqlErr, false},
+		{"admin shutdown", context.Background(), shutdown, true},
+		{"serialization failure", context.Background(), &pgconn.PgError{Code: "40001"}, true},
+		{"connection exception", context.Background(), &pgconn.PgError{Code: "08006"}, true},
+		{"network error", context.Background(), connErr, true},
+		{"unexpected eof", context.Background(), io.ErrUnexpectedEOF, true},
+		{"scan error", context.Background(), errors.New("can't scan into dest[0]"), false},
+		{"request canceled", canceled, connErr, false},
+	}
+	for _, tt := range tests {
+		if got := shouldFallbackToPrimary(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueryWithFallback(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("query error", func(t *testing.T) {
+		replica := &fakeQuerier{queryErr: connErr}
+		primary := &fakeQuerier{rows: &fakeRows{values: []int{1, 2}}}
+		rows, err := queryWithFallback(ctx, replica, primary, "SELECT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := readAll(t, rows); err != nil || len(got) != 2 || primary.queries != 1 {
+			t.Errorf("连接错误应改读主库，got %v, %v, primary queries %d", got, err, primary.queries)
+		}
+	})
+
+	t.Run("sql error", func(t *testing.T) {
+		replica := &fakeQuerier{queryErr: sqlErr}
+		primary := &fakeQuerier{rows: &fakeRows{values: []int{1}}}
+		if _, err := queryWithFallback(ctx, replica, primary, "SELECT"); !errors.Is(err, sqlErr) || primary.queries != 0 {
+			t.Errorf("SQL 错误不应回退，got %v, primary queries %d", err, primary.queries)
+		}
+	})
+
+	t.Run("error before first row", func(t *testing.T) {
+		replicaRows := &fakeRows{err: shutdown}
+		replica := &fakeQuerier{rows: replicaRows}
+		primary := &fakeQuerier{rows: &fakeRows{values: []int{7}}}
+		rows, err := queryWithFallback(ctx, replica, primary, "SELECT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readAll(t, rows)
+		if err != nil || len(got) != 1 || got[0] != 7 {
+			t.Errorf("读取第一行前的连接错误应改读主库，got %v, %v", got, err)
+		}
+		if !replicaRows.closed {
+			t.Error("回退前应关闭副本的结果集")
+		}
+	})
+
+	t.Run("error after rows", func(t *testing.T) {
+		replica := &fakeQuerier{rows: &fakeRows{values: []int{1}, err: connErr}}
+		primary := &fakeQuerier{rows: &fakeRows{values: []int{1, 2}}}
+		rows, _ := queryWithFallback(ctx, replica, primary, "SELECT")
+		got, err := readAll(t, rows)
+		if !errors.Is(err, connErr) || len(got) != 1 || primary.queries != 0 {
+			t.Errorf("已读到数据后出错不应回退，got %v, %v, primary queries %d", got, err, primary.queries)
+		}
+	})
+
+	t.Run("primary also fails", func(t *testing.T) {
+		replica := &fakeQuerier{rows: &fakeRows{err: connErr}}
+		primaryErr := errors.New("primary down")
+		primary := &fakeQuerier{queryErr: primaryErr}
+		rows, _ := queryWithFallback(ctx, replica, primary, "SELECT")
+		if _, err := readAll(t, rows); !errors.Is(err, primaryErr) {
+			t.Errorf("主库重试失败时应返回主库的错误，got %v", err)
+		}
+	})
+
+	t.Run("fallback disabled", func(t *testing.T) {
+		defer func() { replicaFallback = true }()
+		replicaFallback = false
+		replica := &fakeQuerier{queryErr: connErr}
+		primary := &fakeQuerier{rows: &fakeRows{}}
+		if _, err := queryWithFallback(ctx, replica, primary, "SELECT"); !errors.Is(err, connErr) || primary.queries != 0 {
+			t.Errorf("关闭回退后应直接返回副本错误，got %v", err)
+		}
+	})
+}
+
+func TestFallbackRowScan(t *testing.T) {
+	ctx := context.Background()
+	var v int
+
+	row := fallbackRow{ctx: ctx, replica: &fakeQuerier{row: fakeRow{err: shutdown}}, primary: &fakeQuerier{row: fakeRow{value: 3}}}
+	if err := row.Scan(&v); err != nil || v != 3 {
+		t.Errorf("副本关闭时应改读主库，got %d, %v", v, err)
+	}
+
+	primary := &fakeQuerier{row: fakeRow{value: 3}}
+	row = fallbackRow{ctx: ctx, replica: &fakeQuerier{row: fakeRow{err: pgx.ErrNoRows}}, primary: primary}
+	if err := row.Scan(&v); !errors.Is(err, pgx.ErrNoRows) || primary.queries != 0 {
+		t.Errorf("无结果不应回退，got %v", err)
+	}
+}
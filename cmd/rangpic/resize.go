@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// --- 按需缩放与格式转换 ---
+
+// maxResizeDimension 是 w/h 参数允许的最大值，超出时按最大值处理，防止请求生成超大图片
+const maxResizeDimension = 4096
+
+// resizedImageCache 缓存缩放后的图片，键为 URL 加目标尺寸；为 nil 表示不缓存
+var resizedImageCache *imageCache
+
+// variantSpec 描述客户端请求的图片变体，零值表示返回原图
+type variantSpec struct {
+	Width  int
+	Height int
+	WebP   bool
+	// AcceptWebP 表示未指定 format 且客户端的 Accept 头接受 WebP，由 negotiate 决定是否采用
+	AcceptWebP bool
+}
+
+func (s variantSpec) active() bool {
+	return s.Width > 0 || s.Height > 0 || s.WebP
+}
+
+// negotiate 只在需要缩放时按 Accept 头顺带转为 WebP。只请求原图时不协商：否则浏览器的请求几乎都带
+// image/webp，每张图片都要解码重编码，mode=redirect 也会因此失效
+func (s variantSpec) negotiate() variantSpec {
+	if s.AcceptWebP && (s.Width > 0 || s.Height > 0) {
+		s.WebP = true
+	}
+	return s
+}
+
+func (s variantSpec) key(imgURL string) string {
+	return fmt.Sprintf("%s|w=%d|h=%d|webp=%t", imgURL, s.Width, s.Height, s.WebP)
+}
+
+// parseVariantSpec 解析 w、h 和 format 参数，超过 maxResizeDimension 的尺寸会被截断。
+// format=webp 强制输出 WebP，format=original 保持原格式，未指定时记录 Accept 头，缩放时再协商
+func parseVariantSpec(r *http.Request) (variantSpec, error) {
+	q := r.URL.Query()
+	var s variantSpec
+	switch q.Get("format") {
+	case "webp":
+		s.WebP = true
+	case "original":
+	case "":
+		s.AcceptWebP = webpSupported && strings.Contains(r.Header.Get("Accept"), "image/webp")
+	default:
+		return variantSpec{}, errors.New("format 参数只能是 webp 或 original")
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"w", &s.Width}, {"h", &s.Height}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return variantSpec{}, fmt.Errorf("%s 参数必须是非负整数", p.name)
+		}
+		*p.dst = min(n, maxResizeDimension)
+	}
+	return s, nil
+}
+
+// sizePreset 是某个标签的默认缩放尺寸，某一边为 0 表示不限制该边
+type sizePreset struct {
+	Width  int `json:"w"`
+	Height int `json:"h"`
+}
+
+// sizePresets 来自 TAG_SIZE_PRESETS，键为规范化后的标签；为空时不使用默认尺寸
+var sizePresets map[string]sizePreset
+
+// parseSizePresets 解析 TAG_SIZE_PRESETS，格式为 {"mobile":{"w":1080,"h":1920},"desktop":{"w":1920,"h":1080}}
+func parseSizePresets(v string) (map[string]sizePreset, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var raw map[string]sizePreset
+	if err := json.Unmarshal([]byte(v), &raw); err != nil {
+		return nil, fmt.Errorf("TAG_SIZE_PRESETS 必须是 JSON 对象: %w", err)
+	}
+	presets := make(map[string]sizePreset, len(raw))
+	for tag, p := range raw {
+		if p.Width < 0 || p.Height < 0 || p.Width > maxResizeDimension || p.Height > maxResizeDimension || p.Width+p.Height == 0 {
+			return nil, fmt.Errorf("标签 %q 的尺寸必须在 0-%d 之间且不能都为 0", tag, maxResizeDimension)
+		}
+		presets[normalizeTag(tag)] = p
+	}
+	return presets, nil
+}
+
+// applySizePreset 在请求没有指定 w 和 h 时，按第一个配置了默认尺寸的查询标签设置缩放尺寸；
+// 指定了 w 或 h 时原样返回，显式参数总是优先
+func applySizePreset(spec variantSpec, tags []string, q url.Values) variantSpec {
+	if q.Get("w") != "" || q.Get("h") != "" {
+		return spec
+	}
+	for _, tag := range tags {
+		if p, ok := sizePresets[tag]; ok {
+			spec.Width, spec.Height = p.Width, p.Height
+			return spec
+		}
+	}
+	return spec
+}
+
+// fitScale 返回把 width×height 等比缩小到不超过 w×h 的缩放比例，某一边为 0 表示不限制该边，不会大于 1
+func fitScale(width, height, w, h int) float64 {
+	scale := 1.0
+	if w > 0 && width > w {
+		scale = float64(w) / float64(width)
+	}
+	if h > 0 && float64(height)*scale > float64(h) {
+		scale = float64(h) / float64(height)
+	}
+	return scale
+}
+
+// fitWithin 将图片等比缩小到不超过 w×h，某一边为 0 表示不限制该边；不会放大图片
+func fitWithin(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	scale := fitScale(b.Dx(), b.Dy(), w, h)
+	if scale >= 1 {
+		return src
+	}
+	dw := max(1, int(float64(b.Dx())*scale))
+	dh := max(1, int(float64(b.Dy())*scale))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+// serveVariant 读取源图，按 spec 缩放并转换格式后返回。源图无法读取时返回 false，
+// 由调用方按原来的方式处理；源图无法解码或 WebP 编码失败时退回原格式。
+func serveVariant(w http.ResponseWriter, r *http.Request, imgURL string, spec variantSpec) bool {
+	key := spec.key(imgURL)
+	if resizedImageCache != nil {
+		if contentType, data, ok := resizedImageCache.Get(key); ok {
+			w.Header().Set("X-Cache", "HIT")
+			serveBytes(w, r, contentType, data)
+			return true
+		}
+	}
+
+	data, err := fetchImageBytes(r.Context(), imgURL)
+	if err != nil {
+		requestLogger(r).Warn("读取待缩放图片失败", "url", imgURL, "err", err)
+		return false
+	}
+	srcType := http.DetectContentType(data)
+	// 动图解码后只剩第一帧，单独处理：不缩放时原样返回（不转换为 WebP），缩放时逐帧缩放
+	if srcType == "image/gif" {
+		if out, ok := animatedGIFVariant(data, spec); ok {
+			if resizedImageCache != nil {
+				w.Header().Set("X-Cache", "MISS")
+				resizedImageCache.Put(key, srcType, out)
+			}
+			serveBytes(w, r, srcType, out)
+			return true
+		}
+	}
+	src, err := decodeImage(data)
+	if err != nil {
+		requestLogger(r).Info("无法解码图片，返回原图", "url", imgURL, "err", err)
+		serveBytes(w, r, srcType, data)
+		return true
+	}
+
+	contentType, out := encodeVariant(src, srcType, data, spec)
+	if out == nil {
+		requestLogger(r).Warn("编码图片失败，返回原图", "url", imgURL)
+		serveBytes(w, r, srcType, data)
+		return true
+	}
+	if resizedImageCache != nil {
+		w.Header().Set("X-Cache", "MISS")
+		resizedImageCache.Put(key, contentType, out)
+	}
+	serveBytes(w, r, contentType, out)
+	return true
+}
+
+// encodeVariant 对解码后的源图执行缩放和格式转换。需要 WebP 时优先编码为 WebP，失败则退回：
+// 图片未被缩放时直接返回源图字节，否则编码为 JPEG。全部失败时返回 nil
+func encodeVariant(src image.Image, srcType string, data []byte, spec variantSpec) (string, []byte) {
+	dst := fitWithin(src, spec.Width, spec.Height)
+	resized := dst != src
+	if spec.WebP {
+		if srcType == "image/webp" && !resized {
+			return srcType, data
+		}
+		out, err := encodeWebP(dst)
+		if err == nil {
+			return "image/webp", out
+		}
+		slog.Warn("WebP 编码失败，退回原格式", "err", err)
+	}
+	if !resized {
+		return srcType, data
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		slog.Error("JPEG 编码失败", "err", err)
+		return "", nil
+	}
+	return "image/jpeg", buf.Bytes()
+}
+
+// animatedGIFVariant 处理多帧 GIF：没有请求缩放或无需缩小时返回原始字节，否则逐帧缩放后重新编码，
+// 编码失败时同样返回原图。不是动图（单帧或无法解码）时返回 false，按普通图片处理
+func animatedGIFVariant(data []byte, spec variantSpec) ([]byte, bool) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(g.Image) < 2 {
+		return nil, false
+	}
+	resized := resizeGIF(g, spec.Width, spec.Height)
+	if resized == g {
+		return data, true
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, resized); err != nil {
+		slog.Warn("GIF 编码失败，返回原图", "err", err)
+		return data, true
+	}
+	return buf.Bytes(), true
+}
+
+// resizeGIF 把动图的每一帧按同一比例缩小到画布不超过 w×h，保留帧间隔、处置方式和循环次数。
+// 帧可能只覆盖画布的一部分，位置和大小按比例换算；用最近邻缩放保证像素仍在原调色板中。
+// 无需缩小时返回 g 本身
+func resizeGIF(g *gif.GIF, w, h int) *gif.GIF {
+	width, height := g.Config.Width, g.Config.Height
+	if width == 0 || height == 0 {
+		b := g.Image[0].Bounds()
+		width, height = b.Max.X, b.Max.Y
+	}
+	scale := fitScale(width, height, w, h)
+	if scale >= 1 {
+		return g
+	}
+	scaled := func(v int) int { return int(float64(v) * scale) }
+
+	out := *g
+	out.Config.Width = max(1, scaled(width))
+	out.Config.Height = max(1, scaled(height))
+	out.Image = make([]*image.Paletted, len(g.Image))
+	for i, frame := range g.Image {
+		b := frame.Bounds()
+		r := image.Rect(scaled(b.Min.X), scaled(b.Min.Y), scaled(b.Max.X), scaled(b.Max.Y))
+		r.Max.X = min(max(r.Max.X, r.Min.X+1), out.Config.Width)
+		r.Max.Y = min(max(r.Max.Y, r.Min.Y+1), out.Config.Height)
+		r.Min.X = min(r.Min.X, r.Max.X-1)
+		r.Min.Y = min(r.Min.Y, r.Max.Y-1)
+		dst := image.NewPaletted(r, frame.Palette)
+		draw.NearestNeighbor.Scale(dst, r, frame, b, draw.Src, nil)
+		out.Image[i] = dst
+	}
+	return &out
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectModeIgnoresAcceptWebP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/random-image?mode=redirect", nil)
+	req.Header.Set("Accept", "image/avif,image/webp,*/*")
+	opts, err := parseImageServeOptions(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.CacheControl = revalidateCacheControl
+	rec := httptest.NewRecorder()
+	serveImageBytes(rec, req, Image{ID: 1, URL: "https://img.example.com/a.jpg"}, opts)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://img.example.com/a.jpg" {
+		t.Errorf("mode=redirect 且只带 Accept: image/webp 时应直接跳转，got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec.Header().Get("Vary") != "" {
+		t.Errorf("不缩放时响应与 Accept 无关，不应带 Vary，got %q", rec.Header().Get("Vary"))
+	}
+}
+
+func TestVariantNegotiation(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		webp   bool
+	}{
+		{"", "image/webp", false},
+		{"w=100", "image/webp", webpSupported},
+		{"w=100", "image/png", false},
+		{"h=100&format=original", "image/webp", false},
+		{"format=webp", "", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/random-image?"+tt.query, nil)
+		req.Header.Set("Accept", tt.accept)
+		spec, err := parseVariantSpec(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if got := spec.negotiate().WebP; got != tt.webp {
+			t.Errorf("%q Accept=%q: WebP = %v, want %v", tt.query, tt.accept, got, tt.webp)
+		}
+	}
+
+	// 命中标签默认尺寸后同样按 Accept 协商
+	spec := variantSpec{AcceptWebP: true}
+	if spec.negotiate().active() {
+		t.Error("不缩放时不应仅因 Accept 头而处理图片")
+	}
+	spec.Width = 1080
+	if !spec.negotiate().WebP {
+		t.Error("按默认尺寸缩放时应按 Accept 头输出 WebP")
+	}
+}
+
+// testAnimatedGIF 返回 w×h、两帧的动图，第二帧只覆盖画布的右下四分之一
+func testAnimatedGIF(t *testing.T, w, h int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	first := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+	second := image.NewPaletted(image.Rect(w/2, h/2, w, h), palette)
+	for i := range second.Pix {
+		second.Pix[i] = 1
+	}
+	g := &gif.GIF{
+		Image:     []*image.Paletted{first, second},
+		Delay:     []int{10, 20},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground},
+		LoopCount: 3,
+		Config:    image.Config{Width: w, Height: h},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnimatedGIFVariant(t *testing.T) {
+	data := testAnimatedGIF(t, 40, 20)
+
+	out, ok := animatedGIFVariant(data, variantSpec{Width: 100})
+	if !ok || !bytes.Equal(out, data) {
+		t.Fatal("无需缩小的动图应原样返回")
+	}
+
+	out, ok = animatedGIFVariant(data, variantSpec{Width: 20})
+	if !ok {
+		t.Fatal("动图应由 animatedGIFVariant 处理")
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("缩放后的动图无法解码: %v", err)
+	}
+	if len(g.Image) != 2 {
+		t.Fatalf("缩放后有 %d 帧，want 2", len(g.Image))
+	}
+	if g.Config.Width != 20 || g.Config.Height != 10 {
+		t.Errorf("画布 = %dx%d, want 20x10", g.Config.Width, g.Config.Height)
+	}
+	if b := g.Image[1].Bounds(); b != image.Rect(10, 5, 20, 10) {
+		t.Errorf("第二帧的区域 = %v, want (10,5)-(20,10)", b)
+	}
+	if g.Delay[0] != 10 || g.Delay[1] != 20 || g.Disposal[1] != gif.DisposalBackground || g.LoopCount != 3 {
+		t.Errorf("帧间隔、处置方式或循环次数没有保留: %v %v %d", g.Delay, g.Disposal, g.LoopCount)
+	}
+
+	if _, ok := animatedGIFVariant(testGIF(t), variantSpec{Width: 1}); ok {
+		t.Error("单帧 GIF 应交给普通的缩放流程")
+	}
+}
+
+func TestMobileSizePreset(t *testing.T) {
+	presets, err := parseSizePresets(`{"Mobile":{"w":1080,"h":1920},"desktop":{"w":1920,"h":1080}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(p map[string]sizePreset) { sizePresets = p }(sizePresets)
+	sizePresets = presets
+
+	spec := func(target string) variantSpec {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		s, err := parseVariantSpec(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := r.URL.Query()
+		filter, err := parseImageFilter(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return applySizePreset(s, filter.Tags, q)
+	}
+
+	// 配置中的标签按规范化后的形式匹配
+	mobile := spec("/random-image?tag=MOBILE")
+	if mobile.Width != 1080 || mobile.Height != 1920 {
+		t.Fatalf("mobile 预设 = %dx%d，期望 1080x1920", mobile.Width, mobile.Height)
+	}
+	// 横图、竖图都被缩小到竖屏框内，且保持比例
+	for _, c := range []struct{ w, h, wantW, wantH int }{
+		{2400, 1200, 1080, 540},
+		{1000, 4000, 480, 1920},
+		{800, 600, 800, 600},
+	} {
+		got := fitWithin(image.NewRGBA(image.Rect(0, 0, c.w, c.h)), mobile.Width, mobile.Height).Bounds()
+		if got.Dx() != c.wantW || got.Dy() != c.wantH {
+			t.Errorf("%dx%d 缩放后为 %dx%d，期望 %dx%d", c.w, c.h, got.Dx(), got.Dy(), c.wantW, c.wantH)
+		}
+	}
+
+	if s := spec("/random-image?tag=mobile&w=300"); s.Width != 300 || s.Height != 0 {
+		t.Errorf("显式 w 应优先于预设，得到 %dx%d", s.Width, s.Height)
+	}
+	if s := spec("/random-image?tag=cats"); s.active() {
+		t.Errorf("没有预设的标签不应缩放，得到 %dx%d", s.Width, s.Height)
+	}
+	if s := spec("/random-image?tag=cats&tag=desktop&tag=mobile"); s.Width != 1920 || s.Height != 1080 {
+		t.Errorf("应使用第一个配置了预设的标签，得到 %dx%d", s.Width, s.Height)
+	}
+}
+
+func TestParseSizePresetsRejectsInvalid(t *testing.T) {
+	for _, v := range []string{
+		`[1080,1920]`,
+		`{"mobile":{"w":0,"h":0}}`,
+		`{"mobile":{"w":-1,"h":1920}}`,
+		`{"mobile":{"w":1080,"h":99999}}`,
+	} {
+		if _, err := parseSizePresets(v); err == nil {
+			t.Errorf("parseSizePresets(%s) 应返回错误", v)
+		}
+	}
+	if p, err := parseSizePresets(""); err != nil || p != nil {
+		t.Errorf("空配置应返回 nil，得到 %v, %v", p, err)
+	}
+}
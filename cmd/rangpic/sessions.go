@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// --- 会话存储 ---
+
+// 会话保存在 sessions 表中，服务重启或多实例部署时管理员无需重新登录
+
+// sessionLifetime 同时用于 cookie 过期时间和数据库中的 expires_at，避免两者不一致。
+// 默认 12 小时，可以通过 SESSION_TTL 修改
+var sessionLifetime = 12 * time.Hour
+
+// parseSessionTTL 解析 SESSION_TTL，为空时返回默认的 sessionLifetime，格式错误或不为正时返回错误
+func parseSessionTTL(v string) (time.Duration, error) {
+	if v == "" {
+		return sessionLifetime, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("SESSION_TTL 必须是时间间隔（如 30m、24h）: %w", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("SESSION_TTL 必须为正，当前为 %s", v)
+	}
+	return d, nil
+}
+
+// createSession 生成新的会话令牌和该会话的 CSRF 令牌并写入数据库，返回会话令牌及其过期时间
+func createSession(ctx context.Context) (string, time.Time, error) {
+	token := uuid.NewString()
+	expiresAt := time.Now().Add(sessionLifetime)
+	_, err := dbpool.Exec(ctx, "INSERT INTO sessions (token, csrf_token, expires_at) VALUES ($1, $2, $3)", token, uuid.NewString(), expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// lookupSession 判断令牌是否存在且未过期，有效时返回该会话的 CSRF 令牌。
+// 访问到已过期的令牌时顺便删除；升级前创建、没有 CSRF 令牌的会话视为无效，需要重新登录
+func lookupSession(ctx context.Context, token string) (csrf string, ok bool, err error) {
+	var expiresAt time.Time
+	err = dbpool.QueryRow(ctx, "SELECT COALESCE(csrf_token, ''), expires_at FROM sessions WHERE token=$1", token).Scan(&csrf, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if time.Now().After(expiresAt) || csrf == "" {
+		if err := deleteSession(ctx, token); err != nil {
+			slog.Error("删除过期会话失败", "err", err)
+		}
+		return "", false, nil
+	}
+	return csrf, true, nil
+}
+
+func deleteSession(ctx context.Context, token string) error {
+	_, err := dbpool.Exec(ctx, "DELETE FROM sessions WHERE token=$1", token)
+	return err
+}
+
+// startSessionCleaner 定期清理已过期的会话记录
+func startSessionCleaner(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			tag, err := dbpool.Exec(ctx, "DELETE FROM sessions WHERE expires_at <= now()")
+			if err != nil {
+				slog.Error("清理过期会话失败", "err", err)
+			} else if n := tag.RowsAffected(); n > 0 {
+				slog.Info("已清理过期会话", "count", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
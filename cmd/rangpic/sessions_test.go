@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// sessionCookie 从响应中取出 session_token cookie
+func sessionCookie(t *testing.T, rec *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session_token" {
+			return c
+		}
+	}
+	t.Fatalf("响应没有设置 session_token cookie: %v", rec.Header()["Set-Cookie"])
+	return nil
+}
+
+func checkSessionCookieAttrs(t *testing.T, c *http.Cookie, secure bool) {
+	t.Helper()
+	if !c.HttpOnly {
+		t.Error("cookie 缺少 HttpOnly")
+	}
+	if c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("SameSite = %v, want Lax", c.SameSite)
+	}
+	if c.Secure != secure {
+		t.Errorf("Secure = %v, want %v", c.Secure, secure)
+	}
+	if c.Path != "/" {
+		t.Errorf("Path = %q, want /", c.Path)
+	}
+}
+
+func TestLoginSetsSessionCookieAttributes(t *testing.T) {
+	testDB(t)
+	defer func(u, p string, h []byte) { adminUsername, adminPassword, adminPassHash = u, p, h }(adminUsername, adminPassword, adminPassHash)
+	adminUsername, adminPassword, adminPassHash = "admin", "secret", nil
+	defer func(v bool) { cookieSecure = v }(cookieSecure)
+
+	for _, secure := range []bool{false, true} {
+		cookieSecure = secure
+		rec := httptest.NewRecorder()
+		adminLoginHandler(rec, postForm("/admin/login", url.Values{"username": {"admin"}, "password": {"secret"}}))
+		if rec.Code != http.StatusFound {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+		}
+		c := sessionCookie(t, rec)
+		if c.Value == "" {
+			t.Error("登录后 session_token 不应为空")
+		}
+		checkSessionCookieAttrs(t, c, secure)
+	}
+}
+
+func TestLogoutClearsSessionCookie(t *testing.T) {
+	defer func(v bool) { cookieSecure = v }(cookieSecure)
+	for _, secure := range []bool{false, true} {
+		cookieSecure = secure
+		// 不带会话 cookie 时不会访问数据库，只检查清除 cookie 的属性
+		rec := httptest.NewRecorder()
+		adminLogoutHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/logout", nil))
+		c := sessionCookie(t, rec)
+		if c.Value != "" || c.MaxAge >= 0 {
+			t.Errorf("退出登录应清除 cookie，got Value=%q MaxAge=%d", c.Value, c.MaxAge)
+		}
+		checkSessionCookieAttrs(t, c, secure)
+	}
+}
+
+func TestParseSessionTTL(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", sessionLifetime, false},
+		{"30m", 30 * time.Minute, false},
+		{"24h", 24 * time.Hour, false},
+		{"12", 0, true},
+		{"forever", 0, true},
+		{"0s", 0, true},
+		{"-1h", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSessionTTL(tt.v)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSessionTTL(%q) = %v, %v; want %v, err=%v", tt.v, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
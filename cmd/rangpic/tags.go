@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// --- 标签管理 ---
+
+// TagsPageData 是标签管理页面的数据
+type TagsPageData struct {
+	Tags      []TagCount
+	Flash     string
+	CSRFToken string
+}
+
+// normalizeTag 把标签规范为小写，去掉首尾空白并把内部连续的空白合并为一个空格，
+// 避免 Desktop、"desktop " 和 desktop 被存为不同的标签
+func normalizeTag(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// normalizeTags 规范化每个标签，去掉空标签和重复的标签，保留每个标签第一次出现的位置
+func normalizeTags(raw []string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, t := range raw {
+		t = normalizeTag(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// normalizeStoredTags 用 normalizeTags 规范化数据库中所有图片（包括回收站中的）的标签，
+// 只更新标签有变化的图片，返回更新的数量。整个过程在一个事务中完成
+func normalizeStoredTags(ctx context.Context) (int, error) {
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "SELECT id, COALESCE(tags, '{}') FROM images ORDER BY id FOR UPDATE")
+	if err != nil {
+		return 0, fmt.Errorf("查询图片失败: %w", err)
+	}
+	changed := make(map[int][]string)
+	for rows.Next() {
+		var id int
+		var tags []string
+		if err := rows.Scan(&id, &tags); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if normalized := normalizeTags(tags); !slices.Equal(normalized, tags) {
+			changed[id] = normalized
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, tags := range changed {
+		if tags == nil {
+			tags = []string{}
+		}
+		if _, err := tx.Exec(ctx, "UPDATE images SET tags = $1 WHERE id = $2", tags, id); err != nil {
+			return 0, fmt.Errorf("更新图片 %d 的标签失败: %w", id, err)
+		}
+	}
+	return len(changed), tx.Commit(ctx)
+}
+
+// dedupeTagsExpr 去掉数组中的重复标签，保留每个标签第一次出现的位置
+const dedupeTagsExpr = `ARRAY(SELECT t FROM unnest(%s) WITH ORDINALITY AS u(t, n) GROUP BY t ORDER BY MIN(n))`
+
+// renameTagExpr 把 tags 中与 $1 不区分大小写相同的标签都替换为 $2，其余标签保持原样和原顺序
+const renameTagExpr = `ARRAY(SELECT CASE WHEN LOWER(t) = LOWER($1::text) THEN $2::text ELSE t END FROM unnest(tags) WITH ORDINALITY AS u(t, n) ORDER BY n)`
+
+// adminTagsHandler 列出所有标签及其图片数量
+func adminTagsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := dbpool.Query(r.Context(), `SELECT unnest(tags) AS tag, COUNT(*) AS count FROM images WHERE deleted_at IS NULL GROUP BY tag ORDER BY tag`)
+	if err != nil {
+		http.Error(w, "无法获取标签列表", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	data := TagsPageData{Flash: popFlash(w, r), CSRFToken: csrfToken(r)}
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			continue
+		}
+		data.Tags = append(data.Tags, tc)
+	}
+	render(w, "tags.html", data)
+}
+
+// adminRenameTagHandler 在所有图片上把标签 from 改名为 to，from 不区分大小写，Desktop、DESKTOP 等写法一并改名。
+// 目标标签已被使用时必须勾选 merge。改名后同一图片上重复的标签总是会被去掉
+func adminRenameTagHandler(w http.ResponseWriter, r *http.Request) {
+	// from 不做规范化，只去掉首尾空白，以便改名内部空白不规范的旧标签
+	from := strings.TrimSpace(r.FormValue("from"))
+	to := normalizeTag(r.FormValue("to"))
+	merge := r.FormValue("merge") != ""
+	if from == "" || to == "" {
+		http.Error(w, "标签名不能为空", http.StatusBadRequest)
+		return
+	}
+	if from == to {
+		http.Redirect(w, r, "/admin/tags", http.StatusFound)
+		return
+	}
+
+	if !merge {
+		// from 的各种大小写写法本身不算目标标签已存在，把 Desktop 改为 desktop 不需要合并
+		var exists bool
+		if err := dbpool.QueryRow(r.Context(), "SELECT EXISTS (SELECT 1 FROM images, unnest(tags) AS t WHERE LOWER(t) = $1 AND LOWER(t) <> LOWER($2))", to, from).Scan(&exists); err != nil {
+			http.Error(w, "无法查询标签", http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			http.Error(w, fmt.Sprintf("标签 %q 已存在，如需合并请勾选“合并”", to), http.StatusConflict)
+			return
+		}
+	}
+
+	query := "UPDATE images SET tags = " + fmt.Sprintf(dedupeTagsExpr, renameTagExpr) + " WHERE LOWER($1) = ANY(" + lowerTagsExpr + ")"
+	tag, err := dbpool.Exec(r.Context(), query, from, to)
+	if err != nil {
+		requestLogger(r).Error("重命名标签失败", "from", from, "to", to, "err", err)
+		http.Error(w, "重命名标签失败", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("已重命名标签", "from", from, "to", to, "merge", merge, "rows", tag.RowsAffected())
+	setFlash(w, fmt.Sprintf("已将 %d 张图片的标签 %q 改为 %q", tag.RowsAffected(), from, to))
+	http.Redirect(w, r, "/admin/tags", http.StatusFound)
+}
+
+// adminDeleteTagHandler 从所有图片上去掉一个标签。失去全部标签的图片保留为空数组，仍可被无过滤条件的请求返回
+func adminDeleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("tag"))
+	if name == "" {
+		http.Error(w, "标签名不能为空", http.StatusBadRequest)
+		return
+	}
+	tag, err := dbpool.Exec(r.Context(), "UPDATE images SET tags = COALESCE(array_remove(tags, $1), '{}') WHERE $1 = ANY(tags)", name)
+	if err != nil {
+		requestLogger(r).Error("删除标签失败", "tag", name, "err", err)
+		http.Error(w, "删除标签失败", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("已删除标签", "tag", name, "rows", tag.RowsAffected())
+	setFlash(w, fmt.Sprintf("已从 %d 张图片上删除标签 %q", tag.RowsAffected(), name))
+	http.Redirect(w, r, "/admin/tags", http.StatusFound)
+}
+
+// adminBulkTagHandler 为仪表盘上勾选的图片批量添加（op=add）或移除（op=remove）一个标签。
+// 添加时跳过已有该标签（不区分大小写）的图片，不会产生重复标签
+func adminBulkTagHandler(w http.ResponseWriter, r *http.Request) {
+	ids := formIDs(r)
+	name := strings.TrimSpace(r.FormValue("tag"))
+	op := r.FormValue("op")
+	if op == "add" {
+		name = normalizeTag(name)
+	}
+	if name == "" {
+		http.Error(w, "标签名不能为空", http.StatusBadRequest)
+		return
+	}
+	if len(ids) == 0 {
+		setFlash(w, "未选择图片")
+		redirectBack(w, r)
+		return
+	}
+
+	var query, msg string
+	switch op {
+	case "add":
+		query = "UPDATE images SET tags = array_append(COALESCE(tags, '{}'), $1) WHERE id = ANY($2) AND deleted_at IS NULL AND NOT ($1 = ANY(" + lowerTagsExpr + "))"
+		msg = "已为 %d 张图片添加标签 %q（共选中 %d 张）"
+	case "remove":
+		query = "UPDATE images SET tags = array_remove(tags, $1) WHERE id = ANY($2) AND deleted_at IS NULL AND $1 = ANY(tags)"
+		msg = "已从 %d 张图片上移除标签 %q（共选中 %d 张）"
+	default:
+		http.Error(w, "op 只能是 add 或 remove", http.StatusBadRequest)
+		return
+	}
+	tag, err := dbpool.Exec(r.Context(), query, name, ids)
+	if err != nil {
+		requestLogger(r).Error("批量修改标签失败", "op", op, "tag", name, "err", err)
+		http.Error(w, "批量修改标签失败", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("已批量修改标签", "op", op, "tag", name, "selected", len(ids), "rows", tag.RowsAffected())
+	setFlash(w, fmt.Sprintf(msg, tag.RowsAffected(), name, len(ids)))
+	redirectBack(w, r)
+}
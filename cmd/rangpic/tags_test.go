@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// postForm 构造一个表单 POST 请求
+func postForm(target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// flashMessage 返回响应通过 setFlash 设置的提示消息
+func flashMessage(rec *httptest.ResponseRecorder) string {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "flash" {
+			msg, _ := url.QueryUnescape(c.Value)
+			return msg
+		}
+	}
+	return ""
+}
+
+// tagsOf 返回图片当前的标签
+func tagsOf(t *testing.T, id int) []string {
+	t.Helper()
+	var tags []string
+	if err := dbpool.QueryRow(context.Background(), "SELECT tags FROM images WHERE id = $1", id).Scan(&tags); err != nil {
+		t.Fatal(err)
+	}
+	return tags
+}
+
+func TestRenameTagMatchesCaseInsensitively(t *testing.T) {
+	testDB(t)
+	a := insertTestImage(t, "https://example.com/a.jpg", "Desktop", "nature")
+	b := insertTestImage(t, "https://example.com/b.jpg", "desktop")
+	c := insertTestImage(t, "https://example.com/c.jpg", "wallpaper", "DESKTOP")
+	d := insertTestImage(t, "https://example.com/d.jpg", "nature")
+
+	rec := httptest.NewRecorder()
+	adminRenameTagHandler(rec, postForm("/admin/tags/rename", url.Values{"from": {"desktop"}, "to": {"PC"}}))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("改名失败: %d %s", rec.Code, rec.Body)
+	}
+	want := map[int][]string{a: {"pc", "nature"}, b: {"pc"}, c: {"wallpaper", "pc"}, d: {"nature"}}
+	for id, tags := range want {
+		if got := tagsOf(t, id); !reflect.DeepEqual(got, tags) {
+			t.Errorf("图片 %d: got %q, want %q", id, got, tags)
+		}
+	}
+}
+
+func TestRenameTagConflictAndMerge(t *testing.T) {
+	testDB(t)
+	both := insertTestImage(t, "https://example.com/both.jpg", "a", "x", "b")
+	onlyA := insertTestImage(t, "https://example.com/a.jpg", "A")
+
+	rec := httptest.NewRecorder()
+	adminRenameTagHandler(rec, postForm("/admin/tags/rename", url.Values{"from": {"a"}, "to": {"b"}}))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("目标标签已存在且未勾选合并时应返回 409，got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	adminRenameTagHandler(rec, postForm("/admin/tags/rename", url.Values{"from": {"a"}, "to": {"b"}, "merge": {"1"}}))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("合并失败: %d %s", rec.Code, rec.Body)
+	}
+	if got := tagsOf(t, both); !reflect.DeepEqual(got, []string{"b", "x"}) {
+		t.Errorf("合并后不应有重复的 b，got %q", got)
+	}
+	if got := tagsOf(t, onlyA); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("got %q, want [b]", got)
+	}
+
+	// 只改大小写不算与已有标签冲突
+	insertTestImage(t, "https://example.com/upper.jpg", "Nature")
+	rec = httptest.NewRecorder()
+	adminRenameTagHandler(rec, postForm("/admin/tags/rename", url.Values{"from": {"Nature"}, "to": {"nature"}}))
+	if rec.Code != http.StatusFound {
+		t.Errorf("把 Nature 规范为 nature 不需要合并，got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDeleteTag(t *testing.T) {
+	testDB(t)
+	a := insertTestImage(t, "https://example.com/a.jpg", "old", "nature")
+	b := insertTestImage(t, "https://example.com/b.jpg", "old")
+	c := insertTestImage(t, "https://example.com/c.jpg", "nature")
+
+	rec := httptest.NewRecorder()
+	adminDeleteTagHandler(rec, postForm("/admin/tags/delete", url.Values{"tag": {"old"}}))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("删除标签失败: %d %s", rec.Code, rec.Body)
+	}
+	want := map[int][]string{a: {"nature"}, b: {}, c: {"nature"}}
+	for id, tags := range want {
+		if got := tagsOf(t, id); got == nil || !reflect.DeepEqual(got, tags) {
+			t.Errorf("图片 %d: got %#v, want %q（失去全部标签时应为空数组而不是 NULL）", id, got, tags)
+		}
+	}
+	if msg := flashMessage(rec); !strings.Contains(msg, "从 2 张图片") {
+		t.Errorf("提示信息应包含受影响的图片数 2，got %q", msg)
+	}
+}
+
+func TestBulkTagAddSkipsExisting(t *testing.T) {
+	testDB(t)
+	has := insertTestImage(t, "https://example.com/a.jpg", "cat")
+	missing := insertTestImage(t, "https://example.com/b.jpg", "dog")
+	// 规范化之前保存的大写标签同样视为已有
+	legacy := insertTestImage(t, "https://example.com/c.jpg", "Cat")
+
+	form := url.Values{"op": {"add"}, "tag": {" Cat "}, "id": {strconv.Itoa(has), strconv.Itoa(missing), strconv.Itoa(legacy)}}
+	rec := httptest.NewRecorder()
+	adminBulkTagHandler(rec, postForm("/admin/tags/bulk", form))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	if msg := flashMessage(rec); !strings.Contains(msg, "已为 1 张图片添加标签") || !strings.Contains(msg, "共选中 3 张") {
+		t.Errorf("flash = %q", msg)
+	}
+	for id, want := range map[int]string{has: "cat", missing: "dog,cat", legacy: "Cat"} {
+		if got := strings.Join(tagsOf(t, id), ","); got != want {
+			t.Errorf("图片 %d 的标签 = %q, want %q", id, got, want)
+		}
+	}
+
+	// 再次添加不会修改任何图片
+	rec = httptest.NewRecorder()
+	adminBulkTagHandler(rec, postForm("/admin/tags/bulk", form))
+	if msg := flashMessage(rec); !strings.Contains(msg, "已为 0 张图片添加标签") {
+		t.Errorf("重复添加时 flash = %q", msg)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := map[string]string{
+		"cat":    "cat",
+		"100%":   `100\%`,
+		"a_b":    `a\_b`,
+		`c:\dir`: `c:\\dir`,
+		`%_\`:    `\%\_\\`,
+		"中文标签":   "中文标签",
+	}
+	for in, want := range tests {
+		if got := escapeLike(in); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTagSuggest(t *testing.T) {
+	testDB(t)
+	// cat 以 q 开头，排在只包含 q 的 bobcat 之前，尽管 bobcat 使用次数更多
+	insertTestImage(t, "https://example.com/1.jpg", "bobcat")
+	insertTestImage(t, "https://example.com/2.jpg", "bobcat")
+	insertTestImage(t, "https://example.com/3.jpg", "cat", "100%", "a_b")
+	insertTestImage(t, "https://example.com/4.jpg", "dog", "1000", "axb")
+
+	suggest := func(q string) []string {
+		rec := httptest.NewRecorder()
+		tagSuggestAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/tags/suggest?q="+url.QueryEscape(q), nil))
+		var tags []string
+		if err := json.Unmarshal(rec.Body.Bytes(), &tags); err != nil {
+			t.Fatalf("q=%q: %v", q, err)
+		}
+		return tags
+	}
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"CAT", []string{"cat", "bobcat"}},
+		{"bob", []string{"bobcat"}},
+		{"100%", []string{"100%"}},
+		{"a_b", []string{"a_b"}},
+		{"zzz", []string{}},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("q=%q: got %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	tests := map[string]string{
+		"Desktop":        "desktop",
+		"  desktop ":     "desktop",
+		"Dark   Mode":    "dark mode",
+		"\tdark\n mode　": "dark mode",
+		"":               "",
+		"   ":            "",
+		"ÉTÉ":            "été",
+	}
+	for in, want := range tests {
+		if got := normalizeTag(in); got != want {
+			t.Errorf("normalizeTag(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{"Desktop", "nature", " desktop ", "", "NATURE", "dark  mode", "Dark Mode"})
+	if want := []string{"desktop", "nature", "dark mode"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeTags = %q, want %q", got, want)
+	}
+	if got := normalizeTags([]string{" ", ""}); len(got) != 0 {
+		t.Errorf("全部为空时应返回空列表，got %q", got)
+	}
+}
+
+func TestNormalizeStoredTags(t *testing.T) {
+	testDB(t)
+	messy := insertTestImage(t, "https://example.com/a.jpg", "Desktop", " desktop", "Dark  Mode")
+	clean := insertTestImage(t, "https://example.com/b.jpg", "nature")
+	n, err := normalizeStoredTags(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("normalizeStoredTags = %d, %v, want 1", n, err)
+	}
+	if got := tagsOf(t, messy); !reflect.DeepEqual(got, []string{"desktop", "dark mode"}) {
+		t.Errorf("规范化后的标签 = %q", got)
+	}
+	if got := tagsOf(t, clean); !reflect.DeepEqual(got, []string{"nature"}) {
+		t.Errorf("已规范的标签不应改变，got %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- 模板加载 ---
+
+// TemplateProvider 提供后台页面使用的模板集合
+type TemplateProvider interface {
+	Templates() (*template.Template, error)
+}
+
+// templateProvider 在启动时由 initTemplates 设置
+var templateProvider TemplateProvider
+
+// embeddedTemplates 是编译进程序的页面模板，每个字符串以 {{define "名称.html"}} 定义一个页面
+var embeddedTemplates = []string{
+	loginTemplate,
+	dashboardTemplate,
+	editTemplate,
+	localFilesTemplate,
+	bulkDownloadTemplate,
+	placeholdersTemplate,
+	statsTemplate,
+	tagsTemplate,
+	trashTemplate,
+	auditTemplate,
+	duplicatesTemplate,
+}
+
+// parseTemplates 解析内置模板，再用 dir 下的 *.html 文件覆盖同名页面（文件名即模板名，如 dashboard.html）。
+// dir 为空或不存在时只使用内置模板
+func parseTemplates(dir string) (*template.Template, error) {
+	t := template.New("").Funcs(template.FuncMap{
+		"join":    strings.Join,
+		"add":     func(a, b int) int { return a + b },
+		"sub":     func(a, b int) int { return a - b },
+		"timeAgo": timeAgo,
+	})
+	for _, src := range embeddedTemplates {
+		if _, err := t.Parse(src); err != nil {
+			return nil, err
+		}
+	}
+	if dir == "" {
+		return t, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := t.New(filepath.Base(file)).Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("解析模板文件 %s 失败: %w", file, err)
+		}
+	}
+	return t, nil
+}
+
+// cachedTemplates 在创建时解析一次模板，之后一直复用，用于生产环境
+type cachedTemplates struct {
+	t *template.Template
+}
+
+func (c *cachedTemplates) Templates() (*template.Template, error) {
+	return c.t, nil
+}
+
+// devTemplates 每次渲染都重新解析模板，修改 TEMPLATE_DIR 中的文件后刷新页面即可看到效果
+type devTemplates struct {
+	dir string
+}
+
+func (d *devTemplates) Templates() (*template.Template, error) {
+	return parseTemplates(d.dir)
+}
+
+// initTemplates 根据 DEV_MODE 选择模板的加载方式。非开发模式下模板有错误时直接退出
+func initTemplates(devMode bool, dir string) {
+	if devMode {
+		slog.Info("开发模式：每次请求都会重新解析模板", "dir", dir)
+		templateProvider = &devTemplates{dir: dir}
+		return
+	}
+	t, err := parseTemplates(dir)
+	if err != nil {
+		fatal("解析模板失败", "err", err)
+	}
+	templateProvider = &cachedTemplates{t: t}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// staticTemplates 是测试用的 TemplateProvider，直接返回给定的模板或错误
+type staticTemplates struct {
+	t   *template.Template
+	err error
+}
+
+func (s staticTemplates) Templates() (*template.Template, error) {
+	return s.t, s.err
+}
+
+func TestRenderBrokenTemplate(t *testing.T) {
+	defer func(p TemplateProvider) { templateProvider = p }(templateProvider)
+	// 模板先输出一段内容，执行到越界的 index 时才出错
+	broken := template.Must(template.New("").Parse(`{{define "broken.html"}}<p>partial</p>{{index .Items 5}}{{end}}`))
+
+	tests := []struct {
+		name     string
+		provider TemplateProvider
+	}{
+		{"执行出错", staticTemplates{t: broken}},
+		{"加载出错", staticTemplates{err: errors.New("模板语法错误")}},
+		{"模板文件解析出错", &devTemplates{dir: brokenTemplateDir(t)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templateProvider = tt.provider
+			rec := httptest.NewRecorder()
+			render(rec, "broken.html", map[string][]int{"Items": {1}})
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+			}
+			if body := rec.Body.String(); strings.Contains(body, "partial") || !strings.Contains(body, "页面渲染失败") {
+				t.Errorf("出错时不应输出部分页面，body = %q", body)
+			}
+			if ct := rec.Header().Get("Content-Type"); strings.HasPrefix(ct, "text/html") {
+				t.Errorf("出错时 Content-Type 不应为 HTML，got %q", ct)
+			}
+		})
+	}
+}
+
+// brokenTemplateDir 返回一个包含语法错误模板文件的目录
+func brokenTemplateDir(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.html"), []byte(`{{if}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestParseTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "login.html"), []byte(`custom login`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := parseTemplates(dir)
+	if err != nil {
+		t.Fatalf("parseTemplates: %v", err)
+	}
+	var sb strings.Builder
+	if err := tmpl.ExecuteTemplate(&sb, "login.html", nil); err != nil || sb.String() != "custom login" {
+		t.Errorf("目录中的同名文件应覆盖内置模板，got %q, %v", sb.String(), err)
+	}
+	if tmpl.Lookup("dashboard.html") == nil {
+		t.Error("未覆盖的内置模板应保留")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// --- 本地图片缩略图 ---
+
+// thumbsDirName 是缩略图缓存目录，位于本地图片目录下，以点开头因此不会出现在素材列表中
+const thumbsDirName = ".thumbs"
+
+// listThumbnailWidth 是素材库列表中预览缩略图的宽度
+const listThumbnailWidth = 150
+
+// localThumbHandler 处理 /local/thumb/<name>，返回本地文件的缩略图；
+// 源文件格式无法解码时退回原图
+func localThumbHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/local/thumb/")
+	srcPath, err := safeLocalPath(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	thumbPath, err := ensureThumbnail(name, listThumbnailWidth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		serveLocalFile(w, r, srcPath)
+		return
+	}
+	serveLocalFile(w, r, thumbPath)
+}
+
+// thumbnailPath 返回本地文件在指定宽度下的缩略图缓存路径，路径落在该宽度的缓存目录之外时返回错误
+func thumbnailPath(name string, width int) (string, error) {
+	dir := filepath.Join(localImagesPath, thumbsDirName, strconv.Itoa(width))
+	p := filepath.Join(dir, filepath.FromSlash(name)+".jpg")
+	rel, err := filepath.Rel(dir, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("无效的文件名: %q", name)
+	}
+	return p, nil
+}
+
+// ensureThumbnail 确保本地文件存在指定宽度的 JPEG 缩略图并返回其路径。
+// 缓存比源文件新时直接复用，否则重新生成；无法解码的格式返回错误。
+// name 来自数据库中的 URL 或请求路径，源文件和缩略图路径都经过校验，不会读写素材库之外的文件
+func ensureThumbnail(name string, width int) (string, error) {
+	srcPath, err := safeLocalPath(name)
+	if err != nil {
+		return "", err
+	}
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return "", err
+	}
+	thumbPath, err := thumbnailPath(name, width)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(thumbPath); err == nil && !info.ModTime().Before(srcInfo.ModTime()) {
+		return thumbPath, nil
+	}
+
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return "", err
+	}
+	src, err := decodeImage(data)
+	if err != nil {
+		return "", fmt.Errorf("无法解码 %s: %w", name, err)
+	}
+
+	b := src.Bounds()
+	dst := image.Image(src)
+	if b.Dx() > width {
+		height := max(1, b.Dy()*width/b.Dx())
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, b, draw.Src, nil)
+		dst = scaled
+	}
+
+	if err := os.MkdirAll(filepath.Dir(thumbPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	// 先写临时文件再改名，避免并发请求读到写了一半的缩略图
+	tmp, err := os.CreateTemp(filepath.Dir(thumbPath), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := jpeg.Encode(tmp, dst, &jpeg.Options{Quality: 80}); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), thumbPath); err != nil {
+		return "", err
+	}
+	return thumbPath, nil
+}
+
+// warmupThumbnails 在后台为新加入的本地文件生成 THUMBNAIL_WARMUP_WIDTHS 中配置的各尺寸缩略图，
+// 失败只记录日志，不影响添加操作本身
+func warmupThumbnails(name string) {
+	for _, width := range thumbnailWarmupWidths {
+		enqueueJob(func(ctx context.Context) {
+			if _, err := ensureThumbnail(name, width); err != nil {
+				slog.Error("预生成缩略图失败", "file", name, "width", width, "err", err)
+			}
+		})
+	}
+}
+
+// parseWidthList 解析逗号分隔的宽度列表，如 "150,400"
+func parseWidthList(v string) ([]int, error) {
+	var widths []int
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 || n > 4096 {
+			return nil, fmt.Errorf("无效的宽度 %q", part)
+		}
+		widths = append(widths, n)
+	}
+	return widths, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnsureThumbnail(t *testing.T) {
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(localImagesPath, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(localImagesPath, "sub", "a.png"), testPNG(t, 40, 20, color.White), 0o644)
+
+	p, err := ensureThumbnail("sub/a.png", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(localImagesPath, thumbsDirName, "10", "sub", "a.png.jpg"); p != want {
+		t.Errorf("缩略图路径 = %s, want %s", p, want)
+	}
+	if _, err := os.Stat(p); err != nil {
+		t.Errorf("缩略图未生成: %v", err)
+	}
+}
+
+func TestEnsureThumbnailRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	defer func(p string) { localImagesPath = p }(localImagesPath)
+	localImagesPath = filepath.Join(root, "library")
+	if err := os.MkdirAll(localImagesPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// 素材库之外的图片不能被读取，也不能在缓存目录之外写出缩略图
+	os.WriteFile(filepath.Join(root, "secret.png"), testPNG(t, 40, 20, color.Black), 0o644)
+
+	for _, name := range []string{"../secret.png", "../../../secret.png", "/../secret.png", ".thumbs/10/x.png"} {
+		if _, err := ensureThumbnail(name, 10); err == nil {
+			t.Errorf("ensureThumbnail(%q) 应返回错误", name)
+		}
+	}
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err == nil && strings.HasSuffix(p, ".jpg") {
+			t.Errorf("不应生成缩略图: %s", p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateImageURLLocalPaths(t *testing.T) {
+	for _, u := range []string{"/local/a.png", "/local/sub/a.png"} {
+		if err := validateImageURL(u); err != nil {
+			t.Errorf("validateImageURL(%q) = %v", u, err)
+		}
+	}
+	for _, u := range []string{"/local/../secret.png", "/local/sub/../../x.png", "/local/", "/local/.thumbs/10/a.png.jpg", `/local/a\..\b.png`} {
+		if err := validateImageURL(u); err == nil {
+			t.Errorf("validateImageURL(%q) 应返回错误", u)
+		}
+	}
+}
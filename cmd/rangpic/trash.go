@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- 回收站 ---
+
+// trashRetention 是图片在回收站中保留的时间，超过后由后台任务永久删除
+const trashRetention = 30 * 24 * time.Hour
+
+// TrashedImage 是回收站中的一张图片及其删除时间
+type TrashedImage struct {
+	Image
+	DeletedAt time.Time
+}
+
+// TrashPageData 是回收站页面的数据
+type TrashPageData struct {
+	Images    []TrashedImage
+	Flash     string
+	CSRFToken string
+}
+
+// adminTrashHandler 列出已移入回收站的图片，最近删除的在前
+func adminTrashHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := dbpool.Query(r.Context(), "SELECT "+imageColumns+", deleted_at FROM images WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC")
+	if err != nil {
+		http.Error(w, "无法获取回收站", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	data := TrashPageData{Flash: popFlash(w, r), CSRFToken: csrfToken(r)}
+	for rows.Next() {
+		var t TrashedImage
+		if err := rows.Scan(append(imageScanDest(&t.Image), &t.DeletedAt)...); err != nil {
+			requestLogger(r).Error("扫描图片数据失败", "err", err)
+			continue
+		}
+		data.Images = append(data.Images, t)
+	}
+	render(w, "trash.html", data)
+}
+
+// formIDs 读取表单中的全部 id 值，非数字的值直接忽略
+func formIDs(r *http.Request) []int {
+	r.ParseForm()
+	var ids []int
+	for _, v := range r.Form["id"] {
+		if id, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// adminRestoreImageHandler 把回收站中的图片恢复到图库
+func adminRestoreImageHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := dbpool.Exec(r.Context(), "UPDATE images SET deleted_at = NULL WHERE id = ANY($1) AND deleted_at IS NOT NULL", formIDs(r))
+	if err != nil {
+		http.Error(w, "恢复图片失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	refreshWeightsInUse(r.Context())
+	setFlash(w, fmt.Sprintf("已恢复 %d 张图片", tag.RowsAffected()))
+	http.Redirect(w, r, "/admin/trash", http.StatusFound)
+}
+
+// adminPurgeImageHandler 永久删除回收站中的图片，只作用于已移入回收站的图片
+func adminPurgeImageHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := dbpool.Exec(r.Context(), "DELETE FROM images WHERE id = ANY($1) AND deleted_at IS NOT NULL", formIDs(r))
+	if err != nil {
+		http.Error(w, "删除图片失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setFlash(w, fmt.Sprintf("已永久删除 %d 张图片", tag.RowsAffected()))
+	http.Redirect(w, r, "/admin/trash", http.StatusFound)
+}
+
+// startTrashPurger 定期永久删除在回收站中超过 trashRetention 的图片
+func startTrashPurger(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			tag, err := dbpool.Exec(ctx, "DELETE FROM images WHERE deleted_at < $1", time.Now().Add(-trashRetention))
+			if err != nil {
+				slog.Error("清理回收站失败", "err", err)
+			} else if n := tag.RowsAffected(); n > 0 {
+				slog.Info("已永久删除回收站中过期的图片", "count", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
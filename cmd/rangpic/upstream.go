@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// --- 上游请求重试 ---
+
+// upstreamRetries 是请求图床失败后的最多重试次数，由 UPSTREAM_RETRIES 设置，0 表示不重试
+var upstreamRetries int
+
+// upstreamRetryBackoff 是第一次重试前的等待时间，之后每次翻倍
+const upstreamRetryBackoff = 200 * time.Millisecond
+
+// getWithRetry 用 client 发送 GET 请求，遇到连接错误或 5xx 时按指数退避重试至多 upstreamRetries 次。
+// 超时、4xx 和被拒绝的跳转不重试：前者重试只会让客户端等得更久，后两者重试也不会有不同的结果
+func getWithRetry(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	return getWithRetryHeader(ctx, client, rawURL, nil)
+}
+
+// getWithRetryHeader 与 getWithRetry 相同，但每次请求都带上 header 中的请求头（如 Range）
+func getWithRetryHeader(ctx context.Context, client *http.Client, rawURL string, header http.Header) (*http.Response, error) {
+	backoff := upstreamRetryBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := client.Do(req)
+		if attempt >= upstreamRetries || !retryableUpstreamFailure(ctx, resp, err) {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("状态码 %d", resp.StatusCode)
+		}
+		slog.Warn("请求图床失败，稍后重试", "url", rawURL, "attempt", attempt+1, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryableUpstreamFailure 判断一次请求的结果是否值得重试
+func retryableUpstreamFailure(ctx context.Context, resp *http.Response, err error) bool {
+	if err == nil {
+		return resp.StatusCode >= 500
+	}
+	if ctx.Err() != nil || errors.Is(err, errRedirectRejected) {
+		return false
+	}
+	var netErr net.Error
+	return !(errors.As(err, &netErr) && netErr.Timeout())
+}
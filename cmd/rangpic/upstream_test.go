@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGetWithRetryThenSuccess(t *testing.T) {
+	defer func(n int) { upstreamRetries = n }(upstreamRetries)
+	upstreamRetries = 2
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	resp, err := getWithRetry(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("两次 503 后应重试成功，got status %d after %d 次请求", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestGetWithRetryLimits(t *testing.T) {
+	defer func(n int) { upstreamRetries = n }(upstreamRetries)
+
+	tests := []struct {
+		name    string
+		retries int
+		status  int
+		calls   int32
+	}{
+		{"retries exhausted", 1, http.StatusBadGateway, 2},
+		{"no retries by default", 0, http.StatusBadGateway, 1},
+		{"4xx is not retried", 3, http.StatusNotFound, 1},
+	}
+	for _, tt := range tests {
+		upstreamRetries = tt.retries
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(tt.status)
+		}))
+		resp, err := getWithRetry(context.Background(), srv.Client(), srv.URL)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		srv.Close()
+		if resp.StatusCode != tt.status || calls.Load() != tt.calls {
+			t.Errorf("%s: got status %d after %d 次请求, want %d after %d", tt.name, resp.StatusCode, calls.Load(), tt.status, tt.calls)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// --- 浏览计数 ---
+
+// viewFlushInterval 是把内存中累计的浏览次数写回数据库的间隔
+const viewFlushInterval = 5 * time.Second
+
+var (
+	viewMu     sync.Mutex
+	viewCounts = make(map[int]int64)
+)
+
+// recordView 记录一次图片被返回。计数先累积在内存中，由后台任务批量写入，
+// 不会阻塞请求；写入失败时这批计数直接丢弃，浏览量只是尽力统计
+func recordView(id int) {
+	viewMu.Lock()
+	viewCounts[id]++
+	viewMu.Unlock()
+}
+
+// startViewFlusher 定期把累计的浏览次数写回数据库
+func startViewFlusher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(viewFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				flushViews(ctx)
+			}
+		}
+	}()
+}
+
+// flushViews 用一条 UPDATE 写入所有累计的浏览次数，退出前也会调用一次
+func flushViews(ctx context.Context) {
+	viewMu.Lock()
+	pending := viewCounts
+	viewCounts = make(map[int]int64)
+	viewMu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	ids := make([]int, 0, len(pending))
+	counts := make([]int64, 0, len(pending))
+	for id, n := range pending {
+		ids = append(ids, id)
+		counts = append(counts, n)
+	}
+	_, err := dbpool.Exec(ctx, `UPDATE images SET views = images.views + v.n
+		FROM (SELECT unnest($1::int[]) AS id, unnest($2::bigint[]) AS n) AS v
+		WHERE images.id = v.id`, ids, counts)
+	if err != nil {
+		slog.Warn("写入浏览次数失败，本批计数已丢弃", "images", len(ids), "err", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build cgo
+
+package main
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/chai2010/webp"
+)
+
+// webpSupported 表示当前构建能否编码 WebP，不支持时不根据 Accept 头自动转换
+const webpSupported = true
+
+// encodeWebP 将图片编码为有损 WebP，依赖 cgo 编译的 libwebp
+func encodeWebP(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, &webp.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,16 @@
+//go:build !cgo
+
+package main
+
+import (
+	"errors"
+	"image"
+)
+
+// webpSupported 表示当前构建能否编码 WebP，不支持时不根据 Accept 头自动转换
+const webpSupported = false
+
+// encodeWebP 在未启用 cgo 的构建中不可用，调用方会退回原图格式
+func encodeWebP(img image.Image) ([]byte, error) {
+	return nil, errors.New("当前构建未启用 cgo，不支持 WebP 编码")
+}
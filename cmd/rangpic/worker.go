@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// --- 后台任务队列 ---
+
+// jobQueue 承载不需要阻塞请求的耗时任务（如生成缩略图），由单个后台 goroutine 依次执行
+var jobQueue = make(chan func(context.Context), 256)
+
+func startJobWorker(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case job := <-jobQueue:
+				job(ctx)
+			}
+		}
+	}()
+}
+
+// enqueueJob 把任务放入队列，队列已满时丢弃任务并返回 false，调用方不会被阻塞
+func enqueueJob(job func(context.Context)) bool {
+	select {
+	case jobQueue <- job:
+		return true
+	default:
+		slog.Warn("后台任务队列已满，任务被丢弃")
+		return false
+	}
+}